@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/httpserver"
+)
+
+// A restarted process finds the listeners it inherits from fd 3 on, their
+// number in listenFDsEnv, and tells its parent it is serving by writing to
+// the pipe in readyFDEnv.
+const (
+	listenFDsEnv  = "HTTPSERVER_LISTEN_FDS"
+	readyFDEnv    = "HTTPSERVER_READY_FD"
+	firstListenFD = 3
+)
+
+// restartTimeout bounds how long the old process waits for the new one to
+// be ready before it gives up on it and keeps serving.
+const restartTimeout = 30 * time.Second
+
+// inheritedListeners returns the listeners handed over by the process that
+// restarted this one, or none if it wasn't started that way.
+func inheritedListeners() ([]net.Listener, error) {
+	defer os.Unsetenv(listenFDsEnv)
+	value := os.Getenv(listenFDsEnv)
+	if value == "" {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid %s %q", listenFDsEnv, value)
+	}
+	files := make([]*os.File, count)
+	for i := range files {
+		fd := firstListenFD + i
+		files[i] = os.NewFile(uintptr(fd), "listener"+strconv.Itoa(fd))
+	}
+	listeners, err := httpserver.FileListeners(files)
+	if err != nil {
+		return nil, fmt.Errorf("inherited listener %w", err)
+	}
+	return listeners, nil
+}
+
+// notifyReady tells the process that restarted this one, if any, that the
+// inherited listeners are being served, so it can stop serving them.
+func notifyReady() error {
+	defer os.Unsetenv(readyFDEnv)
+	value := os.Getenv(readyFDEnv)
+	if value == "" {
+		return nil
+	}
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", readyFDEnv, value)
+	}
+	pipe := os.NewFile(uintptr(fd), "ready")
+	defer pipe.Close()
+	_, err = pipe.Write([]byte{1})
+	return err
+}
+
+// restart starts a new process of the executable with the same arguments,
+// handing it listeners, and waits until it serves them. On success the
+// caller should stop serving and shut down; on failure the new process is
+// gone and the caller carries on.
+func restart(executable string, args []string, listeners []net.Listener) (pid int, err error) {
+	files := make([]*os.File, 0, len(listeners)+1)
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, ln := range listeners {
+		filer, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return 0, fmt.Errorf("can't hand over a %T listener", ln)
+		}
+		file, err := filer.File()
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, file)
+	}
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer ready.Close()
+	files = append(files, readyWriter)
+
+	cmd := exec.Command(executable, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(environWithout(listenFDsEnv, readyFDEnv),
+		listenFDsEnv+"="+strconv.Itoa(len(listeners)),
+		readyFDEnv+"="+strconv.Itoa(firstListenFD+len(listeners)))
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	// Only the new process holds the write end now, so the read below ends
+	// if it exits without getting ready
+	readyWriter.Close()
+	files = files[:len(files)-1]
+
+	result := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if n, _ := ready.Read(buf); n == 0 {
+			result <- errors.New("the new process exited before it was ready")
+			return
+		}
+		result <- nil
+	}()
+	select {
+	case err = <-result:
+	case <-time.After(restartTimeout):
+		err = fmt.Errorf("the new process wasn't ready within %v", restartTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+	// The new process outlives this one and is reaped by whoever adopts it
+	pid = cmd.Process.Pid
+	cmd.Process.Release()
+	return pid, nil
+}
+
+// environWithout returns the environment minus the variables in names.
+func environWithout(names ...string) []string {
+	var env []string
+outer:
+	for _, kv := range os.Environ() {
+		for _, name := range names {
+			if strings.HasPrefix(kv, name+"=") {
+				continue outer
+			}
+		}
+		env = append(env, kv)
+	}
+	return env
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func TestRestart_HandsOverListeners(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The new process checks it got the listener at fd 3 and reports ready
+	script := `test "$` + listenFDsEnv + `" = 1 && test -S /dev/fd/3 && printf x >&$` + readyFDEnv
+	pid, err := restart("/bin/sh", []string{"-c", script}, []net.Listener{ln})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid <= 0 || pid == os.Getpid() {
+		t.Errorf("Expected the pid of a new process, but got %d", pid)
+	}
+}
+
+func TestRestart_NewProcessFails(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if _, err := restart("/bin/sh", []string{"-c", "exit 1"}, []net.Listener{ln}); err == nil {
+		t.Errorf("Expected a new process exiting early to be an error")
+	}
+}
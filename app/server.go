@@ -1,299 +1,238 @@
 package main
 
 import (
-	"bytes"
-	"compress/gzip"
+	"context"
 	"flag"
 	"fmt"
+	"log"
 	"net"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
-)
+	"syscall"
+	"time"
 
-var directory string
+	"github.com/codecrafters-io/http-server-starter-go/httpserver"
+)
 
 func main() {
-	// Parse arguments
-	directory = parseArgs()
-
-	fmt.Printf("Starting server.. Serving files from directory: %s\n", directory)
-	ln, err := net.Listen("tcp", ":4221")
+	opts, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
+	fmt.Printf("Starting server.. Serving files from directory: %s\n", opts.config.Dir)
+	listeners, err := opts.listen()
 	if err != nil {
-		//panic(err)
 		fmt.Println("Error while staring a listener", err)
 		os.Exit(1)
 	}
-	defer closeListener(ln)
 
-	fmt.Println("TCP Server listening at 4221")
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			fmt.Println("Connection Error", err)
-		}
-		go handleConnection(conn)
+	for _, ln := range listeners {
+		fmt.Printf("Server listening at %s:%s\n", ln.Addr().Network(), ln.Addr())
 	}
-}
-
-func parseArgs() string {
-	dir := flag.String("directory", os.TempDir(), "Directory to serve files from")
-	flag.Parse()
-	return *dir
-}
-
-func closeListener(ln net.Listener) {
-	if err := ln.Close(); err != nil {
-		fmt.Println("Error while closing the listener")
+	served := make(chan error, 1)
+	go func() { served <- opts.server.ServeAll(listeners) }()
+	if err := notifyReady(); err != nil {
+		fmt.Println("Error while notifying the previous process", err)
 	}
-}
 
-func handleConnection(conn net.Conn) {
-	// Close the connection when the function returns
-	defer func(conn net.Conn) {
-		err := conn.Close()
-		if err != nil {
-			fmt.Println("Error closing connection", err)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
+	for {
+		select {
+		case err := <-served:
+			fmt.Println("Error while serving", err)
+			os.Exit(1)
+		case sig := <-signals:
+			if sig == syscall.SIGUSR2 && !handOver(listeners) {
+				continue
+			}
+			fmt.Printf("Received %v, shutting down\n", sig)
+			os.Exit(shutdown(opts.server, opts.shutdownTimeout, signals))
 		}
-	}(conn)
-
-	// Create a byte array that would serve as a buffer
-	buf := make([]byte, 1024)
-
-	// Read from the connection
-	n, err := conn.Read(buf)
-	if err != nil {
-		fmt.Println("Error reading from the connection:", err)
 	}
-	message := string(buf[:n])
-	//fmt.Printf("Read: %v from the server", message)
-	request := parseHttpRequest(message)
-	response := generateHttpResponse(request)
+}
 
-	fmt.Printf("Fetched: %v response", response)
-	resp := fmt.Sprintf("HTTP/1.1 %d %s\r\n", response.StatusCode, response.Status)
-	for key, value := range response.Headers {
-		resp += fmt.Sprintf("%s: %s\r\n", key, value)
+// handOver restarts the server from its executable, which a deploy may have
+// replaced, passing the listeners to the new process. It reports whether
+// the new process took over, in which case this one should shut down.
+func handOver(listeners []net.Listener) bool {
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Println("Error while restarting", err)
+		return false
 	}
-	resp += "\r\n" + response.GetBodyAsString()
-	_, err = conn.Write([]byte(resp))
+	pid, err := restart(executable, os.Args[1:], listeners)
 	if err != nil {
-		fmt.Println("Error while reading from the connection", err)
+		fmt.Println("Error while restarting", err)
+		return false
 	}
-}
-func generateHttpResponse(request HttpRequest) HttpResponse {
-	var response HttpResponse
-
-	if request.Path == "/" {
-		response = HttpResponse{
-			StatusCode: 200,
-			Status:     "OK",
-			Headers:    map[string]string{"Content-Type": "text/plain"},
-			Body:       []byte(""),
-		}
-	} else if strings.HasPrefix(request.Path, "/files/") {
-		if request.Method == GET {
-			fileName := strings.TrimPrefix(request.Path, "/files/")
-			filePathToServe := filepath.Join(directory, fileName)
-			file, err := os.Open(filePathToServe)
-			if err != nil {
-				response = HttpResponse{
-					StatusCode: 404,
-					Status:     "Not Found",
-					Headers:    map[string]string{"Content-Type": "text/plain"},
-					Body:       []byte("File not found"),
-				}
-			} else {
-				defer file.Close()
-				fileContent, err := os.ReadFile(filePathToServe)
-				if err != nil {
-					response = HttpResponse{
-						StatusCode: 500,
-						Status:     "Internal Server Error",
-						Headers:    map[string]string{"Content-Type": "text/plain"},
-						Body:       []byte("Error reading file"),
-					}
-				} else {
-					response = HttpResponse{
-						StatusCode: 200,
-						Status:     "OK",
-						Headers: map[string]string{
-							"Content-Type":   "application/octet-stream",
-							"Content-Length": fmt.Sprintf("%d", len(fileContent))},
-						Body: fileContent,
-					}
-				}
-			}
-		} else if request.Method == POST {
-			fileName := strings.TrimPrefix(request.Path, "/files/")
-			filePathToSave := filepath.Join(directory, fileName)
-			err := os.WriteFile(filePathToSave, []byte(request.Body), 0644)
-			if err != nil {
-				response = HttpResponse{
-					StatusCode: 500,
-					Status:     "Internal Server Error",
-					Headers:    map[string]string{"Content-Type": "text/plain"},
-					Body:       []byte("Error writing file"),
-				}
-			} else {
-				response = HttpResponse{
-					StatusCode: 201,
-					Status:     "Created",
-					//Headers:    map[string]string{"Content-Type": "text/plain"},
-					//Body:       "File created",
-				}
-			}
-		}
-	} else if strings.HasPrefix(request.Path, "/echo/") {
-		msg := strings.TrimPrefix(request.Path, "/echo/")
-		responseHeaders := make(map[string]string)
-		responseHeaders["Content-Type"] = "text/plain"
-		responseHeaders["Content-Length"] = fmt.Sprintf("%d", len(msg))
-		encodingHeader := request.Headers["Accept-Encoding"]
-		needsEncoding := false
-
-		for _, encoding := range strings.Split(encodingHeader, ",") {
-			if strings.TrimSpace(encoding) == string(GZIP) {
-				responseHeaders["Content-Encoding"] = string(GZIP)
-				needsEncoding = true
-				break
-			}
-		}
-		if needsEncoding {
-			responseHeaders["Content-Encoding"] = string(GZIP)
-			gzipResponse, err := encodeStringWithGzip(msg)
-			if err != nil {
-				response = HttpResponse{
-					StatusCode: 500,
-					Status:     "Internal Server Error",
-					Headers:    map[string]string{"Content-Type": "text/plain"},
-					Body:       []byte("Error encoding response"),
-				}
-				return response
-			} else {
-				responseHeaders["Content-Length"] = fmt.Sprintf("%d", len(gzipResponse))
-				response = HttpResponse{
-					StatusCode: 200,
-					Status:     "OK",
-					Headers:    responseHeaders,
-					Body:       gzipResponse,
-				}
-				return response
-			}
-		}
-
-		response = HttpResponse{
-			StatusCode: 200,
-			Status:     "OK",
-			Headers:    responseHeaders,
-			Body:       []byte(msg),
+	// The sockets live on in the new process, so closing ours mustn't
+	// remove their files
+	for _, ln := range listeners {
+		if unixLn, ok := ln.(*net.UnixListener); ok {
+			unixLn.SetUnlinkOnClose(false)
 		}
+	}
+	fmt.Printf("Process %d took over the listeners\n", pid)
+	return true
+}
 
-	} else if request.Path == "/user-agent" {
-		response = HttpResponse{
-			StatusCode: 200,
-			Status:     "OK",
-			Headers: map[string]string{"Content-Type": "text/plain",
-				"Content-Length": fmt.Sprintf("%d", len(request.Headers["User-Agent"]))},
-			Body: []byte(request.Headers["User-Agent"]),
-		}
-	} else {
-		response = HttpResponse{
-			StatusCode: 404,
-			Status:     "Not Found",
-			Headers:    map[string]string{"Content-Type": "text/plain"},
-			Body:       []byte("Path not found"),
+// shutdown stops server, letting the requests in flight finish for up to
+// timeout before their connections are closed, and returns the exit code.
+// Another signal cuts the wait short.
+func shutdown(server *httpserver.Server, timeout time.Duration, signals <-chan os.Signal) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Println("Closing the connections still serving requests:", err)
+		server.Close()
+		return 1
 	}
-
-	return response
+	return 0
 }
 
-type Encoding string
+// options holds what the command line asks for.
+type options struct {
+	server *httpserver.Server
+	config httpserver.Config
 
-const (
-	GZIP Encoding = "gzip"
-	UTF8 Encoding = "utf-8"
-)
+	// addrs are the --listen addresses, listenOptions their socket settings
+	addrs         listenAddrs
+	listenOptions httpserver.ListenOptions
+	// systemd serves the sockets inherited through socket activation too
+	systemd bool
 
-// HttpRequest represents an HTTP request.
-type HttpMethod string
+	// shutdownTimeout is how long requests in flight get to finish once a
+	// signal asks the server to stop
+	shutdownTimeout time.Duration
+}
 
-const (
-	GET  HttpMethod = "GET"
-	POST HttpMethod = "POST"
-)
+// listenAddrs collects repeated --listen flags.
+type listenAddrs []string
 
-type HttpRequest struct {
-	Method  HttpMethod
-	Path    string
-	Headers map[string]string
-	Body    string
+func (a *listenAddrs) String() string {
+	return strings.Join(*a, ",")
 }
 
-func parseHttpRequest(requestString string) HttpRequest {
-	lines := strings.Split(requestString, "\r\n")
-	requestLine := strings.Fields(lines[0])
-	method := requestLine[0]
-	path := requestLine[1]
-
-	headers := make(map[string]string)
-	for _, line := range lines[1:] {
-		if line == "" {
-			break
+func (a listenAddrs) has(addr string) bool {
+	for _, listened := range a {
+		if listened == addr {
+			return true
 		}
-		headerParts := strings.SplitN(line, ": ", 2)
-		headers[headerParts[0]] = headerParts[1]
-	}
-
-	body := strings.Join(lines[len(headers)+2:], "\r\n")
-
-	return HttpRequest{
-		Method:  HttpMethod(method),
-		Path:    path,
-		Headers: headers,
-		Body:    body,
 	}
+	return false
 }
 
-// HttpResponse represents an HTTP response.
-type HttpResponse struct {
-	StatusCode int
-	Status     string
-	Headers    map[string]string
-	Body       []byte
-	Encoding   Encoding
-}
-
-func (r *HttpResponse) GetBodyAsString() string {
-	return string(r.Body)
-}
-
-func (r *HttpResponse) SetBodyFromString(body string) {
-	r.Body = []byte(body)
+func (a *listenAddrs) Set(value string) error {
+	if _, _, err := httpserver.ParseListenAddr(value); err != nil {
+		return err
+	}
+	*a = append(*a, value)
+	return nil
 }
 
-// Encode string with Gzip compression.
-func encodeStringWithGzip(input string) ([]byte, error) {
-	var buf bytes.Buffer
-
-	// Create a new gzip writer
-	gzipWriter := gzip.NewWriter(&buf)
-	defer gzipWriter.Close()
-
-	// Write the string to the gzip writer
-	_, err := gzipWriter.Write([]byte(input))
+// listen opens the listeners the options ask for: the --listen addresses
+// and the sockets inherited from systemd, or DefaultAddr if there are none.
+// A process restarted by SIGUSR2 serves the listeners it was handed instead.
+func (o *options) listen() ([]net.Listener, error) {
+	listeners, err := inheritedListeners()
 	if err != nil {
 		return nil, err
 	}
-
-	// Close the writer to flush the data
-	err = gzipWriter.Close()
-	if err != nil {
-		return nil, err
+	if len(listeners) > 0 {
+		// Sockets of our own --listen addresses are removed on shutdown as
+		// when this process created them; systemd's are left to systemd
+		for _, ln := range listeners {
+			if unixLn, ok := ln.(*net.UnixListener); ok && o.addrs.has("unix:"+unixLn.Addr().String()) {
+				unixLn.SetUnlinkOnClose(true)
+			}
+		}
+		return listeners, nil
+	}
+	closeAll := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}
+	if o.systemd {
+		inherited, err := httpserver.SystemdListeners()
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, inherited...)
+	}
+	addrs := o.addrs
+	if len(addrs) == 0 && len(listeners) == 0 {
+		addrs = listenAddrs{httpserver.DefaultAddr}
 	}
+	for _, addr := range addrs {
+		ln, err := o.listenOptions.Listen(addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
 
-	// Return the compressed bytes
-	return buf.Bytes(), nil
+// parseArgs builds the server, its routes' configuration and the addresses
+// to listen on from the command line arguments.
+func parseArgs(args []string) (*options, error) {
+	config := httpserver.DefaultConfig(os.TempDir())
+	server := &httpserver.Server{
+		IdleTimeout:        60 * time.Second,
+		MaxRequestsPerConn: 100,
+		Logger:             log.New(os.Stdout, "", 0),
+	}
+	opts := &options{
+		server:          server,
+		listenOptions:   httpserver.ListenOptions{SocketMode: 0660},
+		shutdownTimeout: 30 * time.Second,
+	}
+	var mimeTypesFile string
+
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags.StringVar(&config.Dir, "directory", config.Dir, "Directory to serve files from")
+	flags.DurationVar(&server.IdleTimeout, "idle-timeout", server.IdleTimeout, "Close keep-alive connections idle for this long (0 disables)")
+	flags.DurationVar(&server.WriteTimeout, "write-timeout", server.WriteTimeout, "Maximum time to answer a request once its headers are read (0 disables)")
+	flags.Var(&config.Symlinks, "symlinks", "Symlinks to follow under --directory: within, deny or allow")
+	flags.Var(&config.ETags, "etags", "Entity tags for served files: weak (size and mtime) or strong (content hash)")
+	flags.StringVar(&mimeTypesFile, "mime-types", "", "mime.types file mapping media types to file extensions")
+	flags.BoolVar(&config.NoSniff, "nosniff", false, "Send X-Content-Type-Options: nosniff with files")
+	flags.BoolVar(&config.CreateDirs, "create-dirs", config.CreateDirs, "Create missing parent directories for uploads")
+	flags.Int64Var(&config.MaxPartSize, "max-part-size", config.MaxPartSize, "Maximum size in bytes of each file in a form upload (0 for unlimited)")
+	flags.Int64Var(&config.MaxFormSize, "max-form-size", config.MaxFormSize, "Maximum size in bytes of a whole form upload (0 for unlimited)")
+	flags.Int64Var(&config.MaxUploadSize, "max-upload-size", config.MaxUploadSize, "Maximum size in bytes of a resumable upload (0 for unlimited)")
+	flags.Int64Var(&config.MaxDecodedSize, "max-decoded-size", config.MaxDecodedSize, "Maximum decompressed size in bytes of a compressed upload (0 for unlimited)")
+	flags.BoolVar(&config.StoreEncoded, "store-encoded", false, "Store compressed uploads as sent instead of decoding their Content-Encoding")
+	flags.Var(config.CompressionLevels, "compression-level", "Response compression level as coding=level, repeatable: gzip and deflate 1-9, br 0-11, zstd 1-19")
+	flags.IntVar(&server.MaxRequestsPerConn, "max-requests", server.MaxRequestsPerConn, "Maximum requests served per connection (0 for unlimited)")
+	flags.Var(&opts.addrs, "listen", "Address to listen on, repeatable: host:port, [ipv6]:port, :port or unix:/path (default "+httpserver.DefaultAddr+")")
+	flags.Var((*httpserver.FileMode)(&opts.listenOptions.SocketMode), "socket-mode", "Permissions of the Unix sockets --listen creates, in octal")
+	flags.StringVar(&opts.listenOptions.SocketGroup, "socket-group", "", "Group to give the Unix sockets --listen creates")
+	flags.BoolVar(&opts.systemd, "systemd", false, "Serve the sockets passed by systemd socket activation (LISTEN_FDS) as well")
+	flags.DurationVar(&opts.shutdownTimeout, "shutdown-timeout", opts.shutdownTimeout, "Time requests in flight get to finish on SIGINT, SIGTERM or a SIGUSR2 restart before their connections are closed")
+	flags.Parse(args)
+
+	if mimeTypesFile != "" {
+		types, err := httpserver.LoadMimeTypes(mimeTypesFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading mime types: %w", err)
+		}
+		config.MimeTypes = types
+	}
+	server.Handler = httpserver.Chain(httpserver.NewHandler(config), httpserver.LogRequests(server.Logger))
+	opts.config = config
+	return opts, nil
 }
@@ -0,0 +1,392 @@
+package httpserver
+
+import (
+	"errors"
+	"io"
+)
+
+// A brotli encoder, RFC 7932. Each meta-block has one block type per
+// category and one prefix code each for literals, insert-and-copy lengths
+// and distances, fitted to the block. Matches come from the shared LZ77
+// matcher; the static dictionary isn't used.
+
+const (
+	brotliWindowBits = 22
+	brotliBlockSize  = 1 << 17
+
+	brotliLiteralAlphabet  = 256
+	brotliCommandAlphabet  = 704
+	brotliDistanceAlphabet = 64 // 16 short codes and 48 with no postfix bits
+	brotliMaxCodeLength    = 15
+	brotliMaxCodeLenLength = 5
+	brotliRepeatZeros      = 17
+)
+
+// Insert and copy length codes, RFC 7932 section 5.
+var (
+	brotliInsertBase = []uint32{
+		0, 1, 2, 3, 4, 5, 6, 8, 10, 14, 18, 26, 34, 50, 66, 98,
+		130, 194, 322, 578, 1090, 2114, 6210, 22594,
+	}
+	brotliInsertBits = []uint8{
+		0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5,
+		6, 7, 8, 9, 10, 12, 14, 24,
+	}
+	brotliCopyBase = []uint32{
+		2, 3, 4, 5, 6, 7, 8, 9, 10, 12, 14, 18, 22, 30, 38, 54,
+		70, 102, 134, 198, 326, 582, 1094, 2118,
+	}
+	brotliCopyBits = []uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4,
+		5, 5, 6, 7, 8, 9, 10, 24,
+	}
+	// brotliCommandCells maps the high bits of the insert and copy codes to
+	// the first insert-and-copy symbol that codes an explicit distance
+	brotliCommandCells = [3][3]int{
+		{128, 192, 384},
+		{256, 320, 512},
+		{448, 576, 640},
+	}
+	// brotliCodeLengthOrder is the order code length code lengths are sent
+	brotliCodeLengthOrder = []int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+)
+
+// brotliCommand inserts literals, then copies from distance bytes back
+// unless it ends the meta-block.
+type brotliCommand struct {
+	insertLength  int
+	copyLength    int
+	distance      int
+	symbol        int
+	insertCode    int
+	copyCode      int
+	distanceCode  int
+	distanceBits  uint
+	distanceExtra uint32
+}
+
+// brotliWriter compresses into a brotli stream, buffering input a
+// meta-block at a time.
+type brotliWriter struct {
+	w       io.Writer
+	matcher *lzMatcher
+	bits    bitWriter
+	pending []byte
+	started bool
+	closed  bool
+	err     error
+}
+
+// newBrotliWriter returns a writer compressing at quality, 0 (fastest) to
+// 11 (smallest).
+func newBrotliWriter(w io.Writer, quality int) *brotliWriter {
+	return &brotliWriter{w: w, matcher: newLZMatcher(lzEffort(quality))}
+}
+
+func (b *brotliWriter) Write(p []byte) (int, error) {
+	if b.closed {
+		return 0, errors.New("brotli: write after close")
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	b.pending = append(b.pending, p...)
+	written := 0
+	for len(b.pending)-written >= brotliBlockSize && b.err == nil {
+		b.writeMetaBlock(b.pending[written : written+brotliBlockSize])
+		written += brotliBlockSize
+	}
+	b.pending = append(b.pending[:0], b.pending[written:]...)
+	return len(p), b.err
+}
+
+// Close compresses what is buffered and ends the stream with an empty last
+// meta-block.
+func (b *brotliWriter) Close() error {
+	if b.closed {
+		return b.err
+	}
+	b.closed = true
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.pending) > 0 {
+		b.writeMetaBlock(b.pending)
+		b.pending = nil
+	}
+	b.start()
+	b.bits.writeBits(1, 1) // ISLAST
+	b.bits.writeBits(1, 1) // ISLASTEMPTY
+	b.bits.alignToByte()
+	b.flush()
+	return b.err
+}
+
+// start writes the stream header, the window size.
+func (b *brotliWriter) start() {
+	if !b.started {
+		b.started = true
+		b.bits.writeBits(1, 1)
+		b.bits.writeBits(brotliWindowBits-17, 3)
+	}
+}
+
+// flush hands the whole bytes written so far to the underlying writer.
+func (b *brotliWriter) flush() {
+	if b.err == nil && len(b.bits.out) > 0 {
+		_, b.err = b.w.Write(b.bits.out)
+	}
+	b.bits.out = b.bits.out[:0]
+}
+
+// writeMetaBlock compresses block into a meta-block, or stores it if that
+// doesn't make it smaller.
+func (b *brotliWriter) writeMetaBlock(block []byte) {
+	b.start()
+	sequences := b.matcher.encodeBlock(block)
+
+	compressed := bitWriter{acc: b.bits.acc, nbits: b.bits.nbits}
+	writeMetaBlockHeader(&compressed, len(block), false)
+	writeCompressedMetaBlock(&compressed, block, sequences)
+	if len(compressed.out) < len(block)+8 {
+		b.bits.out = append(b.bits.out, compressed.out...)
+		b.bits.acc, b.bits.nbits = compressed.acc, compressed.nbits
+	} else {
+		writeMetaBlockHeader(&b.bits, len(block), true)
+		b.bits.alignToByte()
+		b.bits.out = append(b.bits.out, block...)
+	}
+	b.flush()
+}
+
+func writeMetaBlockHeader(b *bitWriter, length int, uncompressed bool) {
+	b.writeBits(0, 1) // ISLAST
+	nibbles := uint(4)
+	for length-1 >= 1<<(4*nibbles) {
+		nibbles++
+	}
+	b.writeBits(uint64(nibbles-4), 2)
+	b.writeBits(uint64(length-1), 4*nibbles)
+	if uncompressed {
+		b.writeBits(1, 1)
+	} else {
+		b.writeBits(0, 1)
+	}
+}
+
+func writeCompressedMetaBlock(b *bitWriter, block []byte, sequences []lzSequence) {
+	commands := make([]brotliCommand, 0, len(sequences))
+	literalFreq := make([]uint32, brotliLiteralAlphabet)
+	commandFreq := make([]uint32, brotliCommandAlphabet)
+	distanceFreq := make([]uint32, brotliDistanceAlphabet)
+	position := 0
+	for _, seq := range sequences {
+		if seq.literalLength == 0 && seq.matchLength == 0 {
+			continue
+		}
+		for _, c := range block[position : position+seq.literalLength] {
+			literalFreq[c]++
+		}
+		position += seq.literalLength + seq.matchLength
+		cmd := newBrotliCommand(seq.literalLength, seq.matchLength, seq.offset)
+		commandFreq[cmd.symbol]++
+		if cmd.copyLength > 0 {
+			distanceFreq[cmd.distanceCode]++
+		}
+		commands = append(commands, cmd)
+	}
+
+	// One block type of each category and a single prefix code for each,
+	// with no postfix bits or direct distance codes
+	b.writeBits(0, 1) // NBLTYPESL
+	b.writeBits(0, 1) // NBLTYPESI
+	b.writeBits(0, 1) // NBLTYPESD
+	b.writeBits(0, 2) // NPOSTFIX
+	b.writeBits(0, 4) // NDIRECT
+	b.writeBits(0, 2) // context mode of the literal block type
+	b.writeBits(0, 1) // NTREESL
+	b.writeBits(0, 1) // NTREESD
+
+	literalLengths, literalCodes := writePrefixCode(b, literalFreq, 8)
+	commandLengths, commandCodes := writePrefixCode(b, commandFreq, 10)
+	distanceLengths, distanceCodes := writePrefixCode(b, distanceFreq, 6)
+
+	position = 0
+	for _, cmd := range commands {
+		b.writeBits(uint64(commandCodes[cmd.symbol]), uint(commandLengths[cmd.symbol]))
+		b.writeBits(uint64(uint32(cmd.insertLength)-brotliInsertBase[cmd.insertCode]), uint(brotliInsertBits[cmd.insertCode]))
+		copyLength := uint32(cmd.copyLength)
+		if copyLength == 0 {
+			// The meta-block ends after the literals, the copy is never done
+			copyLength = brotliCopyBase[cmd.copyCode]
+		}
+		b.writeBits(uint64(copyLength-brotliCopyBase[cmd.copyCode]), uint(brotliCopyBits[cmd.copyCode]))
+		for _, c := range block[position : position+cmd.insertLength] {
+			b.writeBits(uint64(literalCodes[c]), uint(literalLengths[c]))
+		}
+		position += cmd.insertLength + cmd.copyLength
+		if cmd.copyLength > 0 {
+			b.writeBits(uint64(distanceCodes[cmd.distanceCode]), uint(distanceLengths[cmd.distanceCode]))
+			b.writeBits(uint64(cmd.distanceExtra), cmd.distanceBits)
+		}
+	}
+}
+
+func newBrotliCommand(insertLength, copyLength, distance int) brotliCommand {
+	cmd := brotliCommand{insertLength: insertLength, copyLength: copyLength, distance: distance}
+	cmd.insertCode = lengthCode(brotliInsertBase, uint32(insertLength))
+	if copyLength > 0 {
+		cmd.copyCode = lengthCode(brotliCopyBase, uint32(copyLength))
+		// Distances are shifted so the smallest takes the first code after
+		// the 16 short codes, RFC 7932 section 4
+		d := uint32(distance) + 3
+		bucket := bitLength(uint64(d)) - 2
+		prefix := (d >> bucket) & 1
+		cmd.distanceCode = 16 + int(2*(bucket-1)+uint(prefix))
+		cmd.distanceBits = bucket
+		cmd.distanceExtra = d - (2+prefix)<<bucket
+	}
+	cell := brotliCommandCells[cmd.insertCode>>3][cmd.copyCode>>3]
+	cmd.symbol = cell | (cmd.insertCode&7)<<3 | cmd.copyCode&7
+	return cmd
+}
+
+// writePrefixCode fits a prefix code to freq and writes its description,
+// RFC 7932 section 3. It returns the code lengths and codes to write
+// symbols with.
+func writePrefixCode(b *bitWriter, freq []uint32, alphabetBits uint) ([]uint8, []uint16) {
+	var used []int
+	for symbol, f := range freq {
+		if f > 0 {
+			used = append(used, symbol)
+		}
+	}
+	if len(used) == 0 {
+		// An unused code still needs a symbol
+		used = []int{0}
+	}
+	lengths := huffmanLengths(freq, brotliMaxCodeLength)
+
+	if len(used) <= 4 {
+		// A simple prefix code lists the symbols, shortest codes first
+		b.writeBits(1, 2)
+		b.writeBits(uint64(len(used)-1), 2)
+		sortByLength := append([]int(nil), used...)
+		for i := 1; i < len(sortByLength); i++ {
+			for j := i; j > 0 && lengths[sortByLength[j]] < lengths[sortByLength[j-1]]; j-- {
+				sortByLength[j], sortByLength[j-1] = sortByLength[j-1], sortByLength[j]
+			}
+		}
+		for _, symbol := range sortByLength {
+			b.writeBits(uint64(symbol), alphabetBits)
+		}
+		if len(used) == 4 {
+			if lengths[sortByLength[0]] == 1 {
+				b.writeBits(1, 1)
+			} else {
+				b.writeBits(0, 1)
+			}
+		}
+		if len(used) == 1 {
+			// A lone symbol is written with no bits
+			lengths[used[0]] = 0
+		}
+		return lengths, canonicalCodes(lengths)
+	}
+
+	// Code lengths up to the last used symbol, runs of zeros coded with
+	// repeat codes
+	var tokens, extra []uint8
+	last := used[len(used)-1]
+	for i := 0; i <= last; {
+		if lengths[i] != 0 {
+			tokens, extra = append(tokens, lengths[i]), append(extra, 0)
+			i++
+			continue
+		}
+		run := 0
+		for i+run <= last && lengths[i+run] == 0 {
+			run++
+		}
+		tokens, extra = appendZeroRun(tokens, extra, run)
+		i += run
+	}
+
+	tokenFreq := make([]uint32, 18)
+	for _, t := range tokens {
+		tokenFreq[t]++
+	}
+	tokenLengths := huffmanLengths(tokenFreq, brotliMaxCodeLenLength)
+	kinds := 0
+	for _, f := range tokenFreq {
+		if f > 0 {
+			kinds++
+		}
+	}
+
+	b.writeBits(0, 2) // HSKIP, no code length code lengths skipped
+	// The lengths are sent until they form a complete code. A single
+	// length can't, so then all of them are sent.
+	lastIndex := len(brotliCodeLengthOrder) - 1
+	if kinds > 1 {
+		for tokenLengths[brotliCodeLengthOrder[lastIndex]] == 0 {
+			lastIndex--
+		}
+	}
+	for _, symbol := range brotliCodeLengthOrder[:lastIndex+1] {
+		writeCodeLengthCodeLength(b, tokenLengths[symbol])
+	}
+	tokenCodes := canonicalCodes(tokenLengths)
+	if kinds == 1 {
+		// The only token takes no bits
+		for i := range tokenLengths {
+			tokenLengths[i] = 0
+		}
+	}
+	for i, t := range tokens {
+		b.writeBits(uint64(tokenCodes[t]), uint(tokenLengths[t]))
+		if t == brotliRepeatZeros {
+			b.writeBits(uint64(extra[i]), 3)
+		}
+	}
+	return lengths, canonicalCodes(lengths)
+}
+
+// appendZeroRun codes run zero code lengths. Consecutive repeat codes
+// multiply, so longer runs take several, most significant first.
+func appendZeroRun(tokens, extra []uint8, run int) ([]uint8, []uint8) {
+	if run == 11 {
+		tokens, extra = append(tokens, 0), append(extra, 0)
+		run--
+	}
+	if run < 3 {
+		for i := 0; i < run; i++ {
+			tokens, extra = append(tokens, 0), append(extra, 0)
+		}
+		return tokens, extra
+	}
+	start := len(tokens)
+	run -= 3
+	for {
+		tokens, extra = append(tokens, brotliRepeatZeros), append(extra, uint8(run&7))
+		run >>= 3
+		if run == 0 {
+			break
+		}
+		run--
+	}
+	for i, j := start, len(tokens)-1; i < j; i, j = i+1, j-1 {
+		extra[i], extra[j] = extra[j], extra[i]
+	}
+	return tokens, extra
+}
+
+// writeCodeLengthCodeLength writes a code length code length with the
+// static code of RFC 7932 section 3.5.
+func writeCodeLengthCodeLength(b *bitWriter, length uint8) {
+	codes := [6]struct {
+		value uint64
+		bits  uint
+	}{{0, 2}, {7, 4}, {3, 3}, {2, 2}, {1, 2}, {15, 4}}
+	b.writeBits(codes[length].value, codes[length].bits)
+}
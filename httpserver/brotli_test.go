@@ -0,0 +1,367 @@
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Decode brotli-compressed bytes back to a string. Only what the encoder
+// produces is supported: one block type per category, one prefix code for
+// each, no postfix bits, direct distance codes or dictionary references.
+func decodeBrotliToString(compressed []byte) (string, error) {
+	r := &testBitReader{data: compressed}
+	windowBits, err := readBrotliWindowBits(r)
+	if err != nil {
+		return "", err
+	}
+	var out []byte
+	lastDistances := []int{4, 11, 15, 16}
+	for {
+		isLast, err := r.bits(1)
+		if err != nil {
+			return "", err
+		}
+		if isLast == 1 {
+			if empty, err := r.bits(1); err != nil || empty == 1 {
+				return string(out), err
+			}
+		}
+		nibbles, err := r.bits(2)
+		if err != nil || nibbles == 3 {
+			return "", fmt.Errorf("unsupported meta-block header: %v", err)
+		}
+		length, err := r.bits(4 * uint(nibbles+4))
+		if err != nil {
+			return "", err
+		}
+		remaining := int(length) + 1
+		if isLast == 0 {
+			if uncompressed, _ := r.bits(1); uncompressed == 1 {
+				r.alignToByte()
+				start := r.pos / 8
+				if start+remaining > len(compressed) {
+					return "", io.ErrUnexpectedEOF
+				}
+				out = append(out, compressed[start:start+remaining]...)
+				r.pos += 8 * remaining
+				continue
+			}
+		}
+		// NBLTYPESL, NBLTYPESI, NBLTYPESD, NPOSTFIX, NDIRECT, context mode,
+		// NTREESL and NTREESD
+		if header, err := r.bits(13); err != nil || header != 0 {
+			return "", fmt.Errorf("unsupported meta-block layout %b: %v", header, err)
+		}
+		var codes [3]*testBrotliCode
+		for i, alphabet := range []struct {
+			size int
+			bits uint
+		}{{256, 8}, {704, 10}, {64, 6}} {
+			if codes[i], err = readBrotliPrefixCode(r, alphabet.size, alphabet.bits); err != nil {
+				return "", err
+			}
+		}
+		literals, commands, distances := codes[0], codes[1], codes[2]
+
+		for remaining > 0 {
+			symbol, err := commands.decode(r)
+			if err != nil {
+				return "", err
+			}
+			cell := [][2]int{{0, 0}, {0, 1}, {0, 0}, {0, 1}, {1, 0}, {1, 1}, {0, 2}, {2, 0}, {1, 2}, {2, 1}, {2, 2}}[symbol>>6]
+			insertCode := cell[0]<<3 | symbol>>3&7
+			copyCode := cell[1]<<3 | symbol&7
+			insertExtra, err := r.bits(uint(brotliInsertBits[insertCode]))
+			if err != nil {
+				return "", err
+			}
+			copyExtra, err := r.bits(uint(brotliCopyBits[copyCode]))
+			if err != nil {
+				return "", err
+			}
+			for i := 0; i < int(brotliInsertBase[insertCode]+insertExtra); i++ {
+				literal, err := literals.decode(r)
+				if err != nil {
+					return "", err
+				}
+				out = append(out, byte(literal))
+				remaining--
+			}
+			if remaining <= 0 {
+				break
+			}
+
+			distance := lastDistances[0]
+			if symbol >= 128 {
+				code, err := distances.decode(r)
+				if err != nil {
+					return "", err
+				}
+				if code < 16 {
+					return "", fmt.Errorf("unsupported distance code %d", code)
+				}
+				hcode := code - 16
+				distanceBits := uint(1 + hcode>>1)
+				extra, err := r.bits(distanceBits)
+				if err != nil {
+					return "", err
+				}
+				distance = (2+hcode&1)<<distanceBits - 4 + int(extra) + 1
+				lastDistances = append([]int{distance}, lastDistances[:3]...)
+			}
+			copyLength := int(brotliCopyBase[copyCode] + copyExtra)
+			if distance > len(out) || distance > 1<<windowBits-16 || copyLength > remaining {
+				return "", fmt.Errorf("invalid copy of %d bytes from %d back", copyLength, distance)
+			}
+			for i := 0; i < copyLength; i++ {
+				out = append(out, out[len(out)-distance])
+			}
+			remaining -= copyLength
+		}
+		if isLast == 1 {
+			return string(out), nil
+		}
+	}
+}
+
+func readBrotliWindowBits(r *testBitReader) (uint, error) {
+	if bit, err := r.bits(1); err != nil || bit == 0 {
+		return 16, err
+	}
+	n, err := r.bits(3)
+	if err != nil || n != 0 {
+		return 17 + uint(n), err
+	}
+	n, err = r.bits(3)
+	if n == 0 {
+		return 17, err
+	}
+	return 8 + uint(n), err
+}
+
+// testBrotliCode is a prefix code, or the lone symbol of a code that takes
+// no bits.
+type testBrotliCode struct {
+	*testPrefixDecoder
+	only int
+}
+
+func (c *testBrotliCode) decode(r *testBitReader) (int, error) {
+	if c.testPrefixDecoder == nil {
+		return c.only, nil
+	}
+	return c.testPrefixDecoder.decode(r)
+}
+
+func readBrotliPrefixCode(r *testBitReader, alphabetSize int, alphabetBits uint) (*testBrotliCode, error) {
+	lengths := make([]uint8, alphabetSize)
+	hskip, err := r.bits(2)
+	if err != nil {
+		return nil, err
+	}
+	if hskip == 1 {
+		count, _ := r.bits(2)
+		symbols := make([]int, count+1)
+		for i := range symbols {
+			symbol, err := r.bits(alphabetBits)
+			if err != nil || int(symbol) >= alphabetSize {
+				return nil, fmt.Errorf("invalid simple prefix code symbol %d: %v", symbol, err)
+			}
+			symbols[i] = int(symbol)
+		}
+		var simpleLengths []uint8
+		switch len(symbols) {
+		case 1:
+			return &testBrotliCode{only: symbols[0]}, nil
+		case 2:
+			simpleLengths = []uint8{1, 1}
+		case 3:
+			simpleLengths = []uint8{1, 2, 2}
+		case 4:
+			simpleLengths = []uint8{2, 2, 2, 2}
+			if treeSelect, _ := r.bits(1); treeSelect == 1 {
+				simpleLengths = []uint8{1, 2, 3, 3}
+			}
+		}
+		for i, symbol := range symbols {
+			lengths[symbol] = simpleLengths[i]
+		}
+		return &testBrotliCode{testPrefixDecoder: newTestPrefixDecoder(lengths)}, nil
+	}
+
+	// The static code of the code length code lengths, by the 4 bits
+	// ahead
+	staticValues := []uint8{0, 4, 3, 2, 0, 4, 3, 1, 0, 4, 3, 2, 0, 4, 3, 5}
+	staticLengths := []uint{2, 2, 2, 3, 2, 2, 2, 4, 2, 2, 2, 3, 2, 2, 2, 4}
+	codeLengthLengths := make([]uint8, 18)
+	space, codesUsed := 32, 0
+	for _, symbol := range brotliCodeLengthOrder[hskip:] {
+		ahead, _ := r.peek(4)
+		r.pos += int(staticLengths[ahead])
+		v := staticValues[ahead]
+		codeLengthLengths[symbol] = v
+		if v != 0 {
+			space -= 32 >> v
+			codesUsed++
+			if space <= 0 {
+				break
+			}
+		}
+	}
+	if codesUsed != 1 && space != 0 {
+		return nil, errors.New("incomplete code length code")
+	}
+	codeLengths := &testBrotliCode{testPrefixDecoder: newTestPrefixDecoder(codeLengthLengths)}
+	if codesUsed == 1 {
+		for symbol, l := range codeLengthLengths {
+			if l != 0 {
+				codeLengths = &testBrotliCode{only: symbol}
+			}
+		}
+	}
+
+	symbol, previous, repeat, repeatLength := 0, uint8(8), 0, uint8(0)
+	space = 1 << 15
+	for symbol < alphabetSize && space > 0 {
+		code, err := codeLengths.decode(r)
+		if err != nil {
+			return nil, err
+		}
+		if code < 16 {
+			lengths[symbol] = uint8(code)
+			symbol++
+			repeat = 0
+			if code != 0 {
+				previous = uint8(code)
+				space -= 1 << 15 >> code
+			}
+			continue
+		}
+		extraBits, length := uint(2), previous
+		if code == 17 {
+			extraBits, length = 3, 0
+		}
+		if repeatLength != length {
+			repeat, repeatLength = 0, length
+		}
+		old := repeat
+		if repeat > 0 {
+			repeat = (repeat - 2) << extraBits
+		}
+		extra, err := r.bits(extraBits)
+		if err != nil {
+			return nil, err
+		}
+		repeat += int(extra) + 3
+		for i := 0; i < repeat-old; i++ {
+			if symbol >= alphabetSize {
+				return nil, errors.New("code lengths overflow the alphabet")
+			}
+			lengths[symbol] = length
+			symbol++
+			if length != 0 {
+				space -= 1 << 15 >> length
+			}
+		}
+	}
+	if space != 0 {
+		return nil, errors.New("incomplete prefix code")
+	}
+	return &testBrotliCode{testPrefixDecoder: newTestPrefixDecoder(lengths)}, nil
+}
+
+// brotliTestInputs covers stored, literal only and match heavy data.
+func brotliTestInputs() map[string]string {
+	random := make([]byte, 50000)
+	rand.New(rand.NewSource(1)).Read(random)
+	words := []string{"lorem", "ipsum", "dolor", "sit", "amet", "\n", "ünïcödé"}
+	var text strings.Builder
+	r := rand.New(rand.NewSource(2))
+	for text.Len() < 400000 {
+		text.WriteString(words[r.Intn(len(words))] + " ")
+	}
+	return map[string]string{
+		"empty":  "",
+		"byte":   "a",
+		"short":  "Hello, World!",
+		"run":    strings.Repeat("z", 100000),
+		"random": string(random),
+		"text":   text.String(),
+		"mixed":  text.String()[:30000] + string(random[:20000]) + text.String()[:30000],
+	}
+}
+
+func TestBrotliWriter_RoundTrip(t *testing.T) {
+	for name, content := range brotliTestInputs() {
+		for _, quality := range []int{0, 1, 5, 11} {
+			t.Run(fmt.Sprintf("%s/%d", name, quality), func(t *testing.T) {
+				var buf bytes.Buffer
+				w := newBrotliWriter(&buf, quality)
+				// Writes straddle meta-block boundaries
+				for i := 0; i < len(content); i += 10000 {
+					end := i + 10000
+					if end > len(content) {
+						end = len(content)
+					}
+					if _, err := io.WriteString(w, content[i:end]); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := decodeBrotliToString(buf.Bytes())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if decoded != content {
+					t.Errorf("Expected the decompressed body to match, but got %d bytes of %d", len(decoded), len(content))
+				}
+				if len(content) > 1000 && buf.Len() > len(content)+len(content)/100 {
+					t.Errorf("Expected at most 1%% growth, but %d bytes became %d", len(content), buf.Len())
+				}
+			})
+		}
+	}
+}
+
+func TestBrotliWriter_Compresses(t *testing.T) {
+	content := brotliTestInputs()["text"]
+	sizes := make(map[int]int)
+	for _, quality := range []int{1, 11} {
+		var buf bytes.Buffer
+		w := newBrotliWriter(&buf, quality)
+		io.WriteString(w, content)
+		w.Close()
+		sizes[quality] = buf.Len()
+	}
+	if sizes[1] > len(content)/3 {
+		t.Errorf("Expected text to shrink to under a third, but %d bytes became %d", len(content), sizes[1])
+	}
+	if sizes[11] >= sizes[1] {
+		t.Errorf("Expected quality 11 to beat quality 1, but got %d and %d", sizes[11], sizes[1])
+	}
+}
+
+func TestResponseWriter_Brotli(t *testing.T) {
+	response := serveTestResponse(t, HttpRequest{
+		Method:  GET,
+		Path:    "/echo/squeezed",
+		Headers: Header{"Accept-Encoding": {"gzip;q=0.5, deflate;q=0.5, br"}},
+	})
+	if got := response.Headers.Get("Content-Encoding"); got != "br" {
+		t.Fatalf("Expected Content-Encoding br, but got %q", got)
+	}
+	body, err := decodeBrotliToString(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "squeezed" {
+		t.Errorf("Expected Body squeezed, but got %q", body)
+	}
+}
@@ -0,0 +1,156 @@
+package httpserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// chunkedReader decodes a body sent with "Transfer-Encoding: chunked"
+// (RFC 9112 section 7.1). Chunk extensions are ignored, and any trailer
+// fields following the last chunk are stored in trailers once the body has
+// been read to the end.
+type chunkedReader struct {
+	r *bufio.Reader
+	// remaining is the number of data bytes left in the current chunk
+	remaining int64
+	// needCRLF is set once a chunk's data has been read and the CRLF that
+	// closes it is still pending
+	needCRLF bool
+	trailers Header
+	err      error
+}
+
+func newChunkedReader(r *bufio.Reader, trailers Header) *chunkedReader {
+	return &chunkedReader{r: r, trailers: trailers}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.err == nil {
+		if c.remaining > 0 {
+			if int64(len(p)) > c.remaining {
+				p = p[:c.remaining]
+			}
+			n, err := c.r.Read(p)
+			c.remaining -= int64(n)
+			if c.remaining == 0 {
+				c.needCRLF = true
+			}
+			if err != nil {
+				c.err = unexpectedEOF(err)
+				if n == 0 {
+					return 0, c.err
+				}
+			}
+			return n, nil
+		}
+		if c.needCRLF {
+			c.err = c.readCRLF()
+			c.needCRLF = false
+			continue
+		}
+		c.err = c.beginChunk()
+	}
+	return 0, c.err
+}
+
+// maxChunkLineLength bounds a chunk-size line including its extensions.
+const maxChunkLineLength = 4 << 10
+
+// beginChunk reads the next chunk-size line. The zero sized last chunk is
+// followed by the trailer section, after which the body ends.
+func (c *chunkedReader) beginChunk() error {
+	line, err := readLine(c.r, maxChunkLineLength)
+	if err == errLineTooLong {
+		return badRequest("chunk-size line too long")
+	}
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	size, err := parseChunkSize(line)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		if err := c.readTrailers(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	c.remaining = size
+	return nil
+}
+
+func (c *chunkedReader) readCRLF() error {
+	line, err := readLine(c.r, maxChunkLineLength)
+	if err != nil && err != errLineTooLong {
+		return unexpectedEOF(err)
+	}
+	if line != "" || err != nil {
+		return badRequest("malformed chunked encoding: missing CRLF after chunk data")
+	}
+	return nil
+}
+
+func (c *chunkedReader) readTrailers() error {
+	trailers, err := readHeaders(c.r, maxHeaderBytes)
+	if err != nil {
+		return err
+	}
+	for name, values := range trailers {
+		if c.trailers != nil {
+			c.trailers[name] = append(c.trailers[name], values...)
+		}
+	}
+	return nil
+}
+
+// parseChunkSize parses the hexadecimal size at the start of a chunk-size
+// line, discarding any chunk extensions after ';'.
+func parseChunkSize(line string) (int64, error) {
+	size := line
+	if i := strings.IndexByte(size, ';'); i >= 0 {
+		size = size[:i]
+	}
+	size = strings.TrimRight(size, " \t")
+	n, err := strconv.ParseInt(size, 16, 64)
+	if err != nil || n < 0 || size == "" || size[0] == '+' || size[0] == '-' {
+		return 0, badRequest("malformed chunk size %q", line)
+	}
+	return n, nil
+}
+
+// chunkedWriter frames everything written to it as chunks. Close writes the
+// last chunk but does not close the underlying writer.
+type chunkedWriter struct {
+	w io.Writer
+}
+
+func newChunkedWriter(w io.Writer) *chunkedWriter {
+	return &chunkedWriter{w: w}
+}
+
+func (c *chunkedWriter) Write(p []byte) (int, error) {
+	// An empty chunk would be read as the end of the body
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(c.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if _, err := io.WriteString(c.w, "\r\n"); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (c *chunkedWriter) Close() error {
+	_, err := io.WriteString(c.w, "0\r\n\r\n")
+	return err
+}
@@ -0,0 +1,169 @@
+package httpserver
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChunkedReader(t *testing.T) {
+	tests := []struct {
+		name             string
+		body             string
+		expectedBody     string
+		expectedTrailers map[string]string
+	}{
+		{
+			name:         "Simple",
+			body:         "5\r\nHello\r\n7\r\n, World\r\n0\r\n\r\n",
+			expectedBody: "Hello, World",
+		},
+		{
+			name:         "Extensions",
+			body:         "5;name=value\r\nHello\r\n1 ; last\r\n!\r\n0;done\r\n\r\n",
+			expectedBody: "Hello!",
+		},
+		{
+			name:         "UppercaseHex",
+			body:         "A\r\n0123456789\r\n0\r\n\r\n",
+			expectedBody: "0123456789",
+		},
+		{
+			name:         "Trailers",
+			body:         "3\r\nabc\r\n0\r\nContent-MD5: kAFQmDzST7DWlj99KOF/cg==\r\nX-Build: 42\r\n\r\n",
+			expectedBody: "abc",
+			expectedTrailers: map[string]string{
+				"Content-MD5": "kAFQmDzST7DWlj99KOF/cg==",
+				"X-Build":     "42",
+			},
+		},
+		{
+			name:         "Empty",
+			body:         "0\r\n\r\n",
+			expectedBody: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trailers := make(Header)
+			reader := newChunkedReader(bufio.NewReader(strings.NewReader(tt.body)), trailers)
+			body, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.expectedBody {
+				t.Errorf("Expected Body %q, but got %q", tt.expectedBody, body)
+			}
+			if len(trailers) != len(tt.expectedTrailers) {
+				t.Errorf("Expected %d trailers, but got %v", len(tt.expectedTrailers), trailers)
+			}
+			for key, expectedValue := range tt.expectedTrailers {
+				if trailers.Get(key) != expectedValue {
+					t.Errorf("Expected Trailer %s to be %s, but got %s", key, expectedValue, trailers.Get(key))
+				}
+			}
+		})
+	}
+}
+
+func TestChunkedReader_Malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "BadSize", body: "zz\r\nHello\r\n0\r\n\r\n"},
+		{name: "NegativeSize", body: "-5\r\nHello\r\n0\r\n\r\n"},
+		{name: "EmptySize", body: "\r\nHello\r\n0\r\n\r\n"},
+		{name: "MissingCRLF", body: "5\r\nHelloX\r\n0\r\n\r\n"},
+		{name: "Truncated", body: "5\r\nHel"},
+		{name: "MissingLastChunk", body: "5\r\nHello\r\n"},
+		{name: "BadTrailer", body: "0\r\nnot a header\r\n\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newChunkedReader(bufio.NewReader(strings.NewReader(tt.body)), nil)
+			if _, err := io.ReadAll(reader); err == nil {
+				t.Errorf("Expected an error decoding %q", tt.body)
+			}
+		})
+	}
+}
+
+func TestReadHttpRequest_Chunked(t *testing.T) {
+	raw := "POST /files/log HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"4\r\nWiki\r\n5\r\npedia\r\n0\r\nX-Checksum: 1\r\n\r\n" +
+		"GET / HTTP/1.1\r\n\r\n"
+	reader := bufio.NewReader(strings.NewReader(raw))
+
+	request, err := readHttpRequest(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readRequestBody(t, request); body != "Wikipedia" {
+		t.Errorf("Expected Body Wikipedia, but got %s", body)
+	}
+	if request.Trailers.Get("X-Checksum") != "1" {
+		t.Errorf("Expected Trailer X-Checksum to be 1, but got %v", request.Trailers)
+	}
+
+	next, err := readHttpRequest(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Path != "/" {
+		t.Errorf("Expected Path /, but got %s", next.Path)
+	}
+}
+
+func TestReadHttpRequest_ChunkedAndContentLength(t *testing.T) {
+	raw := "POST /files/x HTTP/1.1\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n"
+	_, err := readHttpRequest(bufio.NewReader(strings.NewReader(raw)))
+	if !errors.Is(err, errContentLengthAndChunked) {
+		t.Errorf("Expected errContentLengthAndChunked, but got %v", err)
+	}
+}
+
+func TestReadHttpRequest_UnsupportedTransferCoding(t *testing.T) {
+	raw := "POST /files/x HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n"
+	_, err := readHttpRequest(bufio.NewReader(strings.NewReader(raw)))
+	if !errors.Is(err, errUnsupportedTransferCoding) {
+		t.Errorf("Expected errUnsupportedTransferCoding, but got %v", err)
+	}
+}
+
+func TestHandleConnection_ChunkedUpload(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+
+	conn, err := net.Dial("tcp", startTestServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	resp := roundTrip(t, conn, reader, "POST /files/build.log HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"6;step=1\r\nline1\n\r\n6\r\nline2\n\r\n0\r\n\r\n")
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected StatusCode 201, but got %d", resp.StatusCode)
+	}
+	saved, err := os.ReadFile(filepath.Join(testConfig.Dir, "build.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(saved) != "line1\nline2\n" {
+		t.Errorf("Expected file content %q, but got %q", "line1\nline2\n", saved)
+	}
+
+	resp = roundTrip(t, conn, reader, "POST /files/x HTTP/1.1\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n")
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected StatusCode 400, but got %d", resp.StatusCode)
+	}
+	expectClosed(t, conn, reader)
+}
@@ -0,0 +1,221 @@
+package httpserver
+
+import (
+	"io"
+	"mime"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// precompressed lists the codings of sibling files that are served in place
+// of a file, most preferred first: foo.br, foo.zst, then foo.gz for foo.
+var precompressed = []struct {
+	coding Encoding
+	suffix string
+}{
+	{coding: BROTLI, suffix: ".br"},
+	{coding: ZSTD, suffix: ".zst"},
+	{coding: GZIP, suffix: ".gz"},
+}
+
+// precompressedSuffix returns the file name suffix of coding's siblings.
+func precompressedSuffix(coding Encoding) string {
+	for _, p := range precompressed {
+		if p.coding == coding {
+			return p.suffix
+		}
+	}
+	return ""
+}
+
+// openPrecompressed opens the most preferred pre-compressed sibling of the
+// file at path that the request accepts. Siblings older than the file are
+// stale and skipped.
+func openPrecompressed(path string, request HttpRequest) (*os.File, os.FileInfo, Encoding, bool) {
+	original, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, "", false
+	}
+	for _, p := range precompressed {
+		if !acceptsEncoding(request, p.coding) {
+			continue
+		}
+		file, err := os.Open(path + p.suffix)
+		if err != nil {
+			continue
+		}
+		info, err := file.Stat()
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().Before(original.ModTime()) {
+			file.Close()
+			continue
+		}
+		return file, info, p.coding, true
+	}
+	return nil, nil, "", false
+}
+
+// compressibleMediaTypes are non text/* media types worth compressing.
+var compressibleMediaTypes = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/wasm":       true,
+	"application/xml":        true,
+	"application/yaml":       true,
+	"image/bmp":              true,
+	"image/svg+xml":          true,
+	"font/ttf":               true,
+	"font/otf":               true,
+}
+
+// isCompressible reports whether content of contentType is likely to shrink
+// when compressed. Already compressed formats like images and archives
+// aren't.
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		compressibleMediaTypes[mediaType]
+}
+
+// variantETag derives the entity tag of a compressed variant from the tag of
+// the file. Compression isn't byte for byte reproducible, so it is weak.
+func variantETag(etag string, coding Encoding) string {
+	opaque := strings.Trim(strings.TrimPrefix(etag, "W/"), "\"")
+	return "W/\"" + opaque + "-" + string(coding) + "\""
+}
+
+// Compress returns the middleware that compresses 200 responses with the
+// content coding negotiated from Accept-Encoding, at levels. When the client
+// accepts none the response could be sent with, it gets 406 Not Acceptable
+// instead.
+func Compress(levels CompressionLevels) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, request HttpRequest) {
+			cw := newCompressWriter(w, request, levels)
+			next.ServeHTTP(cw, request)
+			if err := cw.close(); err != nil {
+				abortResponse(w, err)
+			}
+		})
+	}
+}
+
+// notAcceptableBody is sent when the client accepts none of the codings a
+// response could be sent with.
+const notAcceptableBody = "Not Acceptable"
+
+// compressWriter negotiates the content coding when the header is written
+// and compresses the body written after it.
+type compressWriter struct {
+	w           ResponseWriter
+	request     HttpRequest
+	levels      CompressionLevels
+	wroteHeader bool
+	// encoder compresses the body when a content coding was negotiated
+	encoder io.WriteCloser
+	// discard drops the handler's body, which a 406 was sent in place of
+	discard bool
+}
+
+func newCompressWriter(w ResponseWriter, request HttpRequest, levels CompressionLevels) *compressWriter {
+	return &compressWriter{w: w, request: request, levels: levels}
+}
+
+func (cw *compressWriter) Header() Header {
+	return cw.w.Header()
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	header := cw.w.Header()
+	if !negotiable(statusCode, header) {
+		cw.w.WriteHeader(statusCode)
+		return
+	}
+	addVary(header, "Accept-Encoding")
+	coding, ok := responseEncoding(cw.request, header.Get("Content-Type"))
+	if !ok {
+		// The handler's body is dropped in favor of the 406
+		for key := range header {
+			delete(header, key)
+		}
+		header.Set("Content-Type", "text/plain")
+		header.Set("Content-Length", strconv.Itoa(len(notAcceptableBody)))
+		header.Set("Vary", "Accept-Encoding")
+		cw.w.WriteHeader(406)
+		cw.w.Write([]byte(notAcceptableBody))
+		cw.discard = true
+		return
+	}
+	if coding != IDENTITY {
+		header.Set("Content-Encoding", string(coding))
+		header.Del("Content-Length")
+		// Ranges, digests and strong validators describe the uncompressed
+		// bytes
+		header.Del("Accept-Ranges")
+		header.Del("Repr-Digest")
+		header.Del("Digest")
+		if etag := header.Get("Etag"); etag != "" {
+			header.Set("Etag", variantETag(etag, coding))
+		}
+	}
+	cw.w.WriteHeader(statusCode)
+	if coding != IDENTITY && !discardsBody(cw.w) {
+		cw.encoder = newEncoder(cw.w, coding, cw.levels)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(200)
+	}
+	if cw.discard {
+		return len(p), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.w.Write(p)
+}
+
+func (cw *compressWriter) discardsBody() bool {
+	return cw.discard || discardsBody(cw.w)
+}
+
+func (cw *compressWriter) abort(err error) {
+	abortResponse(cw.w, err)
+}
+
+// close flushes what the encoder holds back.
+func (cw *compressWriter) close() error {
+	if cw.encoder == nil {
+		return nil
+	}
+	return cw.encoder.Close()
+}
+
+// negotiable reports whether a response with statusCode and header is
+// subject to content coding negotiation: a successful response with a body
+// that the handler hasn't encoded itself.
+func negotiable(statusCode int, header Header) bool {
+	return statusCode == 200 &&
+		!header.Has("Content-Encoding") &&
+		!header.Has("Content-Range") &&
+		header.Get("Content-Length") != "0"
+}
+
+// addVary adds field to the Vary header unless it is already listed.
+func addVary(header Header, field string) {
+	if !header.hasToken("Vary", field) && !header.hasToken("Vary", "*") {
+		header.Add("Vary", field)
+	}
+}
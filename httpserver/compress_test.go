@@ -0,0 +1,223 @@
+package httpserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeCompressTestFile(t *testing.T, name string, content string, modTime time.Time) {
+	t.Helper()
+	path := filepath.Join(testConfig.Dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getWithEncoding fetches path through the response writer.
+func getWithEncoding(t *testing.T, path string, acceptEncoding string) HttpResponse {
+	t.Helper()
+	return serveTestResponse(t, HttpRequest{
+		Method:  GET,
+		Path:    path,
+		Headers: Header{"Accept-Encoding": {acceptEncoding}},
+	})
+}
+
+func TestGetFile_GzipOnTheFly(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	content := strings.Repeat("compress me ", 1000)
+	writeCompressTestFile(t, "page.html", content, time.Now())
+
+	response := getWithEncoding(t, "/files/page.html", "gzip")
+	body := string(response.Body)
+	if got := response.Headers.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Expected Content-Encoding gzip, but got %q", got)
+	}
+	if response.Headers.Has("Content-Length") {
+		t.Errorf("Expected no Content-Length for a streamed body")
+	}
+	if got := response.Headers.Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Expected Vary Accept-Encoding, but got %q", got)
+	}
+	decoded, err := decodeGzipToString([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != content {
+		t.Errorf("Expected the decompressed body to match the file")
+	}
+
+	// The compressed variant has its own entity tag
+	etag := response.Headers.Get("Etag")
+	request := HttpRequest{Method: GET, Path: "/files/page.html", Headers: Header{
+		"Accept-Encoding": {"gzip"},
+		"If-None-Match":   {etag},
+	}}
+	if response := generateHttpResponse(request); response.StatusCode != 304 {
+		t.Errorf("Expected StatusCode 304 for etag %s, but got %d", etag, response.StatusCode)
+	}
+}
+
+func TestGetFile_IgnoredRangeUsesVariantETag(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	writeCompressTestFile(t, "page.html", strings.Repeat("compress me ", 1000), time.Now())
+	gzipETag := getWithEncoding(t, "/files/page.html", "gzip").Headers.Get("Etag")
+	identityETag := getWithEncoding(t, "/files/page.html", "").Headers.Get("Etag")
+
+	// Each of these Range requests gets the whole, compressed file
+	ignored := []Header{
+		{"Range": {"items=0-1"}},
+		{"Range": {"bytes=abc"}},
+		{"Range": {"bytes=0-1"}, "If-Range": {`"stale"`}},
+	}
+	for _, headers := range ignored {
+		headers.Set("Accept-Encoding", "gzip")
+		headers.Set("If-None-Match", gzipETag)
+		response := generateHttpResponse(HttpRequest{Method: GET, Path: "/files/page.html", Headers: headers})
+		readResponseBody(t, response)
+		if response.StatusCode != 304 || response.Headers.Get("Etag") != gzipETag {
+			t.Errorf("Expected 304 with Etag %s for %v, but got %d %s",
+				gzipETag, headers, response.StatusCode, response.Headers.Get("Etag"))
+		}
+
+		headers.Set("If-None-Match", identityETag)
+		response = serveTestResponse(t, HttpRequest{Method: GET, Path: "/files/page.html", Headers: headers})
+		if response.StatusCode != 200 || response.Headers.Get("Content-Encoding") != "gzip" {
+			t.Errorf("Expected a gzipped 200 for the identity tag with %v, but got %d %q",
+				headers, response.StatusCode, response.Headers.Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestGetFile_NotCompressed(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	writeCompressTestFile(t, "page.html", "<p>hi</p>", time.Now())
+	writeCompressTestFile(t, "photo.png", "\x89PNG\r\n\x1a\nrest", time.Now())
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+	}{
+		{name: "no Accept-Encoding", path: "/files/page.html", acceptEncoding: ""},
+		{name: "gzip refused", path: "/files/page.html", acceptEncoding: "gzip;q=0, br;q=0"},
+		{name: "incompressible type", path: "/files/photo.png", acceptEncoding: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := getWithEncoding(t, tt.path, tt.acceptEncoding)
+			readResponseBody(t, response)
+			if response.Headers.Has("Content-Encoding") {
+				t.Errorf("Expected no Content-Encoding, but got %q", response.Headers.Get("Content-Encoding"))
+			}
+			if !response.Headers.Has("Content-Length") {
+				t.Errorf("Expected a Content-Length")
+			}
+		})
+	}
+}
+
+func TestGetFile_Precompressed(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	now := time.Now()
+	writeCompressTestFile(t, "app.js", "console.log(1)", now.Add(-time.Hour))
+	writeCompressTestFile(t, "app.js.gz", "pretend gzip", now)
+	writeCompressTestFile(t, "app.js.br", "pretend brotli", now)
+	writeCompressTestFile(t, "app.js.zst", "pretend zstd", now)
+
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+		body           string
+	}{
+		{acceptEncoding: "gzip, br", encoding: "br", body: "pretend brotli"},
+		{acceptEncoding: "gzip", encoding: "gzip", body: "pretend gzip"},
+		{acceptEncoding: "gzip, zstd", encoding: "zstd", body: "pretend zstd"},
+		{acceptEncoding: "br;q=0, gzip", encoding: "gzip", body: "pretend gzip"},
+		{acceptEncoding: "", encoding: "", body: "console.log(1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			response := getWithEncoding(t, "/files/app.js", tt.acceptEncoding)
+			if got := readResponseBody(t, response); got != tt.body {
+				t.Errorf("Expected body %q, but got %q", tt.body, got)
+			}
+			if got := response.Headers.Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Expected Content-Encoding %q, but got %q", tt.encoding, got)
+			}
+			if got := response.Headers.Get("Content-Type"); got != "text/javascript; charset=utf-8" {
+				t.Errorf("Expected the type of the original file, but got %q", got)
+			}
+		})
+	}
+}
+
+func TestGetFile_StalePrecompressedIgnored(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	now := time.Now()
+	writeCompressTestFile(t, "data.json", `{"fresh":true}`, now)
+	writeCompressTestFile(t, "data.json.br", "stale brotli", now.Add(-time.Hour))
+
+	// The file is compressed on the fly instead
+	response := getWithEncoding(t, "/files/data.json", "br")
+	body, err := decodeBrotliToString(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != `{"fresh":true}` {
+		t.Errorf("Expected the stale sibling to be skipped, but got %q", body)
+	}
+}
+
+func TestGetFile_OnTheFlyCodings(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	content := strings.Repeat("<li>item</li>\n", 500)
+	writeCompressTestFile(t, "list.html", content, time.Now())
+
+	decoders := map[string]func([]byte) (string, error){
+		"gzip": decodeGzipToString,
+		"br":   decodeBrotliToString,
+		"zstd": decodeZstdToString,
+	}
+	for coding, decode := range decoders {
+		t.Run(coding, func(t *testing.T) {
+			response := getWithEncoding(t, "/files/list.html", coding)
+			if got := response.Headers.Get("Content-Encoding"); got != coding {
+				t.Fatalf("Expected Content-Encoding %s, but got %q", coding, got)
+			}
+			decoded, err := decode(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded != content {
+				t.Errorf("Expected the decompressed body to match the file")
+			}
+			if etag := response.Headers.Get("Etag"); !strings.HasSuffix(etag, "-"+coding+"\"") {
+				t.Errorf("Expected a variant etag for %s, but got %s", coding, etag)
+			}
+		})
+	}
+}
+
+func TestIsCompressible(t *testing.T) {
+	tests := map[string]bool{
+		"text/plain; charset=utf-8": true,
+		"application/json":          true,
+		"application/ld+json":       true,
+		"image/svg+xml":             true,
+		"image/png":                 false,
+		"application/zip":           false,
+		"":                          false,
+	}
+	for contentType, want := range tests {
+		if got := isCompressible(contentType); got != want {
+			t.Errorf("Expected isCompressible(%q) = %v, but got %v", contentType, want, got)
+		}
+	}
+}
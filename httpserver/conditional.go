@@ -0,0 +1,200 @@
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ETagMode selects how entity tags are computed for served files.
+type ETagMode string
+
+const (
+	// ETagsWeak derives a weak tag from the file's size and modification
+	// time. It is cheap but only usable for weak comparison.
+	ETagsWeak ETagMode = "weak"
+	// ETagsStrong derives a strong tag from a hash of the file's content.
+	ETagsStrong ETagMode = "strong"
+)
+
+// String and Set let an ETagMode be used as a command line flag.
+func (m *ETagMode) String() string {
+	return string(*m)
+}
+
+func (m *ETagMode) Set(value string) error {
+	switch mode := ETagMode(value); mode {
+	case ETagsWeak, ETagsStrong:
+		*m = mode
+		return nil
+	}
+	return fmt.Errorf("unknown ETag mode %q (want weak or strong)", value)
+}
+
+// fileETag returns the entity tag of the file at path, including quotes
+// and the W/ prefix for weak tags.
+func fileETag(path string, info os.FileInfo, mode ETagMode) (string, error) {
+	if mode != ETagsStrong {
+		return fmt.Sprintf("W/\"%x-%x\"", info.Size(), info.ModTime().UnixNano()), nil
+	}
+	sum, err := contentHashes.get(path, info)
+	if err != nil {
+		return "", err
+	}
+	return "\"" + hex.EncodeToString(sum[:16]) + "\"", nil
+}
+
+// hashCache remembers SHA-256 content hashes so a file is only hashed again
+// once its size or modification time changes. They back both strong entity
+// tags and digest headers.
+type hashCache struct {
+	mu      sync.Mutex
+	entries map[string]hashCacheEntry
+}
+
+type hashCacheEntry struct {
+	size    int64
+	modTime time.Time
+	sum     []byte
+}
+
+// maxHashCacheEntries bounds the memory used by the cache. It is simply
+// emptied when full.
+const maxHashCacheEntries = 10000
+
+var contentHashes = &hashCache{entries: make(map[string]hashCacheEntry)}
+
+// get returns the SHA-256 of the file at path, which info describes.
+func (c *hashCache) get(path string, info os.FileInfo) ([]byte, error) {
+	if sum, ok := c.cached(path, info); ok {
+		return sum, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	sum := hash.Sum(nil)
+
+	c.mu.Lock()
+	if len(c.entries) >= maxHashCacheEntries {
+		c.entries = make(map[string]hashCacheEntry)
+	}
+	c.entries[path] = hashCacheEntry{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	c.mu.Unlock()
+	return sum, nil
+}
+
+// cached returns the SHA-256 of the file at path if it is known already,
+// without reading the file.
+func (c *hashCache) cached(path string, info os.FileInfo) ([]byte, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.sum, true
+	}
+	return nil, false
+}
+
+// parseETagList splits an If-Match or If-None-Match value into its entity
+// tags. "*" is returned as a single element. Malformed elements are skipped.
+func parseETagList(value string) []string {
+	var etags []string
+	for {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			return etags
+		}
+		if value[0] == '*' {
+			etags = append(etags, "*")
+			value = value[1:]
+			continue
+		}
+		prefix := ""
+		if strings.HasPrefix(value, "W/") {
+			prefix, value = "W/", value[2:]
+		}
+		if !strings.HasPrefix(value, "\"") {
+			// Skip to the next element
+			if i := strings.IndexByte(value, ','); i >= 0 {
+				value = value[i:]
+				continue
+			}
+			return etags
+		}
+		end := strings.IndexByte(value[1:], '"')
+		if end < 0 {
+			return etags
+		}
+		etags = append(etags, prefix+value[:end+2])
+		value = value[end+2:]
+	}
+}
+
+// etagStrongMatch implements the strong comparison of RFC 9110 section
+// 8.8.3.2: both tags must be strong and identical.
+func etagStrongMatch(a, b string) bool {
+	return !strings.HasPrefix(a, "W/") && !strings.HasPrefix(b, "W/") && a == b
+}
+
+// etagWeakMatch implements the weak comparison: the opaque tags are equal,
+// whether or not either is weak.
+func etagWeakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// etagListMatches reports whether the If-Match or If-None-Match value
+// matches etag using the given comparison. "*" matches any current
+// representation.
+func etagListMatches(value, etag string, match func(a, b string) bool) bool {
+	for _, candidate := range parseETagList(value) {
+		if candidate == "*" || match(candidate, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions evaluates the conditional request headers against the
+// current validators of a representation, in the order given by RFC 9110
+// section 13.2.2. It returns 0 if the request should proceed, or the status
+// (304 or 412) to answer with instead.
+func checkPreconditions(request HttpRequest, etag string, modTime time.Time) int {
+	modTime = modTime.Truncate(time.Second)
+	safe := request.Method == GET || request.Method == HEAD
+
+	if request.Headers.Has("If-Match") {
+		if !etagListMatches(strings.Join(request.Headers.Values("If-Match"), ","), etag, etagStrongMatch) {
+			return 412
+		}
+	} else if value := request.Headers.Get("If-Unmodified-Since"); value != "" {
+		if date, err := parseHTTPDate(value); err == nil && modTime.After(date) {
+			return 412
+		}
+	}
+
+	if request.Headers.Has("If-None-Match") {
+		if etagListMatches(strings.Join(request.Headers.Values("If-None-Match"), ","), etag, etagWeakMatch) {
+			if safe {
+				return 304
+			}
+			return 412
+		}
+	} else if value := request.Headers.Get("If-Modified-Since"); value != "" && safe {
+		if date, err := parseHTTPDate(value); err == nil && !modTime.After(date) {
+			return 304
+		}
+	}
+	return 0
+}
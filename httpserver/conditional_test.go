@@ -0,0 +1,203 @@
+package httpserver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseETagList(t *testing.T) {
+	tests := map[string][]string{
+		`"abc"`:                  {`"abc"`},
+		`"a", W/"b" ,"c,d"`:      {`"a"`, `W/"b"`, `"c,d"`},
+		`*`:                      {"*"},
+		`bogus, "ok"`:            {`"ok"`},
+		`"unterminated`:          nil,
+		``:                       nil,
+		` , W/"x",,"y"`:          {`W/"x"`, `"y"`},
+		`"a" "b"`:                {`"a"`, `"b"`},
+		`W/bogus, W/"weak-only"`: {`W/"weak-only"`},
+	}
+	for input, expected := range tests {
+		if got := parseETagList(input); !reflect.DeepEqual(got, expected) {
+			t.Errorf("parseETagList(%q): expected %v, but got %v", input, expected, got)
+		}
+	}
+}
+
+func TestETagComparison(t *testing.T) {
+	tests := []struct {
+		a, b         string
+		strong, weak bool
+	}{
+		{a: `W/"1"`, b: `W/"1"`, strong: false, weak: true},
+		{a: `W/"1"`, b: `W/"2"`, strong: false, weak: false},
+		{a: `W/"1"`, b: `"1"`, strong: false, weak: true},
+		{a: `"1"`, b: `"1"`, strong: true, weak: true},
+	}
+	for _, tt := range tests {
+		if got := etagStrongMatch(tt.a, tt.b); got != tt.strong {
+			t.Errorf("etagStrongMatch(%s, %s): expected %v, but got %v", tt.a, tt.b, tt.strong, got)
+		}
+		if got := etagWeakMatch(tt.a, tt.b); got != tt.weak {
+			t.Errorf("etagWeakMatch(%s, %s): expected %v, but got %v", tt.a, tt.b, tt.weak, got)
+		}
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	etag := `"v1"`
+	before := formatHTTPDate(modTime.Add(-time.Hour))
+	same := formatHTTPDate(modTime)
+	after := formatHTTPDate(modTime.Add(time.Hour))
+
+	tests := []struct {
+		name     string
+		method   HttpMethod
+		headers  Header
+		expected int
+	}{
+		{name: "None", method: GET, headers: Header{}, expected: 0},
+		{name: "IfNoneMatchHit", method: GET, headers: Header{"If-None-Match": {`"v0", "v1"`}}, expected: 304},
+		{name: "IfNoneMatchWeakHit", method: GET, headers: Header{"If-None-Match": {`W/"v1"`}}, expected: 304},
+		{name: "IfNoneMatchMiss", method: GET, headers: Header{"If-None-Match": {`"v0"`}}, expected: 0},
+		{name: "IfNoneMatchStar", method: GET, headers: Header{"If-None-Match": {"*"}}, expected: 304},
+		{name: "IfNoneMatchUnsafe", method: POST, headers: Header{"If-None-Match": {`"v1"`}}, expected: 412},
+		{name: "IfModifiedSinceSame", method: GET, headers: Header{"If-Modified-Since": {same}}, expected: 304},
+		{name: "IfModifiedSinceLater", method: GET, headers: Header{"If-Modified-Since": {after}}, expected: 304},
+		{name: "IfModifiedSinceEarlier", method: GET, headers: Header{"If-Modified-Since": {before}}, expected: 0},
+		{name: "IfModifiedSinceInvalid", method: GET, headers: Header{"If-Modified-Since": {"yesterday"}}, expected: 0},
+		{name: "IfModifiedSinceUnsafe", method: POST, headers: Header{"If-Modified-Since": {after}}, expected: 0},
+		{
+			name:     "IfNoneMatchOverridesIfModifiedSince",
+			method:   GET,
+			headers:  Header{"If-None-Match": {`"v0"`}, "If-Modified-Since": {after}},
+			expected: 0,
+		},
+		{name: "IfMatchHit", method: GET, headers: Header{"If-Match": {`"v1"`}}, expected: 0},
+		{name: "IfMatchStar", method: GET, headers: Header{"If-Match": {"*"}}, expected: 0},
+		{name: "IfMatchMiss", method: GET, headers: Header{"If-Match": {`"v0"`}}, expected: 412},
+		{name: "IfMatchWeak", method: GET, headers: Header{"If-Match": {`W/"v1"`}}, expected: 412},
+		{name: "IfUnmodifiedSinceSame", method: GET, headers: Header{"If-Unmodified-Since": {same}}, expected: 0},
+		{name: "IfUnmodifiedSinceEarlier", method: GET, headers: Header{"If-Unmodified-Since": {before}}, expected: 412},
+		{
+			name:     "IfMatchOverridesIfUnmodifiedSince",
+			method:   GET,
+			headers:  Header{"If-Match": {`"v1"`}, "If-Unmodified-Since": {before}},
+			expected: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := HttpRequest{Method: tt.method, Headers: tt.headers}
+			if got := checkPreconditions(request, etag, modTime.Add(500*time.Millisecond)); got != tt.expected {
+				t.Errorf("Expected %d, but got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestGetFile_Conditional(t *testing.T) {
+	path := writeRangeTestFile(t)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(testConfig.Dir, "digits.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	first := generateHttpResponse(HttpRequest{Method: GET, Path: path, Headers: Header{}})
+	readResponseBody(t, first)
+	etag := first.Headers.Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("Expected a weak ETag, but got %q", etag)
+	}
+	if got := first.Headers.Get("Last-Modified"); got != "Wed, 01 May 2024 12:00:00 GMT" {
+		t.Errorf("Expected Last-Modified Wed, 01 May 2024 12:00:00 GMT, but got %q", got)
+	}
+
+	tests := []struct {
+		name               string
+		headers            Header
+		expectedStatusCode int
+	}{
+		{name: "IfNoneMatch", headers: Header{"If-None-Match": {etag}}, expectedStatusCode: 304},
+		{name: "IfModifiedSince", headers: Header{"If-Modified-Since": {first.Headers.Get("Last-Modified")}}, expectedStatusCode: 304},
+		{name: "IfMatchWeak", headers: Header{"If-Match": {etag}}, expectedStatusCode: 412},
+		{name: "IfUnmodifiedSince", headers: Header{"If-Unmodified-Since": {formatHTTPDate(modTime.Add(-time.Hour))}}, expectedStatusCode: 412},
+		{name: "Stale", headers: Header{"If-None-Match": {`W/"old"`}}, expectedStatusCode: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := generateHttpResponse(HttpRequest{Method: GET, Path: path, Headers: tt.headers})
+			if response.StatusCode != tt.expectedStatusCode {
+				t.Errorf("Expected StatusCode %d, but got %d", tt.expectedStatusCode, response.StatusCode)
+			}
+			body := readResponseBody(t, response)
+			if tt.expectedStatusCode == 304 {
+				if body != "" {
+					t.Errorf("Expected no body on 304, but got %q", body)
+				}
+				if response.Headers.Get("ETag") != etag {
+					t.Errorf("Expected ETag %s on 304, but got %s", etag, response.Headers.Get("ETag"))
+				}
+			}
+		})
+	}
+}
+
+func TestGetFile_StrongETag(t *testing.T) {
+	defer func(previous ETagMode) { testConfig.ETags = previous }(testConfig.ETags)
+	testConfig.ETags = ETagsStrong
+	path := writeRangeTestFile(t)
+
+	response := generateHttpResponse(HttpRequest{Method: GET, Path: path, Headers: Header{}})
+	readResponseBody(t, response)
+	etag := response.Headers.Get("ETag")
+	if !strings.HasPrefix(etag, `"`) {
+		t.Fatalf("Expected a strong ETag, but got %q", etag)
+	}
+
+	// Strong tags satisfy If-Match and If-Range
+	response = generateHttpResponse(HttpRequest{
+		Method:  GET,
+		Path:    path,
+		Headers: Header{"If-Match": {etag}, "Range": {"bytes=0-2"}, "If-Range": {etag}},
+	})
+	if response.StatusCode != 206 {
+		t.Errorf("Expected StatusCode 206, but got %d", response.StatusCode)
+	}
+	if body := readResponseBody(t, response); body != "012" {
+		t.Errorf("Expected Body 012, but got %s", body)
+	}
+
+	// Changing the content changes the tag
+	later := time.Now().Add(time.Hour)
+	name := filepath.Join(testConfig.Dir, "digits.txt")
+	if err := os.WriteFile(name, []byte("9876543210"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, later, later); err != nil {
+		t.Fatal(err)
+	}
+	response = generateHttpResponse(HttpRequest{Method: GET, Path: path, Headers: Header{"If-None-Match": {etag}}})
+	if response.StatusCode != 200 {
+		t.Errorf("Expected StatusCode 200 after the file changed, but got %d", response.StatusCode)
+	}
+	readResponseBody(t, response)
+	if response.Headers.Get("ETag") == etag {
+		t.Errorf("Expected a new ETag after the file changed")
+	}
+}
+
+func TestETagMode_Set(t *testing.T) {
+	var mode ETagMode
+	if err := mode.Set("strong"); err != nil || mode != ETagsStrong {
+		t.Errorf("Expected strong mode, but got %s (%v)", mode, err)
+	}
+	if err := mode.Set("medium"); err == nil {
+		t.Errorf("Expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,128 @@
+package httpserver
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// errDigestMismatch is returned when an upload doesn't match the digest the
+// client sent with it.
+var errDigestMismatch = errors.New("content digest mismatch")
+
+// digestAlgorithms maps the lower-cased algorithm names of Content-Digest
+// (RFC 9530) and Digest (RFC 3230) to their hash functions. Other
+// algorithms are ignored.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+	"md5":     md5.New,
+}
+
+// digestCheck is one digest an upload has to match.
+type digestCheck struct {
+	algorithm string
+	hash      hash.Hash
+	expected  []byte
+}
+
+// parseUploadDigests collects the digests a request body has to match from
+// its Content-Digest, Repr-Digest, Digest and Content-MD5 headers.
+func parseUploadDigests(headers Header) ([]*digestCheck, error) {
+	var checks []*digestCheck
+	add := func(algorithm, value string) error {
+		newHash, ok := digestAlgorithms[strings.ToLower(algorithm)]
+		if !ok {
+			return nil
+		}
+		expected, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s digest %q", algorithm, value)
+		}
+		hash := newHash()
+		if len(expected) != hash.Size() {
+			return fmt.Errorf("invalid %s digest length %d", algorithm, len(expected))
+		}
+		checks = append(checks, &digestCheck{algorithm: strings.ToLower(algorithm), hash: hash, expected: expected})
+		return nil
+	}
+
+	// Content-Digest and Repr-Digest are structured field dictionaries of
+	// byte sequences, e.g. sha-256=:base64:
+	for _, key := range []string{"Content-Digest", "Repr-Digest"} {
+		for _, member := range headers.tokens(key) {
+			algorithm, value, ok := strings.Cut(member, "=")
+			value = strings.TrimSpace(value)
+			if !ok || len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+				return nil, fmt.Errorf("invalid %s member %q", key, member)
+			}
+			if err := add(strings.TrimSpace(algorithm), value[1:len(value)-1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, member := range headers.tokens("Digest") {
+		algorithm, value, ok := strings.Cut(member, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid Digest member %q", member)
+		}
+		if err := add(strings.TrimSpace(algorithm), strings.TrimSpace(value)); err != nil {
+			return nil, err
+		}
+	}
+
+	if value := headers.Get("Content-MD5"); value != "" {
+		if err := add("md5", strings.TrimSpace(value)); err != nil {
+			return nil, err
+		}
+	}
+	return checks, nil
+}
+
+// digestReader hashes a body as it is read and, once it ends, fails with
+// errDigestMismatch instead of io.EOF if any digest doesn't match.
+type digestReader struct {
+	r      io.Reader
+	checks []*digestCheck
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	for _, check := range d.checks {
+		check.hash.Write(p[:n])
+	}
+	if err == io.EOF {
+		for _, check := range d.checks {
+			if !bytes.Equal(check.hash.Sum(nil), check.expected) {
+				return n, fmt.Errorf("%w: %s", errDigestMismatch, check.algorithm)
+			}
+		}
+	}
+	return n, err
+}
+
+// verifiedBody returns the request body wrapped so reading it to the end
+// verifies any digests the client sent.
+func verifiedBody(request HttpRequest) (io.Reader, error) {
+	checks, err := parseUploadDigests(request.Headers)
+	if err != nil || len(checks) == 0 || request.Body == nil {
+		return request.Body, err
+	}
+	return &digestReader{r: request.Body, checks: checks}, nil
+}
+
+// setDigestHeaders adds the SHA-256 of a file as Repr-Digest and, for older
+// clients, Digest.
+func setDigestHeaders(headers Header, sum []byte) {
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	headers.Set("Repr-Digest", "sha-256=:"+encoded+":")
+	headers.Set("Digest", "sha-256="+encoded)
+}
@@ -0,0 +1,120 @@
+package httpserver
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseUploadDigests(t *testing.T) {
+	sum := sha256.Sum256([]byte("abc"))
+	encoded := base64.StdEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		headers Header
+		checks  int
+		wantErr bool
+	}{
+		{name: "none", headers: Header{}},
+		{name: "content digest", headers: Header{"Content-Digest": {"sha-256=:" + encoded + ":"}}, checks: 1},
+		{name: "unknown algorithm ignored", headers: Header{"Content-Digest": {"sha-256=:" + encoded + ":, crc32c=:AAAAAA==:"}}, checks: 1},
+		{name: "legacy digest", headers: Header{"Digest": {"SHA-256=" + encoded}}, checks: 1},
+		{name: "content md5", headers: Header{"Content-Md5": {"kAFQmDzST7DWlj99KOF/cg=="}}, checks: 1},
+		{name: "not a byte sequence", headers: Header{"Content-Digest": {"sha-256=" + encoded}}, wantErr: true},
+		{name: "bad base64", headers: Header{"Digest": {"sha-256=!!!"}}, wantErr: true},
+		{name: "wrong length", headers: Header{"Content-Digest": {"sha-512=:" + encoded + ":"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks, err := parseUploadDigests(tt.headers)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, but got %v", tt.wantErr, err)
+			}
+			if len(checks) != tt.checks {
+				t.Errorf("Expected %d checks, but got %d", tt.checks, len(checks))
+			}
+		})
+	}
+}
+
+func TestHandleFiles_UploadDigest(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	body := "artifact bytes"
+	sha256Sum := sha256.Sum256([]byte(body))
+	sha512Sum := sha512.Sum512([]byte(body))
+	md5Sum := md5.Sum([]byte(body))
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+	}{
+		{name: "content digest", header: "Content-Digest", value: "sha-512=:" + base64.StdEncoding.EncodeToString(sha512Sum[:]) + ":"},
+		{name: "digest", header: "Digest", value: "sha-256=" + base64.StdEncoding.EncodeToString(sha256Sum[:])},
+		{name: "content md5", header: "Content-MD5", value: base64.StdEncoding.EncodeToString(md5Sum[:])},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := fileRequest(POST, "good.bin", body)
+			request.Headers.Set(tt.header, tt.value)
+			if response := generateHttpResponse(request); response.StatusCode != 201 {
+				t.Errorf("Expected StatusCode 201, but got %d", response.StatusCode)
+			}
+
+			request = fileRequest(POST, "bad.bin", body+"!")
+			request.Headers.Set(tt.header, tt.value)
+			if response := generateHttpResponse(request); response.StatusCode != 400 {
+				t.Errorf("Expected StatusCode 400, but got %d", response.StatusCode)
+			}
+			if _, err := os.Stat(filepath.Join(testConfig.Dir, "bad.bin")); !os.IsNotExist(err) {
+				t.Errorf("Expected the mismatched upload to be removed, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestHandleFiles_AppendDigestMismatch(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(testConfig.Dir, "app.log"), []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	request := fileRequest(PATCH, "app.log", "two\n")
+	request.Headers.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(make([]byte, 32))+":")
+	if response := generateHttpResponse(request); response.StatusCode != 400 {
+		t.Errorf("Expected StatusCode 400, but got %d", response.StatusCode)
+	}
+	if content := readTestFile(t, "app.log"); content != "one\n" {
+		t.Errorf("Expected the append to be rolled back, but got %q", content)
+	}
+}
+
+func TestGetFile_ReprDigest(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(testConfig.Dir, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	response := generateHttpResponse(HttpRequest{Method: GET, Path: "/files/a.txt", Headers: Header{}})
+	readResponseBody(t, response)
+	if got := response.Headers.Get("Repr-Digest"); got != "" {
+		t.Errorf("Expected no Repr-Digest unless asked for, but got %s", got)
+	}
+
+	response = generateHttpResponse(HttpRequest{Method: GET, Path: "/files/a.txt",
+		Headers: Header{"Want-Repr-Digest": {"sha-256=1"}}})
+	defer readResponseBody(t, response)
+	sum := sha256.Sum256([]byte("hello"))
+	encoded := base64.StdEncoding.EncodeToString(sum[:])
+	if got := response.Headers.Get("Repr-Digest"); got != "sha-256=:"+encoded+":" {
+		t.Errorf("Expected Repr-Digest sha-256=:%s:, but got %s", encoded, got)
+	}
+	if got := response.Headers.Get("Digest"); got != "sha-256="+encoded {
+		t.Errorf("Expected Digest sha-256=%s, but got %s", encoded, got)
+	}
+}
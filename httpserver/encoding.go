@@ -0,0 +1,344 @@
+package httpserver
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Encoding string
+
+const (
+	GZIP     Encoding = "gzip"
+	DEFLATE  Encoding = "deflate"
+	BROTLI   Encoding = "br"
+	ZSTD     Encoding = "zstd"
+	IDENTITY Encoding = "identity"
+	UTF8     Encoding = "utf-8"
+)
+
+// CompressionLevels holds the level each content coding compresses
+// responses at.
+type CompressionLevels map[Encoding]int
+
+// compressionLevelRanges are the levels each coding accepts, from fastest
+// to smallest.
+var compressionLevelRanges = map[Encoding][2]int{
+	GZIP:    {gzip.BestSpeed, gzip.BestCompression},
+	DEFLATE: {zlib.BestSpeed, zlib.BestCompression},
+	BROTLI:  {0, 11},
+	ZSTD:    {1, 19},
+}
+
+// defaultCompressionLevels favours speed, since responses are compressed
+// as they are sent.
+var defaultCompressionLevels = CompressionLevels{GZIP: 6, DEFLATE: 6, BROTLI: 5, ZSTD: 3}
+
+// DefaultCompressionLevels returns a copy of the levels used for codings a
+// CompressionLevels doesn't mention.
+func DefaultCompressionLevels() CompressionLevels {
+	levels := make(CompressionLevels, len(defaultCompressionLevels))
+	for coding, level := range defaultCompressionLevels {
+		levels[coding] = level
+	}
+	return levels
+}
+
+// level returns the level coding compresses at.
+func (l CompressionLevels) level(coding Encoding) int {
+	if level, ok := l[coding]; ok {
+		return level
+	}
+	return defaultCompressionLevels[coding]
+}
+
+// String and Set let CompressionLevels be used as a repeatable command line
+// flag of coding=level pairs.
+func (l CompressionLevels) String() string {
+	var pairs []string
+	for coding, level := range l {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", coding, level))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l CompressionLevels) Set(value string) error {
+	name, levelText, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("compression level %q isn't coding=level", value)
+	}
+	coding := Encoding(strings.ToLower(strings.TrimSpace(name)))
+	levels, ok := compressionLevelRanges[coding]
+	if !ok {
+		return fmt.Errorf("unknown content coding %q (want gzip, deflate, br or zstd)", name)
+	}
+	level, err := strconv.Atoi(strings.TrimSpace(levelText))
+	if err != nil || level < levels[0] || level > levels[1] {
+		return fmt.Errorf("%s compression level %q isn't between %d and %d", coding, levelText, levels[0], levels[1])
+	}
+	l[coding] = level
+	return nil
+}
+
+// acceptEncoding is a parsed Accept-Encoding header, as described in RFC
+// 9110 section 12.5.3.
+type acceptEncoding struct {
+	// present is false if the request had no Accept-Encoding header, in
+	// which case responses are sent unencoded: clients that don't ask for
+	// a coding may not be able to decode one
+	present   bool
+	qualities map[Encoding]float64
+}
+
+// parseAcceptEncoding parses the Accept-Encoding header values. Malformed
+// elements are ignored.
+func parseAcceptEncoding(values []string) acceptEncoding {
+	accept := acceptEncoding{present: len(values) > 0, qualities: make(map[Encoding]float64)}
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(element, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" || (name != "*" && !isToken(name)) {
+				continue
+			}
+			q, ok := parseWeight(params)
+			if !ok {
+				continue
+			}
+			// x-gzip is an alias kept for old clients, RFC 9110 section 8.4.1.3
+			if name == "x-gzip" {
+				name = string(GZIP)
+			}
+			accept.qualities[Encoding(name)] = q
+		}
+	}
+	return accept
+}
+
+// parseWeight parses the optional ";q=" weight of an element. Other
+// parameters aren't defined for Accept-Encoding and are ignored.
+func parseWeight(params string) (float64, bool) {
+	if strings.TrimSpace(params) == "" {
+		return 1, true
+	}
+	name, value, ok := strings.Cut(params, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(name), "q") {
+		return 0, false
+	}
+	value = strings.TrimSpace(value)
+	if !isQValue(value) {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(value, 64)
+	return q, err == nil
+}
+
+// isQValue checks the qvalue grammar: 0 to 1 with at most three decimals.
+func isQValue(value string) bool {
+	whole, fraction, hasFraction := strings.Cut(value, ".")
+	if whole != "0" && whole != "1" {
+		return false
+	}
+	if !hasFraction {
+		return true
+	}
+	if len(fraction) > 3 {
+		return false
+	}
+	for i := 0; i < len(fraction); i++ {
+		if !isDigit(fraction[i]) || (whole == "1" && fraction[i] != '0') {
+			return false
+		}
+	}
+	return true
+}
+
+// implicitIdentityQuality is the quality of identity when Accept-Encoding
+// doesn't mention it, the lowest non-zero qvalue.
+const implicitIdentityQuality = 0.001
+
+// quality returns how acceptable coding is to the client, from 0 (not at
+// all) to 1.
+func (a acceptEncoding) quality(coding Encoding) float64 {
+	if !a.present {
+		if coding == IDENTITY {
+			return 1
+		}
+		return 0
+	}
+	if q, ok := a.qualities[coding]; ok {
+		return q
+	}
+	if q, ok := a.qualities["*"]; ok {
+		return q
+	}
+	// Identity is acceptable unless it is excluded, but any coding the
+	// client names is preferred to it
+	if coding == IDENTITY {
+		return implicitIdentityQuality
+	}
+	return 0
+}
+
+// negotiateEncoding picks the coding among offers the client prefers, and
+// the earliest offer among equals. It returns false if none is acceptable.
+func negotiateEncoding(values []string, offers ...Encoding) (Encoding, bool) {
+	accept := parseAcceptEncoding(values)
+	best, bestQ := Encoding(""), 0.0
+	for _, offer := range offers {
+		if q := accept.quality(offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding allows
+// coding.
+func acceptsEncoding(request HttpRequest, coding Encoding) bool {
+	return parseAcceptEncoding(request.Headers.Values("Accept-Encoding")).quality(coding) > 0
+}
+
+// responseEncoding returns the coding the response writer will apply to a
+// 200 response of contentType for request, and false if there is none the
+// client accepts. Content that doesn't compress well is sent as is unless
+// the client refuses that.
+func responseEncoding(request HttpRequest, contentType string) (Encoding, bool) {
+	values := request.Headers.Values("Accept-Encoding")
+	if !isCompressible(contentType) && parseAcceptEncoding(values).quality(IDENTITY) > 0 {
+		return IDENTITY, true
+	}
+	// At the default levels the in-tree Brotli encoder compresses text a
+	// little smaller than gzip but takes about three times as long, and
+	// the Zstandard one is both slower and larger. gzip wins ties, so br
+	// and zstd are only used when the client ranks them above it
+	return negotiateEncoding(values, GZIP, BROTLI, ZSTD, DEFLATE, IDENTITY)
+}
+
+// newEncoder returns a writer compressing into w with coding, at the level
+// levels sets for it.
+func newEncoder(w io.Writer, coding Encoding, levels CompressionLevels) io.WriteCloser {
+	level := levels.level(coding)
+	switch coding {
+	case GZIP:
+		encoder, _ := gzip.NewWriterLevel(w, level)
+		return encoder
+	case DEFLATE:
+		// The deflate content coding is deflate data in the zlib format,
+		// RFC 9110 section 8.4.1.2
+		encoder, _ := zlib.NewWriterLevel(w, level)
+		return encoder
+	case BROTLI:
+		return newBrotliWriter(w, level)
+	case ZSTD:
+		return newZstdWriter(w, level)
+	}
+	return nil
+}
+
+// errUnsupportedContentCoding is returned for uploads in a content coding
+// the server can't decode.
+var errUnsupportedContentCoding = errors.New("unsupported content coding")
+
+// decodeContentCoding undoes the content codings of a request body, listed
+// in the order they were applied. The decoded size is capped at
+// maxDecodedSize so a small compressed body can't expand without bound.
+func decodeContentCoding(codings []string, body io.Reader, maxDecodedSize int64) (io.Reader, error) {
+	decoded := false
+	for i := len(codings) - 1; i >= 0; i-- {
+		switch coding := Encoding(strings.ToLower(codings[i])); coding {
+		case IDENTITY:
+			continue
+		case GZIP, "x-gzip":
+			body = &decodingReader{src: body, open: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			}}
+		case DEFLATE:
+			body = &decodingReader{src: body, open: newDeflateReader}
+		default:
+			return nil, fmt.Errorf("%w: %s", errUnsupportedContentCoding, codings[i])
+		}
+		decoded = true
+	}
+	if decoded {
+		body = &sizeLimitReader{r: body, remaining: sizeLimit(maxDecodedSize)}
+	}
+	return body, nil
+}
+
+// newDeflateReader decodes the deflate content coding. It is meant to be
+// zlib wrapped, but some clients send raw deflate data, so that is accepted
+// too.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	// A zlib header announces deflate and is a multiple of 31
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// decodingReader opens its decompressor on the first read, so a malformed
+// body surfaces as a read error while the upload is copied. Corrupt
+// compressed data is reported as a 400.
+//
+// Decompressors may stop at the end of their stream, so once they do the
+// rest of the body is read too: that runs the digest checks of the readers
+// below, and anything found after the stream is an error.
+type decodingReader struct {
+	src  io.Reader
+	open func(io.Reader) (io.Reader, error)
+	// buffered is what the decompressor reads from; being an io.ByteReader
+	// it keeps them from reading past the end of their stream
+	buffered *bufio.Reader
+	r        io.Reader
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	if d.r == nil {
+		d.buffered = bufio.NewReader(d.src)
+		r, err := d.open(d.buffered)
+		if err != nil {
+			return 0, d.wrap(err)
+		}
+		d.r = r
+	}
+	n, err := d.r.Read(p)
+	if err == io.EOF {
+		trailing, drainErr := io.Copy(io.Discard, d.buffered)
+		if drainErr != nil {
+			return n, d.wrap(drainErr)
+		}
+		if trailing > 0 {
+			return n, badRequest("%d bytes after the compressed body", trailing)
+		}
+	}
+	return n, d.wrap(err)
+}
+
+func (d *decodingReader) wrap(err error) error {
+	var requestErr *RequestError
+	switch {
+	case err == nil, err == io.EOF, errors.As(err, &requestErr),
+		errors.Is(err, errDigestMismatch), errors.Is(err, errPayloadTooLarge):
+		return err
+	case err == io.ErrUnexpectedEOF:
+		return badRequest("truncated compressed body")
+	}
+	return badRequest("malformed compressed body: %v", err)
+}
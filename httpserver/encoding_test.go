@@ -0,0 +1,321 @@
+package httpserver
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   []string
+		expected Encoding
+		ok       bool
+	}{
+		{name: "no header", header: nil, expected: IDENTITY, ok: true},
+		{name: "empty header", header: []string{""}, expected: IDENTITY, ok: true},
+		{name: "gzip", header: []string{"gzip"}, expected: GZIP, ok: true},
+		{name: "x-gzip", header: []string{"x-gzip"}, expected: GZIP, ok: true},
+		{name: "case insensitive", header: []string{"GZip"}, expected: GZIP, ok: true},
+		{name: "q-values", header: []string{"gzip;q=0.5, deflate;q=0.8"}, expected: DEFLATE, ok: true},
+		{name: "whitespace", header: []string{"gzip ; q=0.5 ,deflate ;q=0.8"}, expected: DEFLATE, ok: true},
+		{name: "gzip refused", header: []string{"gzip;q=0"}, expected: IDENTITY, ok: true},
+		{name: "tie prefers the server", header: []string{"deflate, gzip"}, expected: GZIP, ok: true},
+		{name: "wildcard", header: []string{"*"}, expected: GZIP, ok: true},
+		{name: "wildcard with exclusion", header: []string{"*, gzip;q=0"}, expected: DEFLATE, ok: true},
+		{name: "codings not offered", header: []string{"br, zstd"}, expected: IDENTITY, ok: true},
+		{name: "identity refused", header: []string{"identity;q=0"}, ok: false},
+		{name: "everything refused", header: []string{"*;q=0"}, ok: false},
+		{name: "identity refused, gzip allowed", header: []string{"gzip, identity;q=0"}, expected: GZIP, ok: true},
+		{name: "wildcard refused but identity allowed", header: []string{"*;q=0, identity"}, expected: IDENTITY, ok: true},
+		{name: "several header lines", header: []string{"identity;q=0", "deflate"}, expected: DEFLATE, ok: true},
+		{name: "malformed q ignored", header: []string{"gzip;q=2, deflate;q=1.5, identity"}, expected: IDENTITY, ok: true},
+		{name: "too many decimals ignored", header: []string{"gzip;q=0.0001"}, expected: IDENTITY, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coding, ok := negotiateEncoding(tt.header, GZIP, DEFLATE, IDENTITY)
+			if ok != tt.ok || (ok && coding != tt.expected) {
+				t.Errorf("Expected %q %v, but got %q %v", tt.expected, tt.ok, coding, ok)
+			}
+		})
+	}
+}
+
+func TestIsQValue(t *testing.T) {
+	for value, want := range map[string]bool{
+		"0": true, "1": true, "0.5": true, "0.123": true, "1.000": true, "1.": true,
+		"0.1234": false, "1.1": false, "2": false, ".5": false, "": false, "0.a": false,
+	} {
+		if got := isQValue(value); got != want {
+			t.Errorf("Expected isQValue(%q) = %v, but got %v", value, want, got)
+		}
+	}
+}
+
+func TestResponseWriter_NotAcceptable(t *testing.T) {
+	request := HttpRequest{
+		Method:  GET,
+		Path:    "/echo/abc",
+		Version: HTTP11,
+		Headers: Header{"Accept-Encoding": {"identity;q=0, compress"}},
+	}
+	response := serveTestResponse(t, request)
+	if response.StatusCode != 406 || response.Status != "Not Acceptable" {
+		t.Errorf("Expected status line 406 Not Acceptable, but got %d %s", response.StatusCode, response.Status)
+	}
+	if string(response.Body) != notAcceptableBody {
+		t.Errorf("Expected Body %s, but got %s", notAcceptableBody, response.Body)
+	}
+
+	// Errors are sent as they are rather than replaced
+	request.Path = "/nowhere"
+	if response := serveTestResponse(t, request); response.StatusCode != 404 {
+		t.Errorf("Expected StatusCode 404, but got %d", response.StatusCode)
+	}
+}
+
+func TestResponseWriter_Deflate(t *testing.T) {
+	response := serveTestResponse(t, HttpRequest{
+		Method:  GET,
+		Path:    "/echo/deflated",
+		Headers: Header{"Accept-Encoding": {"deflate"}},
+	})
+	if got := response.Headers.Get("Content-Encoding"); got != "deflate" {
+		t.Fatalf("Expected Content-Encoding deflate, but got %q", got)
+	}
+	zr, err := zlib.NewReader(strings.NewReader(string(response.Body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "deflated" {
+		t.Errorf("Expected Body deflated, but got %q", body)
+	}
+	if got := response.Headers.Values("Vary"); len(got) != 1 || got[0] != "Accept-Encoding" {
+		t.Errorf("Expected Vary Accept-Encoding once, but got %q", got)
+	}
+}
+
+func TestResponseWriter_HeadCompressed(t *testing.T) {
+	resp, _, err := writeTestResponse(t,
+		HttpRequest{Method: HEAD, Version: HTTP11, Headers: Header{"Accept-Encoding": {"gzip"}}},
+		HttpResponse{StatusCode: 200, Headers: Header{"Content-Type": {"text/plain"}}, Body: []byte("abc")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("Expected HEAD to describe the gzip variant, but got %q", got)
+	}
+}
+
+func TestResponseEncoding_Preference(t *testing.T) {
+	tests := map[string]Encoding{
+		"gzip, deflate, br, zstd": GZIP,
+		"gzip, zstd":              GZIP,
+		"br, zstd":                BROTLI,
+		"br;q=0.5, zstd":          ZSTD,
+		"gzip;q=0.5, br":          BROTLI,
+		"*":                       GZIP,
+		"*, gzip;q=0":             BROTLI,
+	}
+	for header, expected := range tests {
+		request := HttpRequest{Headers: Header{"Accept-Encoding": {header}}}
+		if coding, _ := responseEncoding(request, "text/html"); coding != expected {
+			t.Errorf("Expected %s for %q, but got %s", expected, header, coding)
+		}
+	}
+}
+
+func TestCompressionLevels_Set(t *testing.T) {
+	levels := CompressionLevels{GZIP: 6, BROTLI: 5}
+	for _, value := range []string{"gzip=9", "BR=11", "zstd = 19"} {
+		if err := levels.Set(value); err != nil {
+			t.Errorf("Expected %q to be accepted, but got %v", value, err)
+		}
+	}
+	if got := levels.String(); got != "br=11,gzip=9,zstd=19" {
+		t.Errorf("Expected br=11,gzip=9,zstd=19, but got %s", got)
+	}
+	for _, value := range []string{"gzip", "gzip=10", "zstd=0", "br=-1", "lzma=1", "gzip=fast"} {
+		if err := levels.Set(value); err == nil {
+			t.Errorf("Expected %q to be refused", value)
+		}
+	}
+}
+
+func TestNewEncoder_Levels(t *testing.T) {
+	content := strings.Repeat("level test, ", 2000)
+	for _, coding := range []Encoding{GZIP, DEFLATE, BROTLI, ZSTD} {
+		levels := compressionLevelRanges[coding]
+		sizes := make([]int, 2)
+		for i, level := range levels {
+			var buf bytes.Buffer
+			w := newEncoder(&buf, coding, CompressionLevels{coding: level})
+			io.WriteString(w, content)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			sizes[i] = buf.Len()
+		}
+		if sizes[1] > sizes[0] {
+			t.Errorf("Expected %s level %d to be no larger than level %d, but got %d and %d",
+				coding, levels[1], levels[0], sizes[1], sizes[0])
+		}
+	}
+}
+
+func compressTestBody(t *testing.T, coding Encoding, content string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch coding {
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		w = newEncoder(&buf, coding, DefaultCompressionLevels())
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestHandleFiles_CompressedUpload(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	content := strings.Repeat("log line\n", 100)
+
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            string
+	}{
+		{name: "gzip", contentEncoding: "gzip", body: compressTestBody(t, GZIP, content)},
+		{name: "x-gzip", contentEncoding: "x-gzip", body: compressTestBody(t, GZIP, content)},
+		{name: "deflate", contentEncoding: "deflate", body: compressTestBody(t, DEFLATE, content)},
+		{name: "raw deflate", contentEncoding: "deflate", body: compressTestBody(t, "raw-deflate", content)},
+		{name: "stacked", contentEncoding: "deflate, gzip", body: compressTestBody(t, GZIP, compressTestBody(t, DEFLATE, content))},
+		{name: "identity", contentEncoding: "identity", body: content},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := fileRequest(PUT, "upload.log", tt.body)
+			request.Headers.Set("Content-Encoding", tt.contentEncoding)
+			if response := generateHttpResponse(request); response.StatusCode >= 300 {
+				t.Fatalf("Expected success, but got %d", response.StatusCode)
+			}
+			if got := readTestFile(t, "upload.log"); got != content {
+				t.Errorf("Expected the decoded content, but got %q", got)
+			}
+		})
+	}
+}
+
+func TestHandleFiles_CompressedUploadErrors(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+
+	request := fileRequest(POST, "a.txt", "data")
+	request.Headers.Set("Content-Encoding", "br")
+	response := generateHttpResponse(request)
+	if response.StatusCode != 415 {
+		t.Errorf("Expected StatusCode 415, but got %d", response.StatusCode)
+	}
+	if got := response.Headers.Get("Accept-Encoding"); got != "gzip, deflate" {
+		t.Errorf("Expected Accept-Encoding gzip, deflate, but got %q", got)
+	}
+
+	request = fileRequest(POST, "a.txt", "not gzip at all")
+	request.Headers.Set("Content-Encoding", "gzip")
+	if response := generateHttpResponse(request); response.StatusCode != 400 {
+		t.Errorf("Expected StatusCode 400 for a corrupt body, but got %d", response.StatusCode)
+	}
+
+	defer func(previous int64) { testConfig.MaxDecodedSize = previous }(testConfig.MaxDecodedSize)
+	testConfig.MaxDecodedSize = 1000
+	request = fileRequest(POST, "bomb.txt", compressTestBody(t, GZIP, strings.Repeat("0", 100000)))
+	request.Headers.Set("Content-Encoding", "gzip")
+	if response := generateHttpResponse(request); response.StatusCode != 413 {
+		t.Errorf("Expected StatusCode 413, but got %d", response.StatusCode)
+	}
+	if _, err := os.Stat(filepath.Join(testConfig.Dir, "bomb.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be stored, but got %v", err)
+	}
+}
+
+func TestHandleFiles_StoreEncoded(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	defer func(previous bool) { testConfig.StoreEncoded = previous }(testConfig.StoreEncoded)
+	testConfig.StoreEncoded = true
+
+	compressed := compressTestBody(t, GZIP, "abc")
+	request := fileRequest(POST, "a.txt.gz", compressed)
+	request.Headers.Set("Content-Encoding", "gzip")
+	if response := generateHttpResponse(request); response.StatusCode != 201 {
+		t.Fatalf("Expected StatusCode 201, but got %d", response.StatusCode)
+	}
+	if got := readTestFile(t, "a.txt.gz"); got != compressed {
+		t.Errorf("Expected the compressed bytes to be stored as sent")
+	}
+}
+
+func TestHandleFiles_CompressedUploadDigest(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+
+	// The digest covers the bytes as sent, before decoding
+	compressed := compressTestBody(t, GZIP, "abc")
+	sum := sha256.Sum256([]byte(compressed))
+	request := fileRequest(POST, "a.txt", compressed)
+	request.Headers.Set("Content-Encoding", "gzip")
+	request.Headers.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+	if response := generateHttpResponse(request); response.StatusCode != 201 {
+		t.Errorf("Expected StatusCode 201, but got %d: %s", response.StatusCode, response.Body)
+	}
+
+	// Decoders that stop at the end of their stream still let the digest
+	// of the whole body be checked
+	wrongSum := sha256.Sum256([]byte("something else"))
+	for _, coding := range []Encoding{GZIP, DEFLATE, "raw-deflate"} {
+		contentEncoding := string(coding)
+		if coding == "raw-deflate" {
+			contentEncoding = string(DEFLATE)
+		}
+		request := fileRequest(POST, "b.txt", compressTestBody(t, coding, "abc"))
+		request.Headers.Set("Content-Encoding", contentEncoding)
+		request.Headers.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(wrongSum[:])+":")
+		if response := generateHttpResponse(request); response.StatusCode != 400 {
+			t.Errorf("Expected StatusCode 400 for a %s body with the wrong digest, but got %d", coding, response.StatusCode)
+		}
+		if _, err := os.Stat(filepath.Join(testConfig.Dir, "b.txt")); !os.IsNotExist(err) {
+			t.Errorf("Expected nothing to be stored for %s, but got %v", coding, err)
+		}
+	}
+}
+
+func TestHandleFiles_CompressedUploadTrailingData(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	for _, coding := range []Encoding{GZIP, DEFLATE, "raw-deflate"} {
+		contentEncoding := string(coding)
+		if coding == "raw-deflate" {
+			contentEncoding = string(DEFLATE)
+		}
+		request := fileRequest(POST, "a.txt", compressTestBody(t, coding, "abc")+"trailing")
+		request.Headers.Set("Content-Encoding", contentEncoding)
+		if response := generateHttpResponse(request); response.StatusCode != 400 {
+			t.Errorf("Expected StatusCode 400 for data after a %s body, but got %d", coding, response.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy decides which symbolic links under the served directory may
+// be followed.
+type SymlinkPolicy string
+
+const (
+	// SymlinksWithinRoot follows symlinks only if they resolve to a path
+	// inside the served directory.
+	SymlinksWithinRoot SymlinkPolicy = "within"
+	// SymlinksDeny refuses any path that goes through a symlink.
+	SymlinksDeny SymlinkPolicy = "deny"
+	// SymlinksAllow follows symlinks wherever they point.
+	SymlinksAllow SymlinkPolicy = "allow"
+)
+
+// String and Set let a SymlinkPolicy be used as a command line flag.
+func (p *SymlinkPolicy) String() string {
+	return string(*p)
+}
+
+func (p *SymlinkPolicy) Set(value string) error {
+	switch policy := SymlinkPolicy(value); policy {
+	case SymlinksWithinRoot, SymlinksDeny, SymlinksAllow:
+		*p = policy
+		return nil
+	}
+	return fmt.Errorf("unknown symlink policy %q (want within, deny or allow)", value)
+}
+
+var (
+	// errForbiddenPath is returned for paths that try to leave the root.
+	errForbiddenPath = errors.New("path escapes the served directory")
+	// errInvalidPath is returned for paths that can't be decoded.
+	errInvalidPath = errors.New("invalid path")
+)
+
+// fileRoot confines file access to a directory. Every path handed to it is
+// decoded, checked and cleaned before being mapped onto the file system.
+type fileRoot struct {
+	dir      string
+	symlinks SymlinkPolicy
+}
+
+func newFileRoot(dir string, symlinks SymlinkPolicy) fileRoot {
+	return fileRoot{dir: dir, symlinks: symlinks}
+}
+
+// resolve maps the percent-encoded URL path urlPath, relative to the root,
+// to a file system path inside the root. The file does not need to exist.
+// It fails with errForbiddenPath if the path would escape the root.
+func (root fileRoot) resolve(urlPath string) (string, error) {
+	urlPath, _, _ = strings.Cut(urlPath, "?")
+	decoded, err := url.PathUnescape(urlPath)
+	if err != nil {
+		return "", errInvalidPath
+	}
+	if err := checkPathSegments(decoded); err != nil {
+		return "", err
+	}
+	// A second decoding must not reveal a traversal either, in case
+	// something downstream decodes the path again
+	if twice, err := url.PathUnescape(decoded); err == nil && twice != decoded {
+		if err := checkPathSegments(twice); err != nil {
+			return "", err
+		}
+	}
+
+	name := filepath.Join(root.dir, filepath.FromSlash(path.Clean("/"+decoded)))
+	if err := root.checkSymlinks(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// checkPathSegments rejects ".." segments, backslashes and NUL bytes.
+func checkPathSegments(p string) error {
+	if strings.ContainsAny(p, "\\\x00") {
+		return errForbiddenPath
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return errForbiddenPath
+		}
+	}
+	return nil
+}
+
+// checkSymlinks applies the symlink policy to name. Only the part of the path
+// that already exists is checked, so names of files about to be created can
+// be resolved too.
+func (root fileRoot) checkSymlinks(name string) error {
+	if root.symlinks == SymlinksAllow {
+		return nil
+	}
+	realRoot, err := filepath.EvalSymlinks(root.dir)
+	if os.IsNotExist(err) {
+		// Nothing exists under a missing root, so there is nothing to follow
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	existing := name
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing || len(parent) < len(filepath.Clean(root.dir)) {
+			return nil
+		}
+		existing = parent
+	}
+	realPath, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return err
+	}
+
+	if root.symlinks == SymlinksDeny {
+		// Any difference below the root means a symlink was followed
+		rel, err := filepath.Rel(filepath.Clean(root.dir), existing)
+		if err != nil || filepath.Join(realRoot, rel) != realPath {
+			return errForbiddenPath
+		}
+		return nil
+	}
+	if !isWithin(realRoot, realPath) {
+		return errForbiddenPath
+	}
+	return nil
+}
+
+// isWithin reports whether path is dir or lies below it.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,163 @@
+package httpserver
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileRoot_Resolve(t *testing.T) {
+	root := newFileRoot(t.TempDir(), SymlinksWithinRoot)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		expectedPath string
+	}{
+		{name: "Plain", urlPath: "foo.txt", expectedPath: "foo.txt"},
+		{name: "Nested", urlPath: "a/b/c.txt", expectedPath: "a/b/c.txt"},
+		{name: "Encoded", urlPath: "my%20file.txt", expectedPath: "my file.txt"},
+		{name: "DotSegment", urlPath: "./a/./b", expectedPath: "a/b"},
+		{name: "DuplicateSlashes", urlPath: "a//b", expectedPath: "a/b"},
+		{name: "Absolute", urlPath: "/etc/passwd", expectedPath: "etc/passwd"},
+		{name: "Query", urlPath: "foo.txt?download=1", expectedPath: "foo.txt"},
+		{name: "DotsInName", urlPath: "archive..tar", expectedPath: "archive..tar"},
+		{name: "Empty", urlPath: "", expectedPath: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := root.resolve(tt.urlPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := filepath.Join(root.dir, filepath.FromSlash(tt.expectedPath))
+			if got != expected {
+				t.Errorf("Expected %s, but got %s", expected, got)
+			}
+		})
+	}
+}
+
+func TestFileRoot_ResolveTraversal(t *testing.T) {
+	root := newFileRoot(t.TempDir(), SymlinksWithinRoot)
+
+	for _, urlPath := range []string{
+		"../../etc/passwd",
+		"a/../../etc/passwd",
+		"..",
+		"%2e%2e/%2e%2e/etc/passwd",
+		"%2E%2E%2F%2E%2E%2Fetc%2Fpasswd",
+		"..%2f..%2fetc%2fpasswd",
+		"%252e%252e/%252e%252e/etc/passwd",
+		"%252e%252e%252fetc%252fpasswd",
+		"..\\..\\etc\\passwd",
+		"..%5c..%5cetc%5cpasswd",
+		"foo%00.txt",
+	} {
+		if _, err := root.resolve(urlPath); !errors.Is(err, errForbiddenPath) {
+			t.Errorf("Expected %q to be forbidden, but got %v", urlPath, err)
+		}
+	}
+
+	if _, err := root.resolve("bad%zzescape"); !errors.Is(err, errInvalidPath) {
+		t.Errorf("Expected errInvalidPath, but got %v", err)
+	}
+}
+
+func TestFileRoot_Symlinks(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "inside.txt"), []byte("inside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"link-inside":      filepath.Join(dir, "inside.txt"),
+		"link-outside":     filepath.Join(outside, "secret.txt"),
+		"link-outside-dir": outside,
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Skip("symlinks not supported:", err)
+		}
+	}
+
+	tests := []struct {
+		urlPath   string
+		policy    SymlinkPolicy
+		forbidden bool
+	}{
+		{urlPath: "link-inside", policy: SymlinksWithinRoot, forbidden: false},
+		{urlPath: "link-outside", policy: SymlinksWithinRoot, forbidden: true},
+		{urlPath: "link-outside-dir/secret.txt", policy: SymlinksWithinRoot, forbidden: true},
+		{urlPath: "link-outside-dir/new-upload.txt", policy: SymlinksWithinRoot, forbidden: true},
+		{urlPath: "link-inside", policy: SymlinksDeny, forbidden: true},
+		{urlPath: "inside.txt", policy: SymlinksDeny, forbidden: false},
+		{urlPath: "new/nested/file.txt", policy: SymlinksDeny, forbidden: false},
+		{urlPath: "link-outside", policy: SymlinksAllow, forbidden: false},
+		{urlPath: "link-outside-dir/secret.txt", policy: SymlinksAllow, forbidden: false},
+	}
+	for _, tt := range tests {
+		_, err := newFileRoot(dir, tt.policy).resolve(tt.urlPath)
+		if tt.forbidden && !errors.Is(err, errForbiddenPath) {
+			t.Errorf("%s with policy %s: expected errForbiddenPath, but got %v", tt.urlPath, tt.policy, err)
+		}
+		if !tt.forbidden && err != nil {
+			t.Errorf("%s with policy %s: expected no error, but got %v", tt.urlPath, tt.policy, err)
+		}
+	}
+}
+
+func TestSymlinkPolicy_Set(t *testing.T) {
+	var policy SymlinkPolicy
+	if err := policy.Set("deny"); err != nil || policy != SymlinksDeny {
+		t.Errorf("Expected deny policy, but got %s (%v)", policy, err)
+	}
+	if err := policy.Set("sometimes"); err == nil {
+		t.Errorf("Expected an error for an unknown policy")
+	}
+}
+
+func TestHandleConnection_PathTraversal(t *testing.T) {
+	parent := t.TempDir()
+	testConfig.Dir = filepath.Join(parent, "served")
+	if err := os.Mkdir(testConfig.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(parent, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", startTestServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for _, target := range []string{
+		"/files/../secret.txt",
+		"/files/%2e%2e/secret.txt",
+		"/files/%2e%2e%2fsecret.txt",
+		"/files/%252e%252e%252fsecret.txt",
+	} {
+		resp := roundTrip(t, conn, reader, "GET "+target+" HTTP/1.1\r\n\r\n")
+		if resp.StatusCode != 403 {
+			t.Errorf("GET %s: expected StatusCode 403, but got %d", target, resp.StatusCode)
+		}
+
+		resp = roundTrip(t, conn, reader, "POST "+target+" HTTP/1.1\r\nContent-Length: 5\r\n\r\npwned")
+		if resp.StatusCode != 403 {
+			t.Errorf("POST %s: expected StatusCode 403, but got %d", target, resp.StatusCode)
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(parent, "secret.txt"))
+	if err != nil || string(content) != "secret" {
+		t.Errorf("Expected the file outside the root to be untouched, got %q (%v)", content, err)
+	}
+}
@@ -0,0 +1,671 @@
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// handleFiles serves the /files/ routes out of directory.
+func (c *Config) handleFiles(request HttpRequest) HttpResponse {
+	fileName := request.PathValue("path")
+	root := newFileRoot(c.Dir, c.Symlinks)
+	filePath, err := root.resolve(fileName)
+	if err != nil {
+		return pathErrorResponse(err)
+	}
+	if isUploadStatePath(root, filePath) {
+		return textResponse(404, "File not found")
+	}
+
+	switch request.Method {
+	case GET, HEAD:
+		if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+			return c.getDirectory(root, fileName, filePath, request)
+		}
+		return c.getFile(filePath, request)
+	case POST:
+		boundary, isMultipart, err := multipartBoundary(request.Headers.Get("Content-Type"))
+		if err != nil {
+			return writeErrorResponse(err)
+		}
+		if isMultipart {
+			return c.postMultipart(root, fileName, filePath, boundary, request)
+		}
+		return c.postFile(filePath, request)
+	case PUT:
+		return c.putFile(filePath, request)
+	case PATCH:
+		return c.appendFile(filePath, request)
+	case DELETE:
+		return c.deleteFile(filePath, request)
+	}
+	allowed := map[HttpMethod]bool{HEAD: true, OPTIONS: true}
+	for _, method := range fileMethods {
+		allowed[method] = true
+	}
+	return methodNotAllowed(allowed)
+}
+
+func (c *Config) getFile(filePathToServe string, request HttpRequest) HttpResponse {
+	file, err := os.Open(filePathToServe)
+	if err != nil {
+		return textResponse(404, "File not found")
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		return textResponse(500, "Error reading file")
+	}
+
+	contentType, err := detectContentType(filePathToServe, file, c.MimeTypes)
+	if err != nil {
+		file.Close()
+		return textResponse(500, "Error reading file")
+	}
+
+	// A pre-compressed sibling is served as is, ranges and all, in place
+	// of compressing the file on every request
+	var encoding Encoding
+	if sibling, siblingInfo, coding, ok := openPrecompressed(filePathToServe, request); ok {
+		file.Close()
+		file, info, encoding = sibling, siblingInfo, coding
+		filePathToServe += precompressedSuffix(coding)
+	}
+
+	etag, err := fileETag(filePathToServe, info, c.ETags)
+	if err != nil {
+		file.Close()
+		return textResponse(500, "Error reading file")
+	}
+	size := info.Size()
+	// A Range header that is ignored, for its unit, its syntax or If-Range,
+	// leaves a full response that may be compressed, so which ranges will be
+	// served is settled before the preconditions. An unsatisfiable range is
+	// only reported once they pass.
+	var ranges []byteRange
+	var rangeErr error
+	if request.Headers.Has("Range") && ifRangeMatches(request.Headers.Get("If-Range"), etag, info.ModTime()) {
+		ranges, rangeErr = parseRange(request.Headers.Get("Range"), size)
+	}
+
+	// Preconditions are evaluated against the tag of the variant the
+	// response writer will send
+	conditionETag := etag
+	if encoding == "" && len(ranges) == 0 {
+		if coding, ok := responseEncoding(request, contentType); ok && coding != IDENTITY {
+			conditionETag = variantETag(etag, coding)
+		}
+	}
+
+	headers := Header{
+		"Content-Type":  {contentType},
+		"Accept-Ranges": {"bytes"},
+		"Etag":          {etag},
+		"Last-Modified": {formatHTTPDate(info.ModTime())},
+		"Vary":          {"Accept-Encoding"},
+	}
+	if encoding != "" {
+		headers.Set("Content-Encoding", string(encoding))
+	}
+	if c.NoSniff {
+		headers.Set("X-Content-Type-Options", "nosniff")
+	}
+	// Hashing reads the whole file, so digests are only sent when the
+	// client asks for them or the hash is at hand anyway
+	if c.ETags == ETagsStrong || request.Headers.Has("Want-Repr-Digest") || request.Headers.Has("Want-Digest") {
+		if sum, err := contentHashes.get(filePathToServe, info); err == nil {
+			setDigestHeaders(headers, sum)
+		}
+	} else if sum, ok := contentHashes.cached(filePathToServe, info); ok {
+		setDigestHeaders(headers, sum)
+	}
+
+	if status := checkPreconditions(request, conditionETag, info.ModTime()); status != 0 {
+		file.Close()
+		if status == 304 {
+			return HttpResponse{
+				StatusCode: 304,
+				Status:     "Not Modified",
+				Headers: Header{
+					"Etag":          {conditionETag},
+					"Last-Modified": {formatHTTPDate(info.ModTime())},
+					"Vary":          {"Accept-Encoding"},
+				},
+			}
+		}
+		return textResponse(412, "Precondition Failed")
+	}
+
+	if rangeErr != nil {
+		file.Close()
+		headers.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		headers.Set("Content-Type", "text/plain")
+		return HttpResponse{
+			StatusCode: 416,
+			Status:     "Range Not Satisfiable",
+			Headers:    headers,
+			Body:       []byte("Range Not Satisfiable"),
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		// Stream the file rather than loading it into memory
+		headers.Set("Content-Length", fmt.Sprintf("%d", size))
+		return HttpResponse{
+			StatusCode: 200,
+			Status:     "OK",
+			Headers:    headers,
+			BodyReader: file,
+		}
+	case 1:
+		headers.Set("Content-Range", ranges[0].contentRange(size))
+		headers.Set("Content-Length", fmt.Sprintf("%d", ranges[0].length))
+		return HttpResponse{
+			StatusCode: 206,
+			Status:     "Partial Content",
+			Headers:    headers,
+			BodyReader: readCloser{io.NewSectionReader(file, ranges[0].start, ranges[0].length), file},
+		}
+	}
+
+	body, length, multipartType := multipartByteranges(file, size, contentType, ranges)
+	headers.Set("Content-Type", multipartType)
+	headers.Set("Content-Length", fmt.Sprintf("%d", length))
+	return HttpResponse{
+		StatusCode: 206,
+		Status:     "Partial Content",
+		Headers:    headers,
+		BodyReader: readCloser{body, file},
+	}
+}
+
+func (c *Config) postFile(filePathToSave string, request HttpRequest) HttpResponse {
+	if info, err := os.Stat(filePathToSave); err == nil && info.IsDir() {
+		return textResponse(409, "Is a directory")
+	}
+	if response, ok := c.checkWritePreconditions(filePathToSave, request); !ok {
+		return response
+	}
+	body, response, ok := c.uploadBody(request, c.StoreEncoded)
+	if !ok {
+		return response
+	}
+	if err := c.createParentDirs(filePathToSave); err != nil {
+		return writeErrorResponse(err)
+	}
+	if err := saveFile(filePathToSave, body, !noClobber(request)); err != nil {
+		return writeErrorResponse(err)
+	}
+	return HttpResponse{
+		StatusCode: 201,
+		Status:     "Created",
+	}
+}
+
+// storedFile describes one file saved from a form upload.
+type storedFile struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"`
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType,omitempty"`
+	SHA256      string `json:"sha256"`
+}
+
+// postMultipart stores the files of a multipart/form-data upload. If the
+// URL names a directory each file is saved in it under its own filename,
+// otherwise the single file in the form is saved at the URL path. Files are
+// only moved into place once the whole body has been read, and the answer
+// is a JSON manifest of what was stored.
+func (c *Config) postMultipart(root fileRoot, fileName, filePath, boundary string, request HttpRequest) HttpResponse {
+	urlPath, _, _ := strings.Cut(fileName, "?")
+	intoDir := urlPath == "" || strings.HasSuffix(urlPath, "/")
+	if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+		intoDir = true
+	}
+
+	// The form has to be decoded to be parsed
+	body, response, ok := c.uploadBody(request, false)
+	if !ok {
+		return response
+	}
+	reader := newMultipartReader(&sizeLimitReader{r: body, remaining: sizeLimit(c.MaxFormSize)}, boundary)
+
+	type pendingFile struct {
+		tempPath string
+		path     string
+	}
+	var pending []pendingFile
+	defer func() {
+		for _, p := range pending {
+			os.Remove(p.tempPath)
+		}
+	}()
+	var stored []storedFile
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return writeErrorResponse(err)
+		}
+		field, filename, err := part.formDataParams()
+		if err != nil {
+			return writeErrorResponse(err)
+		}
+		if filename == "" {
+			// Plain form fields aren't stored
+			continue
+		}
+
+		target := urlPath
+		if intoDir {
+			name, ok := formFileName(filename)
+			if !ok {
+				return textResponse(400, "Invalid filename")
+			}
+			target = path.Join(urlPath, url.PathEscape(name))
+		} else if len(stored) > 0 {
+			return textResponse(400, "Several files need a directory path")
+		}
+		targetPath, err := root.resolve(target)
+		if err != nil {
+			return pathErrorResponse(err)
+		}
+		if response, ok := c.checkWritePreconditions(targetPath, request); !ok {
+			return response
+		}
+
+		hash := sha256.New()
+		content := io.TeeReader(&sizeLimitReader{r: part, remaining: sizeLimit(c.MaxPartSize)}, hash)
+		if err := c.createParentDirs(targetPath); err != nil {
+			return writeErrorResponse(err)
+		}
+		tempPath, size, err := writeTempFile(targetPath, content)
+		if err != nil {
+			return writeErrorResponse(err)
+		}
+		pending = append(pending, pendingFile{tempPath: tempPath, path: targetPath})
+		displayPath, _ := url.PathUnescape(target)
+		stored = append(stored, storedFile{
+			Field:       field,
+			Filename:    filename,
+			Path:        "/files/" + displayPath,
+			Size:        size,
+			ContentType: part.Header.Get("Content-Type"),
+			SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		})
+	}
+	if err := reader.finish(); err != nil {
+		return writeErrorResponse(err)
+	}
+	if len(stored) == 0 {
+		return textResponse(400, "No files in form")
+	}
+
+	for _, p := range pending {
+		if err := commitTempFile(p.tempPath, p.path, !noClobber(request)); err != nil {
+			return writeErrorResponse(err)
+		}
+	}
+
+	manifest, err := json.Marshal(struct {
+		Files []storedFile `json:"files"`
+	}{Files: stored})
+	if err != nil {
+		return textResponse(500, "Error encoding manifest")
+	}
+	return HttpResponse{
+		StatusCode: 201,
+		Status:     "Created",
+		Headers:    Header{"Content-Type": {"application/json"}},
+		Body:       manifest,
+	}
+}
+
+// formFileName reduces the filename of a form part to its last path
+// element, since some clients send the full path of the file.
+func formFileName(filename string) (string, bool) {
+	if i := strings.LastIndexAny(filename, `/\`); i >= 0 {
+		filename = filename[i+1:]
+	}
+	if filename == "" || filename == "." || filename == ".." || strings.ContainsRune(filename, 0) {
+		return "", false
+	}
+	return filename, true
+}
+
+// sizeLimit returns a fresh counter for a sizeLimitReader, or nil if limit
+// is zero.
+func sizeLimit(limit int64) *int64 {
+	if limit <= 0 {
+		return nil
+	}
+	return &limit
+}
+
+// putFile creates or replaces the file at filePath with the request body.
+// It answers 201 if the file is new and 204 if it replaced an existing one.
+func (c *Config) putFile(filePath string, request HttpRequest) HttpResponse {
+	info, err := os.Stat(filePath)
+	existed := err == nil
+	if existed && info.IsDir() {
+		return textResponse(409, "Is a directory")
+	}
+	if response, ok := c.checkWritePreconditions(filePath, request); !ok {
+		return response
+	}
+	body, response, ok := c.uploadBody(request, c.StoreEncoded)
+	if !ok {
+		return response
+	}
+	if err := c.createParentDirs(filePath); err != nil {
+		return writeErrorResponse(err)
+	}
+	if err := saveFile(filePath, body, !noClobber(request)); err != nil {
+		return writeErrorResponse(err)
+	}
+	if existed {
+		return HttpResponse{StatusCode: 204, Status: "No Content"}
+	}
+	return HttpResponse{StatusCode: 201, Status: "Created"}
+}
+
+// appendFile appends the request body to the file at filePath, creating it
+// if needed, for log style uploads. It answers 201 if the file is new and
+// 204 otherwise.
+func (c *Config) appendFile(filePath string, request HttpRequest) HttpResponse {
+	unlock := lockPath(filePath)
+	defer unlock()
+
+	info, err := os.Stat(filePath)
+	existed := err == nil
+	if existed && !info.Mode().IsRegular() {
+		return textResponse(409, "Not a regular file")
+	}
+	if response, ok := c.checkWritePreconditions(filePath, request); !ok {
+		return response
+	}
+	body, response, ok := c.uploadBody(request, c.StoreEncoded)
+	if !ok {
+		return response
+	}
+
+	if err := c.createParentDirs(filePath); err != nil {
+		return writeErrorResponse(err)
+	}
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return writeErrorResponse(err)
+	}
+	_, err = io.Copy(file, body)
+	if err != nil {
+		// Take back whatever part of the body made it into the file
+		if existed {
+			file.Truncate(info.Size())
+		} else {
+			os.Remove(filePath)
+		}
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return writeErrorResponse(err)
+	}
+	if existed {
+		return HttpResponse{StatusCode: 204, Status: "No Content"}
+	}
+	return HttpResponse{StatusCode: 201, Status: "Created"}
+}
+
+// deleteFile removes the file at filePath.
+func (c *Config) deleteFile(filePath string, request HttpRequest) HttpResponse {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return textResponse(404, "File not found")
+	}
+	if info.IsDir() {
+		return textResponse(409, "Is a directory")
+	}
+	if response, ok := c.checkWritePreconditions(filePath, request); !ok {
+		return response
+	}
+	if err := os.Remove(filePath); err != nil {
+		if os.IsNotExist(err) {
+			return textResponse(404, "File not found")
+		}
+		return textResponse(500, "Error deleting file")
+	}
+	return HttpResponse{StatusCode: 204, Status: "No Content"}
+}
+
+// uploadBody returns the body of an upload to store: checked against the
+// digests the client sent, which cover the bytes as sent, and then decoded
+// from its content coding unless storeAsIs is set. If the headers are
+// unusable it returns the response to send and false.
+func (c *Config) uploadBody(request HttpRequest, storeAsIs bool) (io.Reader, HttpResponse, bool) {
+	body, err := verifiedBody(request)
+	if err != nil {
+		return nil, textResponse(400, "Malformed digest header"), false
+	}
+	if storeAsIs {
+		return body, HttpResponse{}, true
+	}
+	body, err = decodeContentCoding(request.Headers.tokens("Content-Encoding"), body, c.MaxDecodedSize)
+	if err != nil {
+		response := textResponse(415, "Unsupported Content-Encoding")
+		response.Headers.Set("Accept-Encoding", string(GZIP)+", "+string(DEFLATE))
+		return nil, response, false
+	}
+	return body, HttpResponse{}, true
+}
+
+// checkWritePreconditions evaluates If-Match, If-None-Match and friends for
+// a request that modifies the file at filePath. It returns the response to
+// send and false if a precondition failed.
+func (c *Config) checkWritePreconditions(filePath string, request HttpRequest) (HttpResponse, bool) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		// If-Match can only match a current representation
+		if request.Headers.Has("If-Match") {
+			return textResponse(412, "Precondition Failed"), false
+		}
+		return HttpResponse{}, true
+	}
+	etag, err := fileETag(filePath, info, c.ETags)
+	if err != nil {
+		return textResponse(500, "Error reading file"), false
+	}
+	if status := checkPreconditions(request, etag, info.ModTime()); status != 0 {
+		return textResponse(status, StatusText(status)), false
+	}
+	return HttpResponse{}, true
+}
+
+// writeErrorResponse answers a failed upload. Errors from reading a
+// malformed request body are the client's fault.
+func writeErrorResponse(err error) HttpResponse {
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) {
+		return textResponse(requestErr.StatusCode, "Malformed request body")
+	}
+	if errors.Is(err, errPayloadTooLarge) {
+		return textResponse(413, "Payload Too Large")
+	}
+	if errors.Is(err, errDigestMismatch) {
+		return textResponse(400, "Digest mismatch")
+	}
+	if errors.Is(err, errFileExists) {
+		return textResponse(412, "Precondition Failed")
+	}
+	if os.IsNotExist(err) {
+		return textResponse(409, "Parent directory does not exist")
+	}
+	return textResponse(500, "Error writing file")
+}
+
+// pathErrorResponse answers a request whose path fileRoot refused.
+func pathErrorResponse(err error) HttpResponse {
+	if errors.Is(err, errForbiddenPath) {
+		return textResponse(403, "Forbidden")
+	}
+	if errors.Is(err, errInvalidPath) {
+		return textResponse(400, "Invalid path")
+	}
+	return textResponse(500, "Error resolving path")
+}
+
+// uploadTempPattern names the temporary files uploads are written to before
+// they are renamed into place.
+const uploadTempPattern = ".upload-*.tmp"
+
+// errFileExists is returned by saveFile when overwrite is false and the
+// destination already exists.
+var errFileExists = errors.New("file already exists")
+
+// isUploadTemp reports whether name is an in-progress upload.
+func isUploadTemp(name string) bool {
+	matched, _ := filepath.Match(uploadTempPattern, name)
+	return matched
+}
+
+// noClobber reports whether the request asked with "If-None-Match: *" that
+// an existing file not be replaced.
+func noClobber(request HttpRequest) bool {
+	for _, tag := range parseETagList(strings.Join(request.Headers.Values("If-None-Match"), ",")) {
+		if tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// createParentDirs creates the missing parent directories of path when
+// CreateDirs is enabled.
+func (c *Config) createParentDirs(path string) error {
+	if !c.CreateDirs {
+		return nil
+	}
+	return os.MkdirAll(filepath.Dir(path), 0755)
+}
+
+// saveFile atomically stores body at path. The body is written to a
+// temporary file in the same directory and synced before being renamed
+// into place, so readers never see a partial upload. Unless overwrite is
+// set an existing file is left alone and errFileExists returned.
+func saveFile(path string, body io.Reader, overwrite bool) error {
+	tempPath, _, err := writeTempFile(path, body)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath)
+	return commitTempFile(tempPath, path, overwrite)
+}
+
+// writeTempFile writes body to a synced temporary file next to path and
+// returns its name and size. The caller must remove it if it isn't
+// committed.
+func writeTempFile(path string, body io.Reader) (string, int64, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), uploadTempPattern)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var size int64
+	if body != nil {
+		size, err = io.Copy(file, body)
+	}
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", 0, err
+	}
+	return file.Name(), size, nil
+}
+
+// commitTempFile moves a file written by writeTempFile to path. Unless
+// overwrite is set an existing file is left alone and errFileExists
+// returned.
+func commitTempFile(tempPath, path string, overwrite bool) error {
+	var err error
+	if overwrite {
+		err = os.Rename(tempPath, path)
+	} else if err = os.Link(tempPath, path); os.IsExist(err) {
+		// Linking fails rather than replacing, which closes the race
+		// between checking for the file and creating it
+		err = errFileExists
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes a directory so a rename into it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return err
+	}
+	return nil
+}
+
+// pathLocks serializes appends to the same file so concurrent requests
+// don't interleave their bodies.
+var pathLocks = struct {
+	sync.Mutex
+	locks map[string]*pathLock
+}{locks: make(map[string]*pathLock)}
+
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockPath locks path and returns the function that unlocks it.
+func lockPath(path string) func() {
+	pathLocks.Lock()
+	lock, ok := pathLocks.locks[path]
+	if !ok {
+		lock = &pathLock{}
+		pathLocks.locks[path] = lock
+	}
+	lock.refs++
+	pathLocks.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		pathLocks.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(pathLocks.locks, path)
+		}
+		pathLocks.Unlock()
+	}
+}
@@ -0,0 +1,272 @@
+package httpserver
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func fileRequest(method HttpMethod, name string, body string) HttpRequest {
+	return HttpRequest{
+		Method:  method,
+		Path:    "/files/" + name,
+		Version: HTTP11,
+		Headers: Header{},
+		Body:    strings.NewReader(body),
+	}
+}
+
+func readTestFile(t *testing.T, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(testConfig.Dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestHandleFiles_Put(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+
+	response := generateHttpResponse(fileRequest(PUT, "new.txt", "first"))
+	if response.StatusCode != 201 {
+		t.Errorf("Expected StatusCode 201 for a new file, but got %d", response.StatusCode)
+	}
+	response = generateHttpResponse(fileRequest(PUT, "new.txt", "second"))
+	if response.StatusCode != 204 {
+		t.Errorf("Expected StatusCode 204 for a replaced file, but got %d", response.StatusCode)
+	}
+	if content := readTestFile(t, "new.txt"); content != "second" {
+		t.Errorf("Expected content second, but got %q", content)
+	}
+
+	if err := os.Mkdir(filepath.Join(testConfig.Dir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	response = generateHttpResponse(fileRequest(PUT, "dir", "x"))
+	if response.StatusCode != 409 {
+		t.Errorf("Expected StatusCode 409 for a directory, but got %d", response.StatusCode)
+	}
+}
+
+func TestHandleFiles_PutPreconditions(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+
+	request := fileRequest(PUT, "new.txt", "x")
+	request.Headers.Set("If-Match", "*")
+	if response := generateHttpResponse(request); response.StatusCode != 412 {
+		t.Errorf("Expected StatusCode 412 for If-Match on a missing file, but got %d", response.StatusCode)
+	}
+
+	generateHttpResponse(fileRequest(PUT, "new.txt", "x"))
+	request = fileRequest(PUT, "new.txt", "y")
+	request.Headers.Set("If-Match", `"stale"`)
+	if response := generateHttpResponse(request); response.StatusCode != 412 {
+		t.Errorf("Expected StatusCode 412 for a stale If-Match, but got %d", response.StatusCode)
+	}
+	if content := readTestFile(t, "new.txt"); content != "x" {
+		t.Errorf("Expected content x, but got %q", content)
+	}
+}
+
+func TestHandleFiles_Delete(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(testConfig.Dir, "gone.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	response := generateHttpResponse(fileRequest(DELETE, "gone.txt", ""))
+	if response.StatusCode != 204 {
+		t.Errorf("Expected StatusCode 204, but got %d", response.StatusCode)
+	}
+	if _, err := os.Stat(filepath.Join(testConfig.Dir, "gone.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected file to be deleted, but got %v", err)
+	}
+	response = generateHttpResponse(fileRequest(DELETE, "gone.txt", ""))
+	if response.StatusCode != 404 {
+		t.Errorf("Expected StatusCode 404, but got %d", response.StatusCode)
+	}
+}
+
+func TestHandleFiles_PatchAppends(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+
+	response := generateHttpResponse(fileRequest(PATCH, "app.log", "one\n"))
+	if response.StatusCode != 201 {
+		t.Errorf("Expected StatusCode 201 for a new file, but got %d", response.StatusCode)
+	}
+	response = generateHttpResponse(fileRequest(PATCH, "app.log", "two\n"))
+	if response.StatusCode != 204 {
+		t.Errorf("Expected StatusCode 204, but got %d", response.StatusCode)
+	}
+	if content := readTestFile(t, "app.log"); content != "one\ntwo\n" {
+		t.Errorf("Expected both lines, but got %q", content)
+	}
+}
+
+func TestHandleFiles_PatchConcurrent(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	line := strings.Repeat("x", 1000) + "\n"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			generateHttpResponse(fileRequest(PATCH, "app.log", line))
+		}()
+	}
+	wg.Wait()
+
+	content := readTestFile(t, "app.log")
+	if content != strings.Repeat(line, 20) {
+		t.Errorf("Expected 20 whole lines, but got %d bytes", len(content))
+	}
+}
+
+func TestHandleFiles_MethodNotAllowed(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+
+	response := generateHttpResponse(fileRequest("TRACE", "a.txt", ""))
+	if response.StatusCode != 405 {
+		t.Errorf("Expected StatusCode 405, but got %d", response.StatusCode)
+	}
+	expectedAllow := "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+	if allow := response.Headers.Get("Allow"); allow != expectedAllow {
+		t.Errorf("Expected Allow %q, but got %q", expectedAllow, allow)
+	}
+
+	// The handler answers the same way if a method reaches it unrouted
+	request := fileRequest("TRACE", "a.txt", "")
+	request.Params = map[string]string{"path": "a.txt"}
+	response = testConfig.handleFiles(request)
+	if allow := response.Headers.Get("Allow"); response.StatusCode != 405 || allow != expectedAllow {
+		t.Errorf("Expected 405 with Allow %q, but got %d %q", expectedAllow, response.StatusCode, allow)
+	}
+}
+
+func TestHandleConnection_Head(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(testConfig.Dir, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", startTestServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	if _, err := conn.Write([]byte("HEAD /files/a.txt HTTP/1.1\r\nHost: x\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(reader, &http.Request{Method: "HEAD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected StatusCode 200, but got %d", resp.StatusCode)
+	}
+	if resp.ContentLength != 5 {
+		t.Errorf("Expected Content-Length 5, but got %d", resp.ContentLength)
+	}
+	if resp.Header.Get("Etag") == "" {
+		t.Errorf("Expected an Etag header")
+	}
+
+	// The next response must start right after the HEAD headers
+	resp = roundTrip(t, conn, reader, "GET /files/a.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected StatusCode 200, but got %d", resp.StatusCode)
+	}
+}
+
+func TestHandleFiles_CreatesParentDirs(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+
+	response := generateHttpResponse(fileRequest(POST, "a/b/c.txt", "nested"))
+	if response.StatusCode != 201 {
+		t.Fatalf("Expected StatusCode 201, but got %d", response.StatusCode)
+	}
+	if content := readTestFile(t, "a/b/c.txt"); content != "nested" {
+		t.Errorf("Expected content nested, but got %q", content)
+	}
+
+	defer func(previous bool) { testConfig.CreateDirs = previous }(testConfig.CreateDirs)
+	testConfig.CreateDirs = false
+	response = generateHttpResponse(fileRequest(POST, "x/y.txt", "nested"))
+	if response.StatusCode != 409 {
+		t.Errorf("Expected StatusCode 409 without --create-dirs, but got %d", response.StatusCode)
+	}
+}
+
+func TestHandleFiles_IfNoneMatchStar(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+
+	request := fileRequest(POST, "once.txt", "first")
+	request.Headers.Set("If-None-Match", "*")
+	if response := generateHttpResponse(request); response.StatusCode != 201 {
+		t.Errorf("Expected StatusCode 201, but got %d", response.StatusCode)
+	}
+	request = fileRequest(POST, "once.txt", "second")
+	request.Headers.Set("If-None-Match", "*")
+	if response := generateHttpResponse(request); response.StatusCode != 412 {
+		t.Errorf("Expected StatusCode 412, but got %d", response.StatusCode)
+	}
+	if content := readTestFile(t, "once.txt"); content != "first" {
+		t.Errorf("Expected content first, but got %q", content)
+	}
+}
+
+func TestSaveFile_NoClobberRace(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	path := filepath.Join(testConfig.Dir, "race.txt")
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := saveFile(path, strings.NewReader("x"), false); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Errorf("Expected exactly one upload to win, but got %d", created)
+	}
+}
+
+func TestSaveFile_FailedUploadLeavesNoTrace(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(testConfig.Dir, "keep.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A chunked body that ends early fails mid-upload
+	request := fileRequest(PUT, "keep.txt", "")
+	request.Body = newChunkedReader(bufio.NewReader(strings.NewReader("3\r\nnew\r\n")), Header{})
+	if response := generateHttpResponse(request); response.StatusCode < 400 {
+		t.Errorf("Expected the upload to fail, but got %d", response.StatusCode)
+	}
+	if content := readTestFile(t, "keep.txt"); content != "old" {
+		t.Errorf("Expected the old content to survive, but got %q", content)
+	}
+	entries, err := os.ReadDir(testConfig.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected the temporary file to be removed, but found %d entries", len(entries))
+	}
+}
@@ -0,0 +1,77 @@
+package httpserver
+
+import (
+	"log"
+)
+
+// Handler answers a request by writing the response to w. The request body
+// has to be read before the response header is written; whatever is left of
+// it then is discarded.
+type Handler interface {
+	ServeHTTP(w ResponseWriter, request HttpRequest)
+}
+
+// HandlerFunc lets an ordinary function be used as a Handler.
+type HandlerFunc func(w ResponseWriter, request HttpRequest)
+
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, request HttpRequest) {
+	f(w, request)
+}
+
+// ResponseFunc is a Handler that builds the whole response before any of it
+// is written, as the server's routes do.
+type ResponseFunc func(request HttpRequest) HttpResponse
+
+func (f ResponseFunc) ServeHTTP(w ResponseWriter, request HttpRequest) {
+	if err := writeHttpResponse(w, f(request)); err != nil {
+		abortResponse(w, err)
+	}
+}
+
+// Middleware wraps a Handler with behaviour shared by every request, such as
+// logging or compression.
+type Middleware func(next Handler) Handler
+
+// Chain wraps handler in middleware. The first middleware is the outermost:
+// it sees the request first and the response last.
+func Chain(handler Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// LogRequests returns the middleware that logs the method, path and
+// response status of each request to logger.
+func LogRequests(logger *log.Logger) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, request HttpRequest) {
+			sw := &statusWriter{ResponseWriter: w, statusCode: 200}
+			next.ServeHTTP(sw, request)
+			logger.Printf("%s %s -> %d", request.Method, request.Path, sw.statusCode)
+		})
+	}
+}
+
+// statusWriter remembers the status code a handler sent.
+type statusWriter struct {
+	ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(statusCode int) {
+	if !sw.wroteHeader {
+		sw.wroteHeader = true
+		sw.statusCode = statusCode
+	}
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sw *statusWriter) discardsBody() bool {
+	return discardsBody(sw.ResponseWriter)
+}
+
+func (sw *statusWriter) abort(err error) {
+	abortResponse(sw.ResponseWriter, err)
+}
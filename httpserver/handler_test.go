@@ -0,0 +1,122 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+// serveTestHandler runs handler for request on a response writer and parses
+// what it wrote.
+func serveTestHandler(t *testing.T, handler Handler, request HttpRequest) (int, Header, string) {
+	t.Helper()
+	if request.Version == "" {
+		request.Version = HTTP11
+	}
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	rw := newResponseWriter(w, request, true)
+	handler.ServeHTTP(rw, request)
+	if _, err := rw.finish(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := readResponse(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("Error parsing %q: %v", buf.String(), err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, Header(resp.Header), string(body)
+}
+
+func TestChain_Order(t *testing.T) {
+	var calls []string
+	tag := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(w ResponseWriter, request HttpRequest) {
+				calls = append(calls, name+" in")
+				w.Header().Add("X-Middleware", name)
+				next.ServeHTTP(w, request)
+				calls = append(calls, name+" out")
+			})
+		}
+	}
+	handler := Chain(HandlerFunc(func(w ResponseWriter, request HttpRequest) {
+		calls = append(calls, "handler")
+		io.WriteString(w, "done")
+	}), tag("outer"), tag("inner"))
+
+	_, header, body := serveTestHandler(t, handler, HttpRequest{Method: GET, Path: "/", Headers: Header{}})
+	if body != "done" {
+		t.Errorf("Expected Body done, but got %q", body)
+	}
+	expected := "outer in, inner in, handler, inner out, outer out"
+	if got := strings.Join(calls, ", "); got != expected {
+		t.Errorf("Expected calls %q, but got %q", expected, got)
+	}
+	if got := strings.Join(header.Values("X-Middleware"), ", "); got != "outer, inner" {
+		t.Errorf("Expected X-Middleware outer, inner, but got %q", got)
+	}
+}
+
+func TestLogRequests_Status(t *testing.T) {
+	var logged bytes.Buffer
+	handler := HandlerFunc(func(w ResponseWriter, request HttpRequest) {
+		w.WriteHeader(418)
+		w.WriteHeader(500)
+	})
+	logger := log.New(&logged, "", 0)
+	statusCode, _, _ := serveTestHandler(t, LogRequests(logger)(handler), HttpRequest{Method: GET, Path: "/tea", Headers: Header{}})
+	if statusCode != 418 {
+		t.Errorf("Expected StatusCode 418, but got %d", statusCode)
+	}
+	if logged.String() != "GET /tea -> 418\n" {
+		t.Errorf("Expected the request to be logged, but got %q", logged.String())
+	}
+}
+
+func TestCompress_StreamingHandler(t *testing.T) {
+	handler := Compress(nil)(HandlerFunc(func(w ResponseWriter, request HttpRequest) {
+		w.Header().Set("Content-Type", "text/plain")
+		for i := 0; i < 3; i++ {
+			io.WriteString(w, "streamed ")
+		}
+	}))
+	_, header, body := serveTestHandler(t, handler, HttpRequest{
+		Method:  GET,
+		Path:    "/",
+		Headers: Header{"Accept-Encoding": {"gzip"}},
+	})
+	if got := header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Expected Content-Encoding gzip, but got %q", got)
+	}
+	decoded, err := decodeGzipToString([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != strings.Repeat("streamed ", 3) {
+		t.Errorf("Expected the streamed body, but got %q", decoded)
+	}
+}
+
+func TestCompress_NotAcceptable(t *testing.T) {
+	handler := Compress(nil)(ResponseFunc(func(request HttpRequest) HttpResponse {
+		return textResponse(200, "hidden")
+	}))
+	statusCode, header, body := serveTestHandler(t, handler, HttpRequest{
+		Method:  GET,
+		Path:    "/",
+		Headers: Header{"Accept-Encoding": {"identity;q=0, compress"}},
+	})
+	if statusCode != 406 || body != notAcceptableBody {
+		t.Errorf("Expected 406 %s, but got %d %q", notAcceptableBody, statusCode, body)
+	}
+	if got := header.Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Expected Vary Accept-Encoding, but got %q", got)
+	}
+}
@@ -0,0 +1,154 @@
+package httpserver
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Header holds the fields of an HTTP header section. Keys are stored in
+// canonical form (see CanonicalHeaderKey) so lookups are case-insensitive,
+// and a field that appears more than once keeps all of its values in order.
+type Header map[string][]string
+
+// Add appends value to the values of key.
+func (h Header) Add(key, value string) {
+	key = CanonicalHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Set replaces any existing values of key with value.
+func (h Header) Set(key, value string) {
+	h[CanonicalHeaderKey(key)] = []string{value}
+}
+
+// Get returns the first value of key, or "" if it is not present.
+func (h Header) Get(key string) string {
+	values := h[CanonicalHeaderKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Values returns all values of key. The returned slice is not a copy.
+func (h Header) Values(key string) []string {
+	return h[CanonicalHeaderKey(key)]
+}
+
+// Has reports whether key is present, even with an empty value.
+func (h Header) Has(key string) bool {
+	_, ok := h[CanonicalHeaderKey(key)]
+	return ok
+}
+
+// Del removes all values of key.
+func (h Header) Del(key string) {
+	delete(h, CanonicalHeaderKey(key))
+}
+
+// Clone returns a deep copy of h.
+func (h Header) Clone() Header {
+	if h == nil {
+		return nil
+	}
+	clone := make(Header, len(h))
+	for key, values := range h {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+var newlineReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+// Write serializes h as header field lines, sorted by key so the output is
+// the same every time. Repeated fields are written one per line in the
+// order they were added.
+func (h Header) Write(w io.Writer) error {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range h[key] {
+			// Never let a value smuggle extra header lines into the response
+			value = newlineReplacer.Replace(value)
+			if _, err := fmt.Fprintf(w, "%s: %s\r\n", key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CanonicalHeaderKey returns the canonical form of a header name: the first
+// letter and every letter following a hyphen are upper case, the rest lower
+// case, so "user-agent" becomes "User-Agent". Names that are not valid
+// tokens are returned unchanged.
+func CanonicalHeaderKey(key string) string {
+	if !isToken(key) {
+		return key
+	}
+	canonical := []byte(key)
+	upper := true
+	for i, c := range canonical {
+		if upper && c >= 'a' && c <= 'z' {
+			canonical[i] = c - ('a' - 'A')
+		} else if !upper && c >= 'A' && c <= 'Z' {
+			canonical[i] = c + ('a' - 'A')
+		}
+		upper = c == '-'
+	}
+	return string(canonical)
+}
+
+// tokens splits the comma separated list values of key into their
+// elements, trimming whitespace and dropping empty elements.
+func (h Header) tokens(key string) []string {
+	var tokens []string
+	for _, value := range h.Values(key) {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				tokens = append(tokens, part)
+			}
+		}
+	}
+	return tokens
+}
+
+// hasToken reports whether the list header key contains token, compared
+// case-insensitively.
+func (h Header) hasToken(key, token string) bool {
+	for _, part := range h.tokens(key) {
+		if strings.EqualFold(part, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpTimeFormat is the IMF-fixdate format used for dates in headers such
+// as Last-Modified.
+const httpTimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// formatHTTPDate formats t as an IMF-fixdate.
+func formatHTTPDate(t time.Time) string {
+	return t.UTC().Format(httpTimeFormat)
+}
+
+// parseHTTPDate parses an HTTP-date in any of the three formats RFC 9110
+// section 5.6.7 requires recipients to accept.
+func parseHTTPDate(value string) (time.Time, error) {
+	var err error
+	for _, layout := range []string{httpTimeFormat, time.RFC850, time.ANSIC} {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
@@ -0,0 +1,157 @@
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalHeaderKey(t *testing.T) {
+	tests := map[string]string{
+		"user-agent":       "User-Agent",
+		"USER-AGENT":       "User-Agent",
+		"content-md5":      "Content-Md5",
+		"x-forwarded-for":  "X-Forwarded-For",
+		"Accept-Encoding":  "Accept-Encoding",
+		"etag":             "Etag",
+		"www-authenticate": "Www-Authenticate",
+		"bad key":          "bad key",
+		"":                 "",
+	}
+	for input, expected := range tests {
+		if got := CanonicalHeaderKey(input); got != expected {
+			t.Errorf("CanonicalHeaderKey(%q): expected %q, but got %q", input, expected, got)
+		}
+	}
+}
+
+func TestHeader_Methods(t *testing.T) {
+	h := make(Header)
+	h.Add("set-cookie", "a=1")
+	h.Add("Set-Cookie", "b=2")
+	h.Set("content-type", "text/plain")
+
+	if got := h.Get("SET-COOKIE"); got != "a=1" {
+		t.Errorf("Expected first Set-Cookie a=1, but got %s", got)
+	}
+	if got := h.Values("set-cookie"); !reflect.DeepEqual(got, []string{"a=1", "b=2"}) {
+		t.Errorf("Expected both Set-Cookie values, but got %v", got)
+	}
+	if !h.Has("Content-Type") || h.Get("CONTENT-TYPE") != "text/plain" {
+		t.Errorf("Expected Content-Type text/plain, but got %v", h)
+	}
+
+	h.Set("Set-Cookie", "c=3")
+	if got := h.Values("Set-Cookie"); !reflect.DeepEqual(got, []string{"c=3"}) {
+		t.Errorf("Expected Set to replace values, but got %v", got)
+	}
+
+	h.Del("set-cookie")
+	if h.Has("Set-Cookie") || h.Get("Set-Cookie") != "" {
+		t.Errorf("Expected Set-Cookie to be deleted, but got %v", h)
+	}
+
+	clone := h.Clone()
+	clone.Add("Content-Type", "text/html")
+	if len(h.Values("Content-Type")) != 1 {
+		t.Errorf("Expected Clone to be independent of the original")
+	}
+}
+
+func TestHeader_Tokens(t *testing.T) {
+	h := Header{"Accept-Encoding": {"deflate, gzip", " br ,, identity"}}
+	expected := []string{"deflate", "gzip", "br", "identity"}
+	if got := h.tokens("accept-encoding"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected tokens %v, but got %v", expected, got)
+	}
+	if !h.hasToken("Accept-Encoding", "GZIP") {
+		t.Errorf("Expected hasToken to match gzip case-insensitively")
+	}
+	if h.hasToken("Accept-Encoding", "zstd") {
+		t.Errorf("Expected hasToken not to match zstd")
+	}
+}
+
+func TestHeader_Write(t *testing.T) {
+	h := Header{
+		"Content-Type":   {"text/plain"},
+		"Set-Cookie":     {"b=2", "a=1"},
+		"Content-Length": {"3"},
+		"X-Injected":     {"a\r\nEvil: yes"},
+	}
+	expected := "Content-Length: 3\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Set-Cookie: b=2\r\n" +
+		"Set-Cookie: a=1\r\n" +
+		"X-Injected: a  Evil: yes\r\n"
+
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		if err := h.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expected {
+			t.Fatalf("Expected\n%q\nbut got\n%q", expected, buf.String())
+		}
+	}
+}
+
+func TestParseHttpRequest_HeaderMap(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\n" +
+		"host: localhost\r\n" +
+		"accept-encoding: gzip\r\n" +
+		"Accept-Encoding: br\r\n" +
+		"X-Folded: first\r\n" +
+		"  second\r\n" +
+		"\tthird\r\n" +
+		"\r\n"
+	req, err := parseHttpRequest(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Headers.Get("Host") != "localhost" {
+		t.Errorf("Expected Host localhost, but got %q", req.Headers.Get("Host"))
+	}
+	if got := req.Headers.Values("Accept-Encoding"); !reflect.DeepEqual(got, []string{"gzip", "br"}) {
+		t.Errorf("Expected both Accept-Encoding values, but got %v", got)
+	}
+	if got := req.Headers.Get("X-Folded"); got != "first second third" {
+		t.Errorf("Expected folded value %q, but got %q", "first second third", got)
+	}
+}
+
+func TestParseHttpRequest_ContentLengthValues(t *testing.T) {
+	req, err := parseHttpRequest("POST / HTTP/1.1\r\nContent-Length: 5\r\ncontent-length: 5, 5\r\n\r\nhello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readRequestBody(t, req); body != "hello" {
+		t.Errorf("Expected Body hello, but got %s", body)
+	}
+
+	for _, raw := range []string{
+		"POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!",
+		"POST / HTTP/1.1\r\nContent-Length: 5, 6\r\n\r\nhello!",
+		"POST / HTTP/1.1\r\nContent-Length:\r\n\r\n",
+		"POST / HTTP/1.1\r\ncontent-length: 3\r\ntransfer-encoding: chunked\r\n\r\n",
+		"GET / HTTP/1.1\r\n folded-first: x\r\n\r\n",
+	} {
+		_, err := parseHttpRequest(raw)
+		var requestErr *RequestError
+		if !errors.As(err, &requestErr) || requestErr.StatusCode != 400 {
+			t.Errorf("Expected 400 for %q, but got %v", raw, err)
+		}
+	}
+}
+
+func TestGenerateHttpResponse_UserAgentCaseInsensitive(t *testing.T) {
+	req, err := parseHttpRequest("GET /user-agent HTTP/1.1\r\nuser-agent: lowercase/1.0\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := generateHttpResponse(req)
+	if body := readResponseBody(t, response); body != "lowercase/1.0" {
+		t.Errorf("Expected Body lowercase/1.0, but got %s", body)
+	}
+}
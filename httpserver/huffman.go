@@ -0,0 +1,147 @@
+package httpserver
+
+import "sort"
+
+// bitWriter packs bits least significant first, the order both brotli and
+// zstd streams use.
+type bitWriter struct {
+	out   []byte
+	acc   uint64
+	nbits uint
+}
+
+// writeBits appends the low n bits of value, n at most 56.
+func (b *bitWriter) writeBits(value uint64, n uint) {
+	b.acc |= (value & (1<<n - 1)) << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.out = append(b.out, byte(b.acc))
+		b.acc >>= 8
+		b.nbits -= 8
+	}
+}
+
+// alignToByte pads with zero bits up to the next byte boundary.
+func (b *bitWriter) alignToByte() {
+	if b.nbits > 0 {
+		b.out = append(b.out, byte(b.acc))
+		b.acc, b.nbits = 0, 0
+	}
+}
+
+// bitLength returns the number of bits needed to represent v.
+func bitLength(v uint64) uint {
+	n := uint(0)
+	for v != 0 {
+		n++
+		v >>= 1
+	}
+	return n
+}
+
+// packageMergeNode is a leaf symbol or a package of two nodes.
+type packageMergeNode struct {
+	weight      uint64
+	symbol      int
+	left, right *packageMergeNode
+}
+
+// huffmanLengths returns the code length of each symbol of an optimal prefix
+// code for freq whose codes are at most limit bits, using the package-merge
+// algorithm. Unused symbols get length 0. A lone used symbol gets length 1.
+// There must be at most 1<<limit used symbols.
+func huffmanLengths(freq []uint32, limit int) []uint8 {
+	lengths := make([]uint8, len(freq))
+	var leaves []*packageMergeNode
+	for symbol, f := range freq {
+		if f > 0 {
+			leaves = append(leaves, &packageMergeNode{weight: uint64(f), symbol: symbol})
+		}
+	}
+	switch len(leaves) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[leaves[0].symbol] = 1
+		return lengths
+	}
+	sort.SliceStable(leaves, func(i, j int) bool { return leaves[i].weight < leaves[j].weight })
+
+	list := leaves
+	for level := 1; level < limit; level++ {
+		packages := make([]*packageMergeNode, 0, len(list)/2)
+		for i := 0; i+1 < len(list); i += 2 {
+			packages = append(packages, &packageMergeNode{
+				weight: list[i].weight + list[i+1].weight,
+				symbol: -1,
+				left:   list[i],
+				right:  list[i+1],
+			})
+		}
+		merged := make([]*packageMergeNode, 0, len(leaves)+len(packages))
+		i, j := 0, 0
+		for i < len(leaves) || j < len(packages) {
+			if j == len(packages) || (i < len(leaves) && leaves[i].weight <= packages[j].weight) {
+				merged = append(merged, leaves[i])
+				i++
+			} else {
+				merged = append(merged, packages[j])
+				j++
+			}
+		}
+		list = merged
+	}
+
+	// Each time a symbol appears among the first 2n-2 items its code gets
+	// one bit longer
+	var count func(node *packageMergeNode)
+	count = func(node *packageMergeNode) {
+		if node.symbol >= 0 {
+			lengths[node.symbol]++
+			return
+		}
+		count(node.left)
+		count(node.right)
+	}
+	for _, node := range list[:2*len(leaves)-2] {
+		count(node)
+	}
+	return lengths
+}
+
+// canonicalCodes assigns canonical prefix codes to the code lengths: shorter
+// codes first, and in symbol order among codes of the same length. Codes are
+// bit reversed so they can be written least significant bit first, as
+// brotli and deflate expect.
+func canonicalCodes(lengths []uint8) []uint16 {
+	var lengthCount [16]int
+	for _, l := range lengths {
+		lengthCount[l]++
+	}
+	lengthCount[0] = 0
+	var next [16]uint16
+	code := uint16(0)
+	for bits := 1; bits < 16; bits++ {
+		code = (code + uint16(lengthCount[bits-1])) << 1
+		next[bits] = code
+	}
+	codes := make([]uint16, len(lengths))
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		codes[symbol] = reverseBits(next[l], uint(l))
+		next[l]++
+	}
+	return codes
+}
+
+// reverseBits reverses the low n bits of code.
+func reverseBits(code uint16, n uint) uint16 {
+	reversed := uint16(0)
+	for i := uint(0); i < n; i++ {
+		reversed = reversed<<1 | code&1
+		code >>= 1
+	}
+	return reversed
+}
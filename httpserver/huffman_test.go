@@ -0,0 +1,121 @@
+package httpserver
+
+import (
+	"errors"
+	"testing"
+)
+
+// testBitReader reads bits least significant first, as bitWriter writes
+// them.
+type testBitReader struct {
+	data []byte
+	pos  int
+}
+
+var errTestBitsExhausted = errors.New("read past the end of the data")
+
+func (r *testBitReader) bits(n uint) (uint32, error) {
+	v, err := r.peek(n)
+	r.pos += int(n)
+	return v, err
+}
+
+func (r *testBitReader) peek(n uint) (uint32, error) {
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		byteIndex := (r.pos + int(i)) / 8
+		if byteIndex >= len(r.data) {
+			return 0, errTestBitsExhausted
+		}
+		v |= uint32(r.data[byteIndex]>>((r.pos+int(i))%8)&1) << i
+	}
+	return v, nil
+}
+
+func (r *testBitReader) alignToByte() {
+	r.pos = (r.pos + 7) &^ 7
+}
+
+// testPrefixDecoder decodes canonical prefix codes a bit at a time, the
+// first bit read being the most significant bit of the code.
+type testPrefixDecoder struct {
+	counts  [16]int
+	symbols []int
+}
+
+func newTestPrefixDecoder(lengths []uint8) *testPrefixDecoder {
+	d := &testPrefixDecoder{}
+	for length := 1; length < 16; length++ {
+		for symbol, l := range lengths {
+			if int(l) == length {
+				d.counts[length]++
+				d.symbols = append(d.symbols, symbol)
+			}
+		}
+	}
+	return d
+}
+
+func (d *testPrefixDecoder) decode(r *testBitReader) (int, error) {
+	code, first, index := 0, 0, 0
+	for length := 1; length < 16; length++ {
+		bit, err := r.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= int(bit)
+		if code-first < d.counts[length] {
+			return d.symbols[index+code-first], nil
+		}
+		index += d.counts[length]
+		first = (first + d.counts[length]) << 1
+		code <<= 1
+	}
+	return 0, errors.New("invalid prefix code")
+}
+
+func TestHuffmanLengths(t *testing.T) {
+	freq := make([]uint32, 300)
+	for i := range freq {
+		// Fibonacci-like weights force long codes without a limit
+		if i < 30 {
+			freq[i] = 1 << uint(i%20)
+		} else if i%3 == 0 {
+			freq[i] = uint32(i)
+		}
+	}
+	for _, limit := range []int{9, 11, 15} {
+		lengths := huffmanLengths(freq, limit)
+		kraft := 0
+		for symbol, l := range lengths {
+			if (l > 0) != (freq[symbol] > 0) {
+				t.Fatalf("Expected a code for exactly the used symbols, but symbol %d has length %d", symbol, l)
+			}
+			if int(l) > limit {
+				t.Errorf("Expected lengths of at most %d, but got %d", limit, l)
+			}
+			if l > 0 {
+				kraft += 1 << uint(limit-int(l))
+			}
+		}
+		if kraft != 1<<uint(limit) {
+			t.Errorf("Expected a complete code for limit %d, but the Kraft sum is %d/%d", limit, kraft, 1<<uint(limit))
+		}
+	}
+
+	if lengths := huffmanLengths([]uint32{0, 5, 0}, 15); lengths[1] != 1 {
+		t.Errorf("Expected a lone symbol to get length 1, but got %v", lengths)
+	}
+}
+
+func TestCanonicalCodes(t *testing.T) {
+	// The example of RFC 1951 section 3.2.2
+	lengths := []uint8{3, 3, 3, 3, 3, 2, 4, 4}
+	expected := []uint16{0b010, 0b011, 0b100, 0b101, 0b110, 0b00, 0b1110, 0b1111}
+	codes := canonicalCodes(lengths)
+	for symbol := range lengths {
+		if got := reverseBits(codes[symbol], uint(lengths[symbol])); got != expected[symbol] {
+			t.Errorf("Expected code %b for symbol %d, but got %b", expected[symbol], symbol, got)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ListenOptions configures the Unix domain sockets Listen creates.
+type ListenOptions struct {
+	// SocketMode is the permission bits of Unix sockets. Zero leaves them as
+	// the umask makes them.
+	SocketMode os.FileMode
+	// SocketGroup names the group Unix sockets are given, so a reverse
+	// proxy running as another user can connect. Empty keeps the default.
+	SocketGroup string
+}
+
+// Listen opens a listener for a --listen address: "unix:/path" for a Unix
+// domain socket, ":port" or a bare port for every interface, or host:port
+// with IPv6 hosts in brackets, such as "[::1]:4221".
+func (o ListenOptions) Listen(address string) (net.Listener, error) {
+	network, addr, err := ParseListenAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	if network != "unix" {
+		return net.Listen(network, addr)
+	}
+
+	if err := removeStaleSocket(addr); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.setSocketPermissions(addr); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// ParseListenAddr returns the network and address to listen on for a
+// --listen address, as described by ListenOptions.Listen.
+func ParseListenAddr(address string) (network, addr string, err error) {
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(address, "unix:")
+		if path == "" {
+			return "", "", fmt.Errorf("listen address %q has no socket path", address)
+		}
+		return "unix", path, nil
+	}
+	if _, err := strconv.ParseUint(address, 10, 16); err == nil {
+		address = ":" + address
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", "", fmt.Errorf("listen address %q isn't host:port, [ipv6]:port, :port or unix:/path", address)
+	}
+	if _, err := net.LookupPort("tcp", port); port == "" || err != nil {
+		return "", "", fmt.Errorf("listen address %q has an invalid port", address)
+	}
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return "", "", fmt.Errorf("listen address %q has an invalid IPv6 host", address)
+	}
+	return "tcp", address, nil
+}
+
+// removeStaleSocket removes a Unix socket left behind by a process that
+// didn't shut down cleanly. A socket something still listens on is an
+// error, as is a path that isn't a socket.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and isn't a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+func (o ListenOptions) setSocketPermissions(path string) error {
+	if o.SocketGroup != "" {
+		group, err := user.LookupGroup(o.SocketGroup)
+		if err != nil {
+			return err
+		}
+		gid, err := strconv.Atoi(group.Gid)
+		if err != nil {
+			return fmt.Errorf("group %s has a non-numeric id %q", o.SocketGroup, group.Gid)
+		}
+		if err := os.Chown(path, -1, gid); err != nil {
+			return err
+		}
+	}
+	if o.SocketMode != 0 {
+		return os.Chmod(path, o.SocketMode)
+	}
+	return nil
+}
+
+// FileMode is an os.FileMode written in octal.
+type FileMode os.FileMode
+
+// String and Set let FileMode be used as a command line flag.
+func (m *FileMode) String() string {
+	if m == nil {
+		return "0"
+	}
+	return fmt.Sprintf("%#o", os.FileMode(*m).Perm())
+}
+
+func (m *FileMode) Set(value string) error {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("file mode %q isn't octal permission bits such as 0660", value)
+	}
+	*m = FileMode(mode)
+	return nil
+}
+
+// systemdFirstFD is the first file descriptor systemd passes sockets in,
+// SD_LISTEN_FDS_START.
+const systemdFirstFD = 3
+
+// SystemdListeners returns the listening sockets systemd passed to this
+// process with socket activation, in the order of the socket unit, or none
+// if it passed none. The LISTEN_* environment variables are unset so child
+// processes don't mistake the sockets for theirs.
+func SystemdListeners() ([]net.Listener, error) {
+	return systemdListeners(systemdFirstFD)
+}
+
+func systemdListeners(firstFD int) ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, errors.New("invalid LISTEN_FDS")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	files := make([]*os.File, count)
+	for i := range files {
+		name := "LISTEN_FD_" + strconv.Itoa(firstFD+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files[i] = os.NewFile(uintptr(firstFD+i), name)
+	}
+	listeners, err := FileListeners(files)
+	if err != nil {
+		return nil, fmt.Errorf("socket from systemd: %w", err)
+	}
+	return listeners, nil
+}
+
+// FileListeners turns inherited listening sockets into listeners. The files
+// are closed either way; the listeners work on duplicates, which unlike
+// inherited descriptors are closed on exec.
+func FileListeners(files []*os.File) ([]net.Listener, error) {
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	listeners := make([]net.Listener, 0, len(files))
+	for _, file := range files {
+		ln, err := net.FileListener(file)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("%s: %w", file.Name(), err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
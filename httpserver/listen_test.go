@@ -0,0 +1,153 @@
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseListenAddr(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		addr    string
+	}{
+		{":4221", "tcp", ":4221"},
+		{"4221", "tcp", ":4221"},
+		{"127.0.0.1:8080", "tcp", "127.0.0.1:8080"},
+		{"[::1]:8080", "tcp", "[::1]:8080"},
+		{"localhost:http", "tcp", "localhost:http"},
+		{"unix:/run/server.sock", "unix", "/run/server.sock"},
+	}
+	for _, test := range tests {
+		network, addr, err := ParseListenAddr(test.address)
+		if err != nil {
+			t.Errorf("Expected %q to parse, but got %v", test.address, err)
+			continue
+		}
+		if network != test.network || addr != test.addr {
+			t.Errorf("Expected %q to be %s %s, but got %s %s", test.address, test.network, test.addr, network, addr)
+		}
+	}
+
+	for _, address := range []string{"", "unix:", "::1:8080", "127.0.0.1", "127.0.0.1:", "127.0.0.1:70000", "[::1]"} {
+		if _, _, err := ParseListenAddr(address); err == nil {
+			t.Errorf("Expected %q to be rejected", address)
+		}
+	}
+}
+
+func TestListen_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.sock")
+	options := ListenOptions{SocketMode: 0660}
+
+	ln, err := options.Listen("unix:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0660 {
+		t.Errorf("Expected socket mode 0660, but got %#o", mode)
+	}
+	if _, err := options.Listen("unix:" + path); err == nil {
+		t.Errorf("Expected a socket in use to be an error")
+	}
+
+	s := &Server{Handler: testHandler}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	go s.Serve(ln)
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	resp := roundTrip(t, conn, bufio.NewReader(conn), "GET /echo/unix HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "unix" {
+		t.Errorf("Expected body unix, but got %q", body)
+	}
+}
+
+func TestListen_StaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave the socket file behind as a crashed process would
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	ln, err = ListenOptions{}.Listen("unix:" + path)
+	if err != nil {
+		t.Fatalf("Expected a stale socket to be replaced, but got %v", err)
+	}
+	ln.Close()
+
+	notSocket := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notSocket, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (ListenOptions{}).Listen("unix:" + notSocket); err == nil {
+		t.Errorf("Expected a regular file in the way to be an error")
+	}
+}
+
+func TestSystemdListeners(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	file, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// systemdListeners closes the descriptors it takes over, so hand it a
+	// duplicate nothing else owns
+	fd, err := syscall.Dup(int(file.Fd()))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "http")
+	listeners, err := systemdListeners(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 {
+		t.Fatalf("Expected 1 listener, but got %d", len(listeners))
+	}
+	defer listeners[0].Close()
+	if listeners[0].Addr().String() != ln.Addr().String() {
+		t.Errorf("Expected the listener at %s, but got %s", ln.Addr(), listeners[0].Addr())
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Errorf("Expected LISTEN_FDS to be unset")
+	}
+
+	// Sockets meant for another process are left alone
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if listeners, err := systemdListeners(-1); err != nil || len(listeners) != 0 {
+		t.Errorf("Expected no listeners for another process, but got %d and %v", len(listeners), err)
+	}
+}
@@ -0,0 +1,249 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indexFile is served in place of a listing when a directory contains it.
+const indexFile = "index.html"
+
+// dirEntry describes one entry of a directory listing.
+type dirEntry struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	IsDir    bool      `json:"dir"`
+}
+
+// getDirectory answers a GET for a directory: it redirects to the path with
+// a trailing slash, then serves the directory's index.html if there is one,
+// and otherwise an HTML or JSON listing of its entries.
+func (c *Config) getDirectory(root fileRoot, fileName string, dirPath string, request HttpRequest) HttpResponse {
+	urlPath, rawQuery, _ := strings.Cut(request.Path, "?")
+	if !strings.HasSuffix(urlPath, "/") {
+		location := urlPath + "/"
+		if rawQuery != "" {
+			location += "?" + rawQuery
+		}
+		return HttpResponse{
+			StatusCode: 301,
+			Status:     "Moved Permanently",
+			Headers:    Header{"Location": {location}, "Content-Type": {"text/plain"}},
+			Body:       []byte("Moved Permanently"),
+		}
+	}
+
+	fileName, _, _ = strings.Cut(fileName, "?")
+	if indexPath, err := root.resolve(fileName + indexFile); err == nil {
+		if info, err := os.Stat(indexPath); err == nil && info.Mode().IsRegular() {
+			return c.getFile(indexPath, request)
+		}
+	}
+
+	entries, err := readDirEntries(dirPath)
+	if err != nil {
+		return HttpResponse{
+			StatusCode: 500,
+			Status:     "Internal Server Error",
+			Headers:    Header{"Content-Type": {"text/plain"}},
+			Body:       []byte("Error reading directory"),
+		}
+	}
+	query, _ := url.ParseQuery(rawQuery)
+	sortDirEntries(entries, query.Get("sort"), query.Get("order"))
+
+	// Listings change whenever the directory does, so don't let them be
+	// cached, and tell caches the representation depends on Accept
+	headers := Header{"Cache-Control": {"no-cache"}, "Vary": {"Accept"}}
+	var body []byte
+	if preferredMediaType(request.Headers.Values("Accept"), "text/html", "application/json") == "application/json" {
+		body, err = json.Marshal(struct {
+			Path    string     `json:"path"`
+			Entries []dirEntry `json:"entries"`
+		}{Path: urlPath, Entries: entries})
+		if err != nil {
+			return HttpResponse{
+				StatusCode: 500,
+				Status:     "Internal Server Error",
+				Headers:    Header{"Content-Type": {"text/plain"}},
+				Body:       []byte("Error encoding listing"),
+			}
+		}
+		headers.Set("Content-Type", "application/json; charset=utf-8")
+	} else {
+		body = []byte(renderListing(urlPath, entries, query.Get("sort"), query.Get("order")))
+		headers.Set("Content-Type", "text/html; charset=utf-8")
+	}
+
+	return HttpResponse{
+		StatusCode: 200,
+		Status:     "OK",
+		Headers:    headers,
+		Body:       body,
+	}
+}
+
+// readDirEntries lists dirPath. Entries that vanish or can't be inspected
+// while listing are skipped.
+func readDirEntries(dirPath string) ([]dirEntry, error) {
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]dirEntry, 0, len(dirEntries))
+	for _, entry := range dirEntries {
+		if isUploadTemp(entry.Name()) || entry.Name() == uploadStateDir {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, dirEntry{
+			Name:     entry.Name(),
+			Size:     info.Size(),
+			Modified: info.ModTime().UTC(),
+			IsDir:    entry.IsDir(),
+		})
+	}
+	return entries, nil
+}
+
+// sortDirEntries orders entries by name, size or mtime, ascending unless
+// order is "desc". Unknown keys sort by name.
+func sortDirEntries(entries []dirEntry, key, order string) {
+	less := func(a, b dirEntry) bool { return a.Name < b.Name }
+	switch key {
+	case "size":
+		less = func(a, b dirEntry) bool {
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+			return a.Name < b.Name
+		}
+	case "mtime":
+		less = func(a, b dirEntry) bool {
+			if !a.Modified.Equal(b.Modified) {
+				return a.Modified.Before(b.Modified)
+			}
+			return a.Name < b.Name
+		}
+	}
+	desc := order == "desc"
+	sort.SliceStable(entries, func(i, j int) bool {
+		if desc {
+			return less(entries[j], entries[i])
+		}
+		return less(entries[i], entries[j])
+	})
+}
+
+// renderListing renders entries as an HTML table whose column headers link
+// to the listing sorted by that column.
+func renderListing(urlPath string, entries []dirEntry, sortKey, order string) string {
+	title := html.EscapeString("Index of " + urlPath)
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n", title)
+	fmt.Fprintf(&b, "<h1>%s</h1>\n<table>\n<tr>", title)
+	for _, column := range []struct{ key, label string }{{"name", "Name"}, {"size", "Size"}, {"mtime", "Modified"}} {
+		columnOrder := "asc"
+		if column.key == sortKey && order != "desc" {
+			columnOrder = "desc"
+		}
+		fmt.Fprintf(&b, "<th><a href=\"?sort=%s&amp;order=%s\">%s</a></th>", column.key, columnOrder, column.label)
+	}
+	b.WriteString("</tr>\n")
+	if urlPath != "/files/" {
+		b.WriteString("<tr><td><a href=\"../\">../</a></td><td></td><td></td></tr>\n")
+	}
+	for _, entry := range entries {
+		name := entry.Name
+		size := strconv.FormatInt(entry.Size, 10)
+		if entry.IsDir {
+			name += "/"
+			size = "-"
+		}
+		href := (&url.URL{Path: name}).EscapedPath()
+		if strings.Contains(strings.SplitN(href, "/", 2)[0], ":") {
+			// Keep names like "a:b" from being read as a URL scheme
+			href = "./" + href
+		}
+		fmt.Fprintf(&b, "<tr><td><a href=\"%s\">%s</a></td><td>%s</td><td>%s</td></tr>\n",
+			html.EscapeString(href), html.EscapeString(name), size, entry.Modified.Format(time.RFC3339))
+	}
+	b.WriteString("</table>\n</body>\n</html>\n")
+	return b.String()
+}
+
+// preferredMediaType returns the offer the Accept header values rank
+// highest, or the first offer if the client expressed no preference. Ties
+// go to the earlier offer.
+func preferredMediaType(accept []string, offers ...string) string {
+	best, bestQ := offers[0], -1.0
+	for _, offer := range offers {
+		q := mediaTypeQuality(accept, offer)
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// mediaTypeQuality returns the q-value the Accept header values give offer,
+// using the most specific matching range. Without an Accept header every
+// type is acceptable.
+func mediaTypeQuality(accept []string, offer string) float64 {
+	if len(accept) == 0 {
+		return 1
+	}
+	offerType, _, _ := strings.Cut(offer, "/")
+	q, specificity := 0.0, -1
+	for _, value := range accept {
+		for _, element := range strings.Split(value, ",") {
+			mediaRange, params, _ := strings.Cut(element, ";")
+			mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+			rangeQ := parseQValue(params)
+
+			var s int
+			switch {
+			case mediaRange == offer:
+				s = 2
+			case mediaRange == offerType+"/*":
+				s = 1
+			case mediaRange == "*/*":
+				s = 0
+			default:
+				continue
+			}
+			if s > specificity {
+				q, specificity = rangeQ, s
+			}
+		}
+	}
+	return q
+}
+
+// parseQValue extracts the q parameter from a ";"-separated parameter list,
+// defaulting to 1.
+func parseQValue(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
@@ -0,0 +1,192 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeListingTestDir fills a fresh served directory with a few entries of
+// different sizes and ages.
+func writeListingTestDir(t *testing.T) {
+	t.Helper()
+	testConfig.Dir = t.TempDir()
+	files := []struct {
+		name    string
+		content string
+		age     time.Duration
+	}{
+		{name: "b.txt", content: "bbbbbbbbbb", age: 3 * time.Hour},
+		{name: "a.txt", content: "a", age: time.Hour},
+		{name: "<script>.txt", content: "xyz", age: 2 * time.Hour},
+	}
+	for _, f := range files {
+		path := filepath.Join(testConfig.Dir, f.name)
+		if err := os.WriteFile(path, []byte(f.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-f.age)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(testConfig.Dir, "sub dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getListing(t *testing.T, path string, accept string) []dirEntry {
+	t.Helper()
+	response := generateHttpResponse(HttpRequest{Method: GET, Path: path, Headers: Header{"Accept": {accept}}})
+	if response.StatusCode != 200 {
+		t.Fatalf("Expected StatusCode 200, but got %d", response.StatusCode)
+	}
+	if got := response.Headers.Get("Content-Type"); got != "application/json; charset=utf-8" {
+		t.Fatalf("Expected a JSON listing, but got %s", got)
+	}
+	var listing struct {
+		Path    string     `json:"path"`
+		Entries []dirEntry `json:"entries"`
+	}
+	if err := json.Unmarshal([]byte(readResponseBody(t, response)), &listing); err != nil {
+		t.Fatal(err)
+	}
+	return listing.Entries
+}
+
+func entryNames(entries []dirEntry) string {
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestGetDirectory_JSONListing(t *testing.T) {
+	writeListingTestDir(t)
+
+	entries := getListing(t, "/files/", "application/json")
+	if got := entryNames(entries); got != "<script>.txt,a.txt,b.txt,sub dir" {
+		t.Errorf("Unexpected entries %s", got)
+	}
+	for _, entry := range entries {
+		if entry.Name == "b.txt" && (entry.Size != 10 || entry.IsDir) {
+			t.Errorf("Unexpected entry %+v", entry)
+		}
+		if entry.Name == "sub dir" && !entry.IsDir {
+			t.Errorf("Expected sub dir to be a directory")
+		}
+	}
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{query: "?sort=name&order=desc", expected: "b.txt,a.txt,<script>.txt"},
+		{query: "?sort=size", expected: "a.txt,<script>.txt,b.txt"},
+		{query: "?sort=mtime&order=desc", expected: "a.txt,<script>.txt,b.txt"},
+		{query: "?sort=bogus", expected: "<script>.txt,a.txt,b.txt"},
+	}
+	for _, tt := range tests {
+		// Only the files have controlled sizes and ages
+		var files []dirEntry
+		for _, entry := range getListing(t, "/files/"+tt.query, "text/html;q=0.5, application/json") {
+			if !entry.IsDir {
+				files = append(files, entry)
+			}
+		}
+		if got := entryNames(files); got != tt.expected {
+			t.Errorf("%s: expected %s, but got %s", tt.query, tt.expected, got)
+		}
+	}
+}
+
+func TestGetDirectory_HTMLListing(t *testing.T) {
+	writeListingTestDir(t)
+
+	for _, accept := range []string{"", "text/html", "*/*", "application/json;q=0.1, text/*"} {
+		response := generateHttpResponse(HttpRequest{Method: GET, Path: "/files/", Headers: Header{"Accept": {accept}}})
+		if response.StatusCode != 200 {
+			t.Fatalf("Accept %q: expected StatusCode 200, but got %d", accept, response.StatusCode)
+		}
+		if got := response.Headers.Get("Content-Type"); got != "text/html; charset=utf-8" {
+			t.Errorf("Accept %q: expected an HTML listing, but got %s", accept, got)
+		}
+		body := readResponseBody(t, response)
+		for _, want := range []string{
+			`<a href="a.txt">a.txt</a>`,
+			`<a href="sub%20dir/">sub dir/</a>`,
+			`&lt;script&gt;.txt`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("Accept %q: expected listing to contain %s", accept, want)
+			}
+		}
+		if strings.Contains(body, "<script>") {
+			t.Errorf("Expected file names to be escaped")
+		}
+	}
+}
+
+func TestGetDirectory_Redirect(t *testing.T) {
+	writeListingTestDir(t)
+
+	response := generateHttpResponse(HttpRequest{Method: GET, Path: "/files/sub%20dir?sort=size", Headers: Header{}})
+	if response.StatusCode != 301 {
+		t.Fatalf("Expected StatusCode 301, but got %d", response.StatusCode)
+	}
+	if got := response.Headers.Get("Location"); got != "/files/sub%20dir/?sort=size" {
+		t.Errorf("Expected Location /files/sub%%20dir/?sort=size, but got %s", got)
+	}
+
+	response = generateHttpResponse(HttpRequest{Method: GET, Path: "/files/sub%20dir/", Headers: Header{}})
+	if response.StatusCode != 200 {
+		t.Errorf("Expected StatusCode 200, but got %d", response.StatusCode)
+	}
+	if body := readResponseBody(t, response); !strings.Contains(body, `<a href="../">`) {
+		t.Errorf("Expected a link to the parent directory")
+	}
+}
+
+func TestGetDirectory_Index(t *testing.T) {
+	writeListingTestDir(t)
+	index := "<h1>Welcome</h1>"
+	if err := os.WriteFile(filepath.Join(testConfig.Dir, "sub dir", indexFile), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	response := generateHttpResponse(HttpRequest{Method: GET, Path: "/files/sub%20dir/", Headers: Header{}})
+	if response.StatusCode != 200 {
+		t.Fatalf("Expected StatusCode 200, but got %d", response.StatusCode)
+	}
+	if got := response.Headers.Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("Expected Content-Type text/html; charset=utf-8, but got %s", got)
+	}
+	if body := readResponseBody(t, response); body != index {
+		t.Errorf("Expected Body %s, but got %s", index, body)
+	}
+}
+
+func TestPreferredMediaType(t *testing.T) {
+	tests := []struct {
+		accept   []string
+		expected string
+	}{
+		{accept: nil, expected: "text/html"},
+		{accept: []string{"application/json"}, expected: "application/json"},
+		{accept: []string{"text/html, application/json"}, expected: "text/html"},
+		{accept: []string{"text/html;q=0.9", "application/json"}, expected: "application/json"},
+		{accept: []string{"application/*"}, expected: "application/json"},
+		{accept: []string{"*/*;q=0.1, application/json;q=0.5"}, expected: "application/json"},
+		{accept: []string{"*/*, text/html;q=0"}, expected: "application/json"},
+		{accept: []string{"image/png"}, expected: "text/html"},
+	}
+	for _, tt := range tests {
+		if got := preferredMediaType(tt.accept, "text/html", "application/json"); got != tt.expected {
+			t.Errorf("Accept %q: expected %s, but got %s", tt.accept, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package httpserver
+
+// lzParams tunes the match finder shared by the brotli and zstd encoders.
+type lzParams struct {
+	// window is how far back matches may reach, a power of two
+	window int
+	// maxChain is how many earlier positions are tried per position. Zero
+	// disables matching, leaving only entropy coding.
+	maxChain int
+	// niceLength stops the search once a match this long is found
+	niceLength int
+	// lazy looks one byte ahead for a longer match before taking one
+	lazy bool
+}
+
+// lzEffort maps an effort from 0 (fastest) to 11 (smallest) to match finder
+// parameters.
+func lzEffort(effort int) lzParams {
+	if effort <= 0 {
+		return lzParams{window: 1 << 16}
+	}
+	if effort > 11 {
+		effort = 11
+	}
+	params := lzParams{
+		window:     1 << 16,
+		maxChain:   1 << uint(effort-1),
+		niceLength: 16 << uint(effort/2),
+		lazy:       effort >= 5,
+	}
+	if effort >= 6 {
+		params.window = 1 << 18
+	}
+	return params
+}
+
+const (
+	lzMinMatch = 4
+	lzMaxMatch = 1 << 16
+	lzHashBits = 15
+)
+
+// lzSequence is a run of literals followed by a copy of matchLength bytes
+// from offset bytes back. The last sequence of a block has no copy.
+type lzSequence struct {
+	literalLength int
+	matchLength   int
+	offset        int
+}
+
+// lzMatcher finds repeated strings with hash chains. It keeps up to a window
+// of history so blocks can refer back to the ones before them.
+type lzMatcher struct {
+	params lzParams
+	hist   []byte
+	// head holds the latest position of each hash and prev the position
+	// before it with the same hash, indexed modulo the window. -1 is none.
+	head []int32
+	prev []int32
+}
+
+func newLZMatcher(params lzParams) *lzMatcher {
+	m := &lzMatcher{params: params}
+	if params.maxChain > 0 {
+		m.head = make([]int32, 1<<lzHashBits)
+		m.prev = make([]int32, params.window)
+		for i := range m.head {
+			m.head[i] = -1
+		}
+	}
+	return m
+}
+
+func lzHash(b []byte) uint32 {
+	v := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+	return (v * 2654435761) >> (32 - lzHashBits)
+}
+
+// encodeBlock splits block into sequences. Matches may refer back into
+// earlier blocks, but never reach past the end of this one.
+func (m *lzMatcher) encodeBlock(block []byte) []lzSequence {
+	m.slide()
+	start := len(m.hist)
+	m.hist = append(m.hist, block...)
+	end := len(m.hist)
+	if m.params.maxChain == 0 {
+		return []lzSequence{{literalLength: len(block)}}
+	}
+
+	var sequences []lzSequence
+	literalStart := start
+	for i := start; i+lzMinMatch <= end; {
+		length, offset := m.findMatch(i, end)
+		if length < lzMinMatch {
+			m.insert(i)
+			i++
+			continue
+		}
+		if m.params.lazy && length < m.params.niceLength && i+1+lzMinMatch <= end {
+			m.insert(i)
+			if next, _ := m.findMatch(i+1, end); next > length {
+				i++
+				continue
+			}
+		} else {
+			m.insert(i)
+		}
+		sequences = append(sequences, lzSequence{
+			literalLength: i - literalStart,
+			matchLength:   length,
+			offset:        offset,
+		})
+		for j := i + 1; j < i+length && j+lzMinMatch <= end; j++ {
+			m.insert(j)
+		}
+		i += length
+		literalStart = i
+	}
+	return append(sequences, lzSequence{literalLength: end - literalStart})
+}
+
+// findMatch returns the longest earlier match for the bytes at i.
+func (m *lzMatcher) findMatch(i, end int) (length, offset int) {
+	limit := end - i
+	if limit > lzMaxMatch {
+		limit = lzMaxMatch
+	}
+	candidate := int(m.head[lzHash(m.hist[i:])])
+	for chain := 0; chain < m.params.maxChain && candidate >= 0; chain++ {
+		if i-candidate >= m.params.window {
+			break
+		}
+		if m.hist[candidate+length] == m.hist[i+length] {
+			n := 0
+			for n < limit && m.hist[candidate+n] == m.hist[i+n] {
+				n++
+			}
+			if n > length {
+				length, offset = n, i-candidate
+				if n >= m.params.niceLength || n == limit {
+					break
+				}
+			}
+		}
+		next := int(m.prev[candidate&(m.params.window-1)])
+		// Slots are reused after a window, so an older link may point
+		// forward into another chain
+		if next >= candidate {
+			break
+		}
+		candidate = next
+	}
+	if length < lzMinMatch {
+		return 0, 0
+	}
+	return length, offset
+}
+
+func (m *lzMatcher) insert(i int) {
+	h := lzHash(m.hist[i:])
+	m.prev[i&(m.params.window-1)] = m.head[h]
+	m.head[h] = int32(i)
+}
+
+// slide drops history that is more than a window old. It moves by whole
+// windows so positions keep their slot in prev.
+func (m *lzMatcher) slide() {
+	window := m.params.window
+	if len(m.hist) < 2*window {
+		return
+	}
+	shift := (len(m.hist) - window) &^ (window - 1)
+	m.hist = append(m.hist[:0], m.hist[shift:]...)
+	rebase := func(positions []int32) {
+		for i, p := range positions {
+			if int(p) >= shift {
+				positions[i] = p - int32(shift)
+			} else {
+				positions[i] = -1
+			}
+		}
+	}
+	if m.head != nil {
+		rebase(m.head)
+		rebase(m.prev)
+	}
+}
@@ -0,0 +1,47 @@
+package httpserver
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLZMatcher_EncodeBlock(t *testing.T) {
+	blocks := [][]byte{
+		[]byte(strings.Repeat("abcabcabd", 500)),
+		[]byte("no repeats here"),
+		[]byte(strings.Repeat("abcabcabd", 20) + "tail"),
+		{},
+	}
+	for _, effort := range []int{0, 1, 5, 11} {
+		matcher := newLZMatcher(lzEffort(effort))
+		var history []byte
+		for _, block := range blocks {
+			sequences := matcher.encodeBlock(block)
+			position := 0
+			for i, seq := range sequences {
+				history = append(history, block[position:position+seq.literalLength]...)
+				position += seq.literalLength
+				if seq.matchLength == 0 {
+					if i != len(sequences)-1 {
+						t.Fatalf("Expected only the last sequence to have no match")
+					}
+					continue
+				}
+				if seq.matchLength < lzMinMatch || seq.offset <= 0 || seq.offset > len(history) {
+					t.Fatalf("Expected a valid match, but got %+v", seq)
+				}
+				for j := 0; j < seq.matchLength; j++ {
+					history = append(history, history[len(history)-seq.offset])
+				}
+				position += seq.matchLength
+			}
+			if position != len(block) {
+				t.Fatalf("Expected the sequences to cover %d bytes, but they cover %d", len(block), position)
+			}
+		}
+		if !bytes.Equal(history, bytes.Join(blocks, nil)) {
+			t.Errorf("Expected effort %d to reproduce the input", effort)
+		}
+	}
+}
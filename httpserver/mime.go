@@ -0,0 +1,206 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLength is how much of a file is inspected to guess its type when the
+// extension doesn't tell.
+const sniffLength = 512
+
+// builtinMimeTypes maps lower case file extensions to media types.
+var builtinMimeTypes = map[string]string{
+	".avif":  "image/avif",
+	".bmp":   "image/bmp",
+	".css":   "text/css",
+	".csv":   "text/csv",
+	".gif":   "image/gif",
+	".gz":    "application/gzip",
+	".htm":   "text/html",
+	".html":  "text/html",
+	".ico":   "image/x-icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "text/javascript",
+	".json":  "application/json",
+	".log":   "text/plain",
+	".md":    "text/markdown",
+	".mjs":   "text/javascript",
+	".mp3":   "audio/mpeg",
+	".mp4":   "video/mp4",
+	".ogg":   "audio/ogg",
+	".otf":   "font/otf",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".tar":   "application/x-tar",
+	".ttf":   "font/ttf",
+	".txt":   "text/plain",
+	".wasm":  "application/wasm",
+	".wav":   "audio/wav",
+	".webm":  "video/webm",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "application/xml",
+	".yaml":  "application/yaml",
+	".yml":   "application/yaml",
+	".zip":   "application/zip",
+}
+
+// textMediaTypes are non text/* media types whose content is text and gets a
+// charset parameter.
+var textMediaTypes = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/xml":        true,
+	"application/yaml":       true,
+	"image/svg+xml":          true,
+}
+
+// LoadMimeTypes reads a mime.types style file, where each line holds a media
+// type followed by the extensions that map to it, and returns the built-in
+// table extended and overridden by its entries.
+func LoadMimeTypes(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	types := make(map[string]string, len(builtinMimeTypes))
+	for ext, mediaType := range builtinMimeTypes {
+		types[ext] = mediaType
+	}
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if !strings.Contains(fields[0], "/") {
+			return nil, fmt.Errorf("%s:%d: invalid media type %q", path, lineNumber, fields[0])
+		}
+		for _, ext := range fields[1:] {
+			types["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = fields[0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
+// detectContentType picks the Content-Type for a file: by extension if
+// mimeTypes, or the built-in table if it is nil, knows it, otherwise by
+// sniffing the start of content. content is left positioned at its start.
+func detectContentType(name string, content io.ReadSeeker, mimeTypes map[string]string) (string, error) {
+	if mimeTypes == nil {
+		mimeTypes = builtinMimeTypes
+	}
+	if mediaType, ok := mimeTypes[strings.ToLower(filepath.Ext(name))]; ok {
+		return withCharset(mediaType), nil
+	}
+
+	buf := make([]byte, sniffLength)
+	n, err := io.ReadFull(content, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return withCharset(sniffContentType(buf[:n])), nil
+}
+
+// withCharset adds "charset=utf-8" to text media types that don't already
+// carry a charset.
+func withCharset(mediaType string) string {
+	base, params, _ := strings.Cut(mediaType, ";")
+	base = strings.ToLower(strings.TrimSpace(base))
+	if strings.Contains(strings.ToLower(params), "charset=") {
+		return mediaType
+	}
+	if strings.HasPrefix(base, "text/") || textMediaTypes[base] {
+		return mediaType + "; charset=utf-8"
+	}
+	return mediaType
+}
+
+// magicSignatures identify binary formats by their leading bytes.
+var magicSignatures = []struct {
+	prefix    string
+	mediaType string
+}{
+	{"\x89PNG\r\n\x1a\n", "image/png"},
+	{"\xff\xd8\xff", "image/jpeg"},
+	{"GIF87a", "image/gif"},
+	{"GIF89a", "image/gif"},
+	{"BM", "image/bmp"},
+	{"%PDF-", "application/pdf"},
+	{"PK\x03\x04", "application/zip"},
+	{"\x1f\x8b\x08", "application/gzip"},
+	{"\x00asm", "application/wasm"},
+	{"OggS", "audio/ogg"},
+	{"ID3", "audio/mpeg"},
+	{"wOFF", "font/woff"},
+	{"wOF2", "font/woff2"},
+}
+
+// htmlSignatures are the tags that mark a document as HTML, compared case
+// insensitively after leading whitespace.
+var htmlSignatures = []string{
+	"<!doctype html", "<html", "<head", "<body", "<script", "<iframe", "<h1",
+	"<div", "<font", "<table", "<a", "<style", "<title", "<b", "<br", "<p", "<!--",
+}
+
+// sniffContentType guesses the media type of data, loosely following the
+// WHATWG MIME Sniffing algorithm.
+func sniffContentType(data []byte) string {
+	for _, sig := range magicSignatures {
+		if bytes.HasPrefix(data, []byte(sig.prefix)) {
+			return sig.mediaType
+		}
+	}
+	if len(data) >= 12 && string(data[:4]) == "RIFF" {
+		switch string(data[8:12]) {
+		case "WEBP":
+			return "image/webp"
+		case "WAVE":
+			return "audio/wav"
+		}
+	}
+
+	text := bytes.TrimLeft(data, "\t\n\x0c\r ")
+	lower := bytes.ToLower(text)
+	for _, sig := range htmlSignatures {
+		// The tag must be followed by a space or the end of the tag
+		if bytes.HasPrefix(lower, []byte(sig)) && len(lower) > len(sig) &&
+			(lower[len(sig)] == ' ' || lower[len(sig)] == '>' || sig == "<!--") {
+			return "text/html"
+		}
+	}
+	if bytes.HasPrefix(text, []byte("<?xml")) {
+		return "text/xml"
+	}
+
+	for _, c := range data {
+		if isBinaryByte(c) {
+			return "application/octet-stream"
+		}
+	}
+	return "text/plain"
+}
+
+// isBinaryByte reports whether c never appears in text files.
+func isBinaryByte(c byte) bool {
+	return c <= 0x08 || c == 0x0b || (c >= 0x0e && c <= 0x1a) || (c >= 0x1c && c <= 0x1f)
+}
@@ -0,0 +1,157 @@
+package httpserver
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSniffContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{name: "PNG", data: "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", expected: "image/png"},
+		{name: "JPEG", data: "\xff\xd8\xff\xe0\x00\x10JFIF", expected: "image/jpeg"},
+		{name: "GIF", data: "GIF89a\x01\x00", expected: "image/gif"},
+		{name: "WebP", data: "RIFF\x24\x00\x00\x00WEBPVP8 ", expected: "image/webp"},
+		{name: "PDF", data: "%PDF-1.7\n", expected: "application/pdf"},
+		{name: "Gzip", data: "\x1f\x8b\x08\x00\x00\x00", expected: "application/gzip"},
+		{name: "Zip", data: "PK\x03\x04\x14\x00", expected: "application/zip"},
+		{name: "HTML", data: "\n  <!DOCTYPE html>\n<html>", expected: "text/html"},
+		{name: "HTMLTag", data: "<HTML><body>hi</body></HTML>", expected: "text/html"},
+		{name: "NotATag", data: "<abbr>", expected: "text/plain"},
+		{name: "XML", data: "<?xml version=\"1.0\"?><root/>", expected: "text/xml"},
+		{name: "JSON", data: "{\"a\": 1}\n", expected: "text/plain"},
+		{name: "UTF8", data: "héllo wörld\n", expected: "text/plain"},
+		{name: "Empty", data: "", expected: "text/plain"},
+		{name: "Binary", data: "\x00\x01\x02\x03", expected: "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffContentType([]byte(tt.data)); got != tt.expected {
+				t.Errorf("Expected %s, but got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWithCharset(t *testing.T) {
+	tests := map[string]string{
+		"text/plain":                     "text/plain; charset=utf-8",
+		"text/html":                      "text/html; charset=utf-8",
+		"application/json":               "application/json; charset=utf-8",
+		"image/svg+xml":                  "image/svg+xml; charset=utf-8",
+		"text/plain; charset=iso-8859-1": "text/plain; charset=iso-8859-1",
+		"image/png":                      "image/png",
+		"application/octet-stream":       "application/octet-stream",
+	}
+	for input, expected := range tests {
+		if got := withCharset(input); got != expected {
+			t.Errorf("withCharset(%q): expected %q, but got %q", input, expected, got)
+		}
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		content  string
+		expected string
+	}{
+		{name: "Extension", fileName: "index.html", content: "plain words", expected: "text/html; charset=utf-8"},
+		{name: "UpperCaseExtension", fileName: "PHOTO.JPG", content: "", expected: "image/jpeg"},
+		{name: "JSON", fileName: "data.json", content: "{}", expected: "application/json; charset=utf-8"},
+		{name: "SniffedText", fileName: "README", content: "Hello, World!", expected: "text/plain; charset=utf-8"},
+		{name: "SniffedPNG", fileName: "image", content: "\x89PNG\r\n\x1a\nrest", expected: "image/png"},
+		{name: "UnknownExtension", fileName: "blob.xyz", content: "\x00\x00", expected: "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := strings.NewReader(tt.content)
+			got, err := detectContentType(tt.fileName, content, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %s, but got %s", tt.expected, got)
+			}
+			// Sniffing must not consume the content
+			rest, _ := io.ReadAll(content)
+			if string(rest) != tt.content {
+				t.Errorf("Expected content to be rewound, but read %q", rest)
+			}
+		})
+	}
+}
+
+func TestLoadMimeTypes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mime.types")
+	config := "# custom types\n" +
+		"application/x-artifact   art ARTX\n" +
+		"text/x-log log # override the built-in text/plain\n" +
+		"\n" +
+		"application/vnd.empty\n"
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	types, err := LoadMimeTypes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		".art":  "application/x-artifact",
+		".artx": "application/x-artifact",
+		".log":  "text/x-log",
+		".png":  "image/png",
+	}
+	for ext, mediaType := range expected {
+		if types[ext] != mediaType {
+			t.Errorf("Expected %s to map to %s, but got %q", ext, mediaType, types[ext])
+		}
+	}
+	if builtinMimeTypes[".log"] != "text/plain" {
+		t.Errorf("Expected the built-in table to be left unchanged")
+	}
+
+	if err := os.WriteFile(path, []byte("notatype ext\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadMimeTypes(path); err == nil {
+		t.Errorf("Expected an error for an invalid media type")
+	}
+	if _, err := LoadMimeTypes(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
+
+func TestGetFile_ContentType(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(testConfig.Dir, "page.html"), []byte("<p>hi</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	response := generateHttpResponse(HttpRequest{Method: GET, Path: "/files/page.html", Headers: Header{}})
+	if got := response.Headers.Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("Expected Content-Type text/html; charset=utf-8, but got %s", got)
+	}
+	if response.Headers.Has("X-Content-Type-Options") {
+		t.Errorf("Expected no X-Content-Type-Options by default")
+	}
+	if body := readResponseBody(t, response); body != "<p>hi</p>" {
+		t.Errorf("Expected Body <p>hi</p>, but got %s", body)
+	}
+
+	defer func(previous bool) { testConfig.NoSniff = previous }(testConfig.NoSniff)
+	testConfig.NoSniff = true
+	response = generateHttpResponse(HttpRequest{Method: GET, Path: "/files/page.html", Headers: Header{}})
+	readResponseBody(t, response)
+	if got := response.Headers.Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("Expected X-Content-Type-Options nosniff, but got %q", got)
+	}
+}
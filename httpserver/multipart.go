@@ -0,0 +1,203 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"strings"
+)
+
+// maxBoundaryLength is the longest boundary RFC 2046 allows.
+const maxBoundaryLength = 70
+
+// maxPartHeaderBytes bounds the header section of a single part.
+const maxPartHeaderBytes = 16 << 10
+
+// multipartBufferSize must comfortably hold a full delimiter.
+const multipartBufferSize = 32 << 10
+
+// multipartBoundary returns the boundary of a multipart/form-data content
+// type, and false if contentType is something else.
+func multipartBoundary(contentType string) (string, bool, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		return "", false, nil
+	}
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > maxBoundaryLength {
+		return "", true, badRequest("invalid multipart boundary %q", boundary)
+	}
+	return boundary, true, nil
+}
+
+// multipartReader streams the parts of a multipart body, as described in
+// RFC 2046 section 5.1 and RFC 7578. Parts are read one at a time and never
+// buffered in full.
+type multipartReader struct {
+	r *bufio.Reader
+	// dashBoundary is "--boundary", which opens the first part
+	dashBoundary []byte
+	// delimiter is "\r\n--boundary", which ends every part
+	delimiter []byte
+	part      *multipartPart
+	started   bool
+	done      bool
+}
+
+func newMultipartReader(r io.Reader, boundary string) *multipartReader {
+	return &multipartReader{
+		r:            bufio.NewReaderSize(r, multipartBufferSize),
+		dashBoundary: []byte("--" + boundary),
+		delimiter:    []byte("\r\n--" + boundary),
+	}
+}
+
+// multipartPart is one part of a multipart body. Reading it returns the
+// part's content up to the next delimiter.
+type multipartPart struct {
+	Header Header
+	mr     *multipartReader
+	eof    bool
+}
+
+// NextPart skips the rest of the current part and returns the next one, or
+// io.EOF after the closing delimiter.
+func (mr *multipartReader) NextPart() (*multipartPart, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+	if mr.part != nil {
+		if _, err := io.Copy(io.Discard, mr.part); err != nil {
+			return nil, err
+		}
+	} else if !mr.started {
+		if err := mr.skipPreamble(); err != nil {
+			return nil, err
+		}
+	}
+	mr.started = true
+
+	// The delimiter is followed by "--" for the last part, or by optional
+	// whitespace and CRLF
+	line, err := readLine(mr.r, maxPartHeaderBytes)
+	if err != nil {
+		return nil, mr.malformed(err)
+	}
+	if strings.HasPrefix(line, "--") {
+		mr.done = true
+		return nil, io.EOF
+	}
+	if strings.TrimRight(line, " \t") != "" {
+		return nil, badRequest("garbage after multipart delimiter")
+	}
+
+	headers, err := readHeaders(mr.r, maxPartHeaderBytes)
+	if err != nil {
+		return nil, mr.malformed(err)
+	}
+	mr.part = &multipartPart{Header: headers, mr: mr}
+	return mr.part, nil
+}
+
+// skipPreamble discards everything before the first delimiter, leaving the
+// reader just after it.
+func (mr *multipartReader) skipPreamble() error {
+	if prefix, err := mr.r.Peek(len(mr.dashBoundary)); err == nil && bytes.Equal(prefix, mr.dashBoundary) {
+		_, err := mr.r.Discard(len(mr.dashBoundary))
+		return err
+	}
+	part := &multipartPart{mr: mr}
+	if _, err := io.Copy(io.Discard, part); err != nil {
+		return err
+	}
+	return nil
+}
+
+// malformed turns read errors into the 400 a truncated or oversized part
+// header deserves.
+func (mr *multipartReader) malformed(err error) error {
+	var requestErr *RequestError
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return badRequest("multipart body ends without a closing delimiter")
+	}
+	if err == errLineTooLong || errors.As(err, &requestErr) {
+		return badRequest("malformed multipart part: %v", err)
+	}
+	return err
+}
+
+func (p *multipartPart) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	r := p.mr.r
+	delimiter := p.mr.delimiter
+
+	// Peek only returns less than a full buffer at the end of the body
+	peek, err := r.Peek(multipartBufferSize)
+	if i := bytes.Index(peek, delimiter); i >= 0 {
+		if i == 0 {
+			p.eof = true
+			if _, err := r.Discard(len(delimiter)); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		return r.Read(b[:minInt(len(b), i)])
+	}
+	if err != nil {
+		return 0, p.mr.malformed(err)
+	}
+
+	// Keep back enough bytes that a delimiter split across reads is still
+	// found next time
+	return r.Read(b[:minInt(len(b), len(peek)-len(delimiter)+1)])
+}
+
+// finish discards the epilogue after the closing delimiter, surfacing any
+// error the body reports at its end, such as a digest mismatch.
+func (mr *multipartReader) finish() error {
+	_, err := io.Copy(io.Discard, mr.r)
+	return err
+}
+
+// formDataParams returns the field name and filename from a part's
+// Content-Disposition header.
+func (p *multipartPart) formDataParams() (name, filename string, err error) {
+	disposition, params, err := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+	if err != nil || disposition != "form-data" {
+		return "", "", badRequest("invalid Content-Disposition %q", p.Header.Get("Content-Disposition"))
+	}
+	return params["name"], params["filename"], nil
+}
+
+// errPayloadTooLarge is returned by a sizeLimitReader that hit its limit.
+var errPayloadTooLarge = errors.New("payload too large")
+
+// sizeLimitReader fails with errPayloadTooLarge once more than *remaining
+// bytes have been read. The counter may be shared between readers to
+// enforce a total limit. A nil counter means no limit.
+type sizeLimitReader struct {
+	r         io.Reader
+	remaining *int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if l.remaining != nil {
+		*l.remaining -= int64(n)
+		if *l.remaining < 0 {
+			return n, errPayloadTooLarge
+		}
+	}
+	return n, err
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
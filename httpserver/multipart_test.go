@@ -0,0 +1,207 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// buildForm encodes files, keyed by filename, and one plain field as
+// multipart/form-data.
+func buildForm(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("comment", "not a file"); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		part, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(part, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return body.String(), writer.FormDataContentType()
+}
+
+func readParts(t *testing.T, r io.Reader, boundary string) ([]string, error) {
+	t.Helper()
+	reader := newMultipartReader(r, boundary)
+	var parts []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return parts, reader.finish()
+		}
+		if err != nil {
+			return parts, err
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return parts, err
+		}
+		parts = append(parts, part.Header.Get("Content-Disposition")+"|"+string(content))
+	}
+}
+
+func TestMultipartReader(t *testing.T) {
+	body := "preamble\r\n" +
+		"--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"a\"\r\n\r\n" +
+		"first\r\n--xy not a delimiter\r\n" +
+		"--xyz  \r\n" +
+		"Content-Disposition: form-data; name=\"b\"\r\n\r\n" +
+		"\r\n" +
+		"--xyz--\r\n" +
+		"epilogue"
+	want := []string{
+		"form-data; name=\"a\"|first\r\n--xy not a delimiter",
+		"form-data; name=\"b\"|",
+	}
+
+	for _, r := range map[string]io.Reader{
+		"whole":    strings.NewReader(body),
+		"one byte": iotest.OneByteReader(strings.NewReader(body)),
+	} {
+		parts, err := readParts(t, r, "xyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(parts, "\n") != strings.Join(want, "\n") {
+			t.Errorf("Expected parts %q, but got %q", want, parts)
+		}
+	}
+}
+
+func TestMultipartReader_LargePart(t *testing.T) {
+	content := strings.Repeat("0123456789", 10000)
+	body, contentType := buildForm(t, map[string]string{"big.txt": content})
+	boundary, _, err := multipartBoundary(contentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, err := readParts(t, strings.NewReader(body), boundary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 || !strings.HasSuffix(parts[1], "|"+content) {
+		t.Errorf("Expected the large part to survive intact")
+	}
+}
+
+func TestMultipartReader_Malformed(t *testing.T) {
+	tests := map[string]string{
+		"no closing delimiter": "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nabc",
+		"no delimiter at all":  "just some bytes",
+		"garbage after":        "--xyz junk\r\n\r\nabc\r\n--xyz--",
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := readParts(t, strings.NewReader(body), "xyz")
+			var requestErr *RequestError
+			if !errors.As(err, &requestErr) || requestErr.StatusCode != 400 {
+				t.Errorf("Expected a 400 RequestError, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestHandleFiles_MultipartIntoDirectory(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	body, contentType := buildForm(t, map[string]string{"a.txt": "aaa", `C:\Users\me\b.txt`: "bb"})
+
+	request := fileRequest(POST, "uploads/", body)
+	request.Headers.Set("Content-Type", contentType)
+	response := generateHttpResponse(request)
+	if response.StatusCode != 201 {
+		t.Fatalf("Expected StatusCode 201, but got %d: %s", response.StatusCode, response.Body)
+	}
+
+	var manifest struct {
+		Files []storedFile `json:"files"`
+	}
+	if err := json.Unmarshal(response.Body, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 2 {
+		t.Fatalf("Expected 2 files in the manifest, but got %d", len(manifest.Files))
+	}
+	for _, file := range manifest.Files {
+		if file.Field != "file" || !strings.HasPrefix(file.Path, "/files/uploads/") || file.SHA256 == "" {
+			t.Errorf("Unexpected manifest entry %+v", file)
+		}
+	}
+	if content := readTestFile(t, "uploads/a.txt"); content != "aaa" {
+		t.Errorf("Expected content aaa, but got %q", content)
+	}
+	if content := readTestFile(t, "uploads/b.txt"); content != "bb" {
+		t.Errorf("Expected content bb, but got %q", content)
+	}
+}
+
+func TestHandleFiles_MultipartToPath(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	body, contentType := buildForm(t, map[string]string{"ignored.txt": "content"})
+
+	request := fileRequest(POST, "report.txt", body)
+	request.Headers.Set("Content-Type", contentType)
+	if response := generateHttpResponse(request); response.StatusCode != 201 {
+		t.Fatalf("Expected StatusCode 201, but got %d: %s", response.StatusCode, response.Body)
+	}
+	if content := readTestFile(t, "report.txt"); content != "content" {
+		t.Errorf("Expected content content, but got %q", content)
+	}
+
+	body, contentType = buildForm(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	request = fileRequest(POST, "two.txt", body)
+	request.Headers.Set("Content-Type", contentType)
+	if response := generateHttpResponse(request); response.StatusCode != 400 {
+		t.Errorf("Expected StatusCode 400 for two files and a file path, but got %d", response.StatusCode)
+	}
+}
+
+func TestHandleFiles_MultipartLimits(t *testing.T) {
+	defer func(part, form int64) { testConfig.MaxPartSize, testConfig.MaxFormSize = part, form }(testConfig.MaxPartSize, testConfig.MaxFormSize)
+
+	tests := []struct {
+		name     string
+		partSize int64
+		formSize int64
+	}{
+		{name: "part", partSize: 10, formSize: 0},
+		{name: "form", partSize: 0, formSize: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig.Dir = t.TempDir()
+			testConfig.MaxPartSize, testConfig.MaxFormSize = tt.partSize, tt.formSize
+			body, contentType := buildForm(t, map[string]string{"small.txt": "ok", "big.txt": strings.Repeat("x", 1000)})
+
+			request := fileRequest(POST, "", body)
+			request.Headers.Set("Content-Type", contentType)
+			if response := generateHttpResponse(request); response.StatusCode != 413 {
+				t.Errorf("Expected StatusCode 413, but got %d", response.StatusCode)
+			}
+			entries, err := os.ReadDir(testConfig.Dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("Expected nothing to be stored, but found %s", filepath.Join(testConfig.Dir, entries[0].Name()))
+			}
+		})
+	}
+}
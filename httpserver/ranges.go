@@ -0,0 +1,166 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRanges caps how many ranges one request may ask for. Requests for more
+// are served in full instead of as a huge multipart response.
+const maxRanges = 100
+
+// errUnsatisfiableRange means none of the requested ranges overlap the file.
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// byteRange is a satisfiable range of a representation.
+type byteRange struct {
+	start, length int64
+}
+
+// contentRange formats r as a Content-Range value for a representation of
+// the given size.
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header value (RFC 9110 section 14.2) against a
+// representation of size bytes. It returns no ranges if the header should be
+// ignored, either because it is absent, malformed or uses another unit, and
+// errUnsatisfiableRange if every range lies outside the representation.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	unit, set, ok := strings.Cut(header, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	specs := 0
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if specs++; specs > maxRanges {
+			return nil, nil
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+
+		if first == "" {
+			// Suffix range: the final n bytes
+			n, ok := parseRangeInt(last)
+			if !ok {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			ranges = append(ranges, byteRange{start: size - n, length: n})
+			continue
+		}
+
+		start, ok := parseRangeInt(first)
+		if !ok {
+			return nil, nil
+		}
+		end := size - 1
+		if last != "" {
+			if end, ok = parseRangeInt(last); !ok || end < start {
+				return nil, nil
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+
+	if specs == 0 {
+		return nil, nil
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+func parseRangeInt(s string) (int64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// ifRangeMatches evaluates an If-Range precondition. A range request is only
+// honored if the representation is unchanged: the validator must be either
+// a strong entity tag equal to etag, or an HTTP-date exactly equal to
+// modTime.
+func ifRangeMatches(ifRange, etag string, modTime time.Time) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, "\"") || strings.HasPrefix(ifRange, "W/") {
+		return etagStrongMatch(ifRange, etag)
+	}
+	date, err := parseHTTPDate(ifRange)
+	return err == nil && modTime.Truncate(time.Second).Equal(date)
+}
+
+// readCloser pairs a reader with the Closer of the resource it reads from.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// multipartByteranges builds a multipart/byteranges body (RFC 9110 section
+// 14.6) for ranges of content. It returns the body, its exact length and
+// the Content-Type carrying the boundary.
+func multipartByteranges(content io.ReaderAt, size int64, contentType string, ranges []byteRange) (io.Reader, int64, string) {
+	boundary := newBoundary()
+	var parts []io.Reader
+	var length int64
+	for i, r := range ranges {
+		partHeader := fmt.Sprintf("--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			boundary, contentType, r.contentRange(size))
+		if i > 0 {
+			partHeader = "\r\n" + partHeader
+		}
+		parts = append(parts, strings.NewReader(partHeader), io.NewSectionReader(content, r.start, r.length))
+		length += int64(len(partHeader)) + r.length
+	}
+	closing := "\r\n--" + boundary + "--\r\n"
+	parts = append(parts, strings.NewReader(closing))
+	length += int64(len(closing))
+
+	return io.MultiReader(parts...), length, "multipart/byteranges; boundary=" + boundary
+}
+
+func newBoundary() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf[:])
+}
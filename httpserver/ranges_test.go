@@ -0,0 +1,233 @@
+package httpserver
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		size     int64
+		expected []byteRange
+		err      error
+	}{
+		{name: "Closed", header: "bytes=0-4", size: 10, expected: []byteRange{{0, 5}}},
+		{name: "OpenEnded", header: "bytes=3-", size: 10, expected: []byteRange{{3, 7}}},
+		{name: "Suffix", header: "bytes=-3", size: 10, expected: []byteRange{{7, 3}}},
+		{name: "SuffixLargerThanFile", header: "bytes=-50", size: 10, expected: []byteRange{{0, 10}}},
+		{name: "EndPastFile", header: "bytes=5-100", size: 10, expected: []byteRange{{5, 5}}},
+		{name: "Multiple", header: "bytes=0-1, 4-5,-1", size: 10, expected: []byteRange{{0, 2}, {4, 2}, {9, 1}}},
+		{name: "UnitCase", header: "Bytes=0-0", size: 10, expected: []byteRange{{0, 1}}},
+		{name: "SkipsUnsatisfiable", header: "bytes=20-30,0-0", size: 10, expected: []byteRange{{0, 1}}},
+		{name: "StartPastFile", header: "bytes=10-", size: 10, err: errUnsatisfiableRange},
+		{name: "ZeroSuffix", header: "bytes=-0", size: 10, err: errUnsatisfiableRange},
+		{name: "EmptyFile", header: "bytes=0-", size: 0, err: errUnsatisfiableRange},
+		{name: "OtherUnit", header: "items=0-4", size: 10},
+		{name: "Reversed", header: "bytes=5-1", size: 10},
+		{name: "NotANumber", header: "bytes=a-b", size: 10},
+		{name: "Signed", header: "bytes=+1-2", size: 10},
+		{name: "NoDash", header: "bytes=5", size: 10},
+		{name: "NoSpecs", header: "bytes=", size: 10},
+		{name: "TooMany", header: "bytes=" + strings.Repeat("0-0,", maxRanges+1), size: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges, err := parseRange(tt.header, tt.size)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected error %v, but got %v", tt.err, err)
+			}
+			if !reflect.DeepEqual(ranges, tt.expected) {
+				t.Errorf("Expected ranges %v, but got %v", tt.expected, ranges)
+			}
+		})
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 30, 45, 500, time.UTC)
+	tests := []struct {
+		ifRange  string
+		expected bool
+	}{
+		{ifRange: "", expected: true},
+		{ifRange: "Wed, 01 May 2024 12:30:45 GMT", expected: true},
+		{ifRange: "Wednesday, 01-May-24 12:30:45 GMT", expected: true},
+		{ifRange: "Wed, 01 May 2024 12:30:44 GMT", expected: false},
+		{ifRange: "\"strong\"", expected: true},
+		{ifRange: "\"some-etag\"", expected: false},
+		{ifRange: "W/\"strong\"", expected: false},
+		{ifRange: "not a date", expected: false},
+	}
+	for _, tt := range tests {
+		if got := ifRangeMatches(tt.ifRange, "\"strong\"", modTime); got != tt.expected {
+			t.Errorf("ifRangeMatches(%q): expected %v, but got %v", tt.ifRange, tt.expected, got)
+		}
+	}
+}
+
+// writeRangeTestFile creates a file holding the digits 0-9 under a fresh
+// served directory and returns its /files/ path.
+func writeRangeTestFile(t *testing.T) string {
+	t.Helper()
+	testConfig.Dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(testConfig.Dir, "digits.txt"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return "/files/digits.txt"
+}
+
+func TestGetFile_Range(t *testing.T) {
+	path := writeRangeTestFile(t)
+
+	tests := []struct {
+		name                 string
+		rangeHeader          string
+		expectedBody         string
+		expectedContentRange string
+	}{
+		{name: "Closed", rangeHeader: "bytes=2-5", expectedBody: "2345", expectedContentRange: "bytes 2-5/10"},
+		{name: "OpenEnded", rangeHeader: "bytes=7-", expectedBody: "789", expectedContentRange: "bytes 7-9/10"},
+		{name: "Suffix", rangeHeader: "bytes=-2", expectedBody: "89", expectedContentRange: "bytes 8-9/10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := generateHttpResponse(HttpRequest{
+				Method:  GET,
+				Path:    path,
+				Headers: Header{"Range": {tt.rangeHeader}},
+			})
+			if response.StatusCode != 206 {
+				t.Errorf("Expected StatusCode 206, but got %d", response.StatusCode)
+			}
+			if got := response.Headers.Get("Content-Range"); got != tt.expectedContentRange {
+				t.Errorf("Expected Content-Range %s, but got %s", tt.expectedContentRange, got)
+			}
+			if got := response.Headers.Get("Content-Length"); got != strconv.Itoa(len(tt.expectedBody)) {
+				t.Errorf("Expected Content-Length %d, but got %s", len(tt.expectedBody), got)
+			}
+			if body := readResponseBody(t, response); body != tt.expectedBody {
+				t.Errorf("Expected Body %s, but got %s", tt.expectedBody, body)
+			}
+		})
+	}
+}
+
+func TestGetFile_AcceptRanges(t *testing.T) {
+	path := writeRangeTestFile(t)
+	response := generateHttpResponse(HttpRequest{Method: GET, Path: path, Headers: Header{}})
+	if response.StatusCode != 200 {
+		t.Errorf("Expected StatusCode 200, but got %d", response.StatusCode)
+	}
+	if got := response.Headers.Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Expected Accept-Ranges bytes, but got %q", got)
+	}
+	readResponseBody(t, response)
+}
+
+func TestGetFile_MultipleRanges(t *testing.T) {
+	path := writeRangeTestFile(t)
+	response := generateHttpResponse(HttpRequest{
+		Method:  GET,
+		Path:    path,
+		Headers: Header{"Range": {"bytes=0-1,5-6,-1"}},
+	})
+	if response.StatusCode != 206 {
+		t.Fatalf("Expected StatusCode 206, but got %d", response.StatusCode)
+	}
+	mediaType, params, err := mime.ParseMediaType(response.Headers.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Expected multipart/byteranges, but got %q", response.Headers.Get("Content-Type"))
+	}
+
+	body := readResponseBody(t, response)
+	if got := response.Headers.Get("Content-Length"); got != strconv.Itoa(len(body)) {
+		t.Errorf("Expected Content-Length %d, but got %s", len(body), got)
+	}
+
+	expected := []struct{ contentRange, data string }{
+		{"bytes 0-1/10", "01"},
+		{"bytes 5-6/10", "56"},
+		{"bytes 9-9/10", "9"},
+	}
+	reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	for i, want := range expected {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("Part %d: %v", i, err)
+		}
+		if got := part.Header.Get("Content-Range"); got != want.contentRange {
+			t.Errorf("Part %d: expected Content-Range %s, but got %s", i, want.contentRange, got)
+		}
+		if got := part.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+			t.Errorf("Part %d: expected Content-Type text/plain; charset=utf-8, but got %s", i, got)
+		}
+		data, _ := io.ReadAll(part)
+		if string(data) != want.data {
+			t.Errorf("Part %d: expected %q, but got %q", i, want.data, data)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("Expected exactly %d parts, but got error %v", len(expected), err)
+	}
+}
+
+func TestGetFile_RangeNotSatisfiable(t *testing.T) {
+	path := writeRangeTestFile(t)
+	response := generateHttpResponse(HttpRequest{
+		Method:  GET,
+		Path:    path,
+		Headers: Header{"Range": {"bytes=50-60"}},
+	})
+	if response.StatusCode != 416 {
+		t.Errorf("Expected StatusCode 416, but got %d", response.StatusCode)
+	}
+	if got := response.Headers.Get("Content-Range"); got != "bytes */10" {
+		t.Errorf("Expected Content-Range bytes */10, but got %s", got)
+	}
+}
+
+func TestGetFile_IfRange(t *testing.T) {
+	path := writeRangeTestFile(t)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(testConfig.Dir, "digits.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name               string
+		ifRange            string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{name: "Unchanged", ifRange: formatHTTPDate(modTime), expectedStatusCode: 206, expectedBody: "01"},
+		{name: "Changed", ifRange: formatHTTPDate(modTime.Add(-time.Hour)), expectedStatusCode: 200, expectedBody: "0123456789"},
+		{name: "EntityTag", ifRange: "\"abc\"", expectedStatusCode: 200, expectedBody: "0123456789"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := generateHttpResponse(HttpRequest{
+				Method:  GET,
+				Path:    path,
+				Headers: Header{"Range": {"bytes=0-1"}, "If-Range": {tt.ifRange}},
+			})
+			if response.StatusCode != tt.expectedStatusCode {
+				t.Errorf("Expected StatusCode %d, but got %d", tt.expectedStatusCode, response.StatusCode)
+			}
+			if body := readResponseBody(t, response); body != tt.expectedBody {
+				t.Errorf("Expected Body %s, but got %s", tt.expectedBody, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package httpserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// HttpMethod is the method token of an HTTP request.
+type HttpMethod string
+
+const (
+	GET     HttpMethod = "GET"
+	HEAD    HttpMethod = "HEAD"
+	POST    HttpMethod = "POST"
+	PUT     HttpMethod = "PUT"
+	PATCH   HttpMethod = "PATCH"
+	DELETE  HttpMethod = "DELETE"
+	OPTIONS HttpMethod = "OPTIONS"
+)
+
+// HTTP protocol versions understood by the server.
+const (
+	HTTP10 = "HTTP/1.0"
+	HTTP11 = "HTTP/1.1"
+)
+
+// Limits on the size of the request head. Anything larger is rejected before
+// it can exhaust memory.
+const (
+	maxRequestLineLength = 8 << 10
+	maxHeaderBytes       = 64 << 10
+	// maxLeadingEmptyLines is how many stray CRLFs are skipped before a
+	// request line, see RFC 9112 section 2.2
+	maxLeadingEmptyLines = 4
+)
+
+// HttpRequest represents an HTTP request.
+type HttpRequest struct {
+	Method  HttpMethod
+	Path    string
+	Version string
+	Headers Header
+	// Body streams the request payload with any transfer coding removed.
+	// It is never nil for requests produced by readHttpRequest.
+	Body io.Reader
+	// Trailers holds the trailer fields of a chunked request. It is only
+	// populated once Body has been read to the end.
+	Trailers Header
+	// Params holds the wildcard values of the route pattern the request
+	// matched. It is set by Router.
+	Params map[string]string
+}
+
+// PathValue returns the value the wildcard name of the matched route
+// pattern took, or "" if there is none.
+func (r HttpRequest) PathValue(name string) string {
+	return r.Params[name]
+}
+
+// RequestError describes a request the server refuses to process. StatusCode
+// is the response status the client should get.
+type RequestError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%d %s: %v", e.StatusCode, StatusText(e.StatusCode), e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &RequestError{StatusCode: 400, Err: fmt.Errorf(format, args...)}
+}
+
+var (
+	errContentLengthAndChunked   = errors.New("request has both Content-Length and Transfer-Encoding")
+	errUnsupportedTransferCoding = errors.New("unsupported Transfer-Encoding")
+	errURITooLong                = errors.New("request line too long")
+	errHeaderTooLarge            = errors.New("request header fields too large")
+	errUnsupportedVersion        = errors.New("unsupported HTTP version")
+	errLineTooLong               = errors.New("line too long")
+)
+
+// readHttpRequest reads the request line and headers up to the blank line
+// that terminates them, and returns a request whose Body reads the payload
+// directly from r. The caller must consume or discard Body before reading
+// the next request from r.
+//
+// Malformed requests are reported as a *RequestError. io.EOF means the
+// connection was closed cleanly before a new request started.
+func readHttpRequest(r *bufio.Reader) (HttpRequest, error) {
+	line, err := readRequestLine(r)
+	if err != nil {
+		return HttpRequest{}, err
+	}
+	request, err := parseRequestLine(line)
+	if err != nil {
+		return HttpRequest{}, err
+	}
+
+	request.Headers, err = readHeaders(r, maxHeaderBytes)
+	if err != nil {
+		return HttpRequest{}, err
+	}
+	if err := setRequestBody(&request, r); err != nil {
+		return HttpRequest{}, err
+	}
+	return request, nil
+}
+
+// parseHttpRequest parses a complete request held in memory.
+func parseHttpRequest(requestString string) (HttpRequest, error) {
+	return readHttpRequest(bufio.NewReader(strings.NewReader(requestString)))
+}
+
+func readRequestLine(r *bufio.Reader) (string, error) {
+	for i := 0; ; i++ {
+		line, err := readLine(r, maxRequestLineLength)
+		if err == errLineTooLong {
+			return "", &RequestError{StatusCode: 414, Err: errURITooLong}
+		}
+		if err != nil || line != "" {
+			return line, err
+		}
+		if i == maxLeadingEmptyLines {
+			return "", badRequest("missing request line")
+		}
+	}
+}
+
+// parseRequestLine validates "method SP request-target SP HTTP-version".
+func parseRequestLine(line string) (HttpRequest, error) {
+	requestLine := strings.Split(line, " ")
+	if len(requestLine) != 3 {
+		return HttpRequest{}, badRequest("malformed request line %q", line)
+	}
+	method, target, version := requestLine[0], requestLine[1], requestLine[2]
+
+	if !isToken(method) {
+		return HttpRequest{}, badRequest("invalid method %q", method)
+	}
+	path, err := parseRequestTarget(target)
+	if err != nil {
+		return HttpRequest{}, err
+	}
+	if err := checkVersion(version); err != nil {
+		return HttpRequest{}, err
+	}
+
+	return HttpRequest{
+		Method:  HttpMethod(method),
+		Path:    path,
+		Version: version,
+	}, nil
+}
+
+// parseRequestTarget accepts the origin-form ("/path?query"), the asterisk
+// form used by OPTIONS, and the absolute-form ("http://host/path"), which is
+// reduced to its path.
+func parseRequestTarget(target string) (string, error) {
+	for i := 0; i < len(target); i++ {
+		if c := target[i]; c <= ' ' || c == 0x7f {
+			return "", badRequest("invalid character in request target %q", target)
+		}
+	}
+	if target == "*" || strings.HasPrefix(target, "/") {
+		return target, nil
+	}
+	lower := strings.ToLower(target)
+	for _, scheme := range []string{"http://", "https://"} {
+		if strings.HasPrefix(lower, scheme) {
+			rest := target[len(scheme):]
+			if i := strings.IndexAny(rest, "/?"); i >= 0 {
+				if rest[i] == '?' {
+					return "/" + rest[i:], nil
+				}
+				return rest[i:], nil
+			}
+			return "/", nil
+		}
+	}
+	return "", badRequest("invalid request target %q", target)
+}
+
+// checkVersion accepts HTTP/1.0 and HTTP/1.1. Other well formed versions get
+// 505 HTTP Version Not Supported.
+func checkVersion(version string) error {
+	if version == HTTP10 || version == HTTP11 {
+		return nil
+	}
+	if len(version) == len("HTTP/x.y") && strings.HasPrefix(version, "HTTP/") &&
+		isDigit(version[5]) && version[6] == '.' && isDigit(version[7]) {
+		return &RequestError{StatusCode: 505, Err: fmt.Errorf("%w %s", errUnsupportedVersion, version)}
+	}
+	return badRequest("malformed HTTP version %q", version)
+}
+
+// readHeaders reads header field lines up to and including the empty line
+// ending the header section, using at most limit bytes. Obsolete line
+// folding is replaced with a single space, as RFC 9112 section 5.2 allows.
+func readHeaders(r *bufio.Reader, limit int) (Header, error) {
+	headers := make(Header)
+	var lastKey string
+	for {
+		line, err := readLine(r, limit)
+		if err == errLineTooLong {
+			return nil, &RequestError{StatusCode: 431, Err: errHeaderTooLarge}
+		}
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if line == "" {
+			return headers, nil
+		}
+		limit -= len(line) + 2
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if lastKey == "" {
+				return nil, badRequest("continuation line without a header field")
+			}
+			values := headers[lastKey]
+			folded := strings.Trim(line, " \t")
+			if err := checkHeaderValue(lastKey, folded); err != nil {
+				return nil, err
+			}
+			values[len(values)-1] = strings.TrimSpace(values[len(values)-1] + " " + folded)
+			continue
+		}
+
+		name, value, err := parseHeaderLine(line)
+		if err != nil {
+			return nil, err
+		}
+		lastKey = CanonicalHeaderKey(name)
+		headers.Add(lastKey, value)
+	}
+}
+
+// setRequestBody picks the body framing from the request headers, as
+// described in RFC 9112 section 6.3.
+func setRequestBody(request *HttpRequest, r *bufio.Reader) error {
+	if request.Headers.Has("Transfer-Encoding") {
+		// Both framings at once is a classic request smuggling vector
+		if request.Headers.Has("Content-Length") {
+			return &RequestError{StatusCode: 400, Err: errContentLengthAndChunked}
+		}
+		codings := request.Headers.tokens("Transfer-Encoding")
+		if len(codings) != 1 || !strings.EqualFold(codings[0], "chunked") {
+			return &RequestError{StatusCode: 501, Err: errUnsupportedTransferCoding}
+		}
+		request.Trailers = make(Header)
+		request.Body = newChunkedReader(r, request.Trailers)
+		return nil
+	}
+
+	contentLength, err := parseContentLength(request.Headers.Values("Content-Length"))
+	if err != nil {
+		return err
+	}
+	request.Body = &fixedLengthReader{r: r, remaining: contentLength}
+	return nil
+}
+
+// parseHeaderLine splits a "Name: value" field line, trimming the optional
+// whitespace around the value.
+func parseHeaderLine(line string) (string, string, error) {
+	colon := strings.IndexByte(line, ':')
+	if colon <= 0 {
+		return "", "", badRequest("malformed header line %q", line)
+	}
+	name := line[:colon]
+	// Whitespace before the colon is forbidden by RFC 9112 section 5.1
+	if !isToken(name) {
+		return "", "", badRequest("invalid header name %q", name)
+	}
+	value := strings.Trim(line[colon+1:], " \t")
+	if err := checkHeaderValue(name, value); err != nil {
+		return "", "", err
+	}
+	return name, value, nil
+}
+
+// checkHeaderValue rejects field values containing control characters.
+func checkHeaderValue(name, value string) error {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return badRequest("invalid character in header %s", name)
+		}
+	}
+	return nil
+}
+
+// readLine reads a single CRLF (or bare LF) terminated line of at most limit
+// bytes from r, without its line terminator.
+func readLine(r *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		fragment, err := r.ReadSlice('\n')
+		if len(line)+len(fragment) > limit {
+			return "", errLineTooLong
+		}
+		line = append(line, fragment...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		break
+	}
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return string(line), nil
+}
+
+// unexpectedEOF turns a clean EOF in the middle of a request into
+// io.ErrUnexpectedEOF so callers can tell it apart from an idle close.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// parseContentLength parses the Content-Length header values. An absent
+// header means the request has no body; repeated headers must agree.
+func parseContentLength(values []string) (int64, error) {
+	if len(values) == 0 {
+		return 0, nil
+	}
+	// "Content-Length: 5, 5" and repeated fields are allowed as long as
+	// every value is the same (RFC 9110 section 8.6)
+	var value string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if value != "" && part != value {
+				return 0, badRequest("conflicting Content-Length values %q", values)
+			}
+			value = part
+		}
+	}
+
+	if value == "" {
+		return 0, badRequest("empty Content-Length")
+	}
+	for i := 0; i < len(value); i++ {
+		if !isDigit(value[i]) {
+			return 0, badRequest("invalid Content-Length %q", value)
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, badRequest("invalid Content-Length %q", value)
+	}
+	return n, nil
+}
+
+// isToken reports whether s is a non-empty RFC 9110 token.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// fixedLengthReader reads exactly remaining bytes from r, reporting
+// io.ErrUnexpectedEOF if the connection ends early.
+type fixedLengthReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (f *fixedLengthReader) Read(p []byte) (int, error) {
+	if f.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > f.remaining {
+		p = p[:f.remaining]
+	}
+	n, err := f.r.Read(p)
+	f.remaining -= int64(n)
+	if err == io.EOF {
+		if f.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+// maxBodyDrain is how much of an unread request body the server is willing
+// to discard to keep a connection alive for the next request.
+const maxBodyDrain = 256 << 10
+
+// discardBody consumes whatever the handler left unread of body. It reports
+// false if the body was too large or broken, in which case the connection
+// cannot be reused.
+func discardBody(body io.Reader) bool {
+	if body == nil {
+		return true
+	}
+	n, err := io.Copy(io.Discard, io.LimitReader(body, maxBodyDrain+1))
+	return err == nil && n <= maxBodyDrain
+}
@@ -0,0 +1,349 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReadHttpRequest_Pipelined(t *testing.T) {
+	raw := "POST /files/a HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /echo/b HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	reader := bufio.NewReader(strings.NewReader(raw))
+
+	first, err := readHttpRequest(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readRequestBody(t, first); body != "hello" {
+		t.Errorf("Expected Body hello, but got %s", body)
+	}
+
+	second, err := readHttpRequest(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Path != "/echo/b" {
+		t.Errorf("Expected Path /echo/b, but got %s", second.Path)
+	}
+	if body := readRequestBody(t, second); body != "" {
+		t.Errorf("Expected empty Body, but got %s", body)
+	}
+
+	if _, err := readHttpRequest(reader); err != io.EOF {
+		t.Errorf("Expected io.EOF after the last request, but got %v", err)
+	}
+}
+
+func TestReadHttpRequest_TruncatedBody(t *testing.T) {
+	raw := "POST /files/a HTTP/1.1\r\nContent-Length: 10\r\n\r\nshort"
+	request, err := readHttpRequest(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(request.Body); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF, but got %v", err)
+	}
+}
+
+func TestReadHttpRequest_TruncatedHeaders(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	if _, err := readHttpRequest(bufio.NewReader(strings.NewReader(raw))); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF, but got %v", err)
+	}
+}
+
+func TestReadHttpRequest_InvalidContentLength(t *testing.T) {
+	for _, value := range []string{"abc", "-1", "1.5"} {
+		raw := "POST / HTTP/1.1\r\nContent-Length: " + value + "\r\n\r\n"
+		if _, err := readHttpRequest(bufio.NewReader(strings.NewReader(raw))); err == nil {
+			t.Errorf("Expected an error for Content-Length %q", value)
+		}
+	}
+}
+
+func TestHandleConnection_LargeUpload(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+
+	content := make([]byte, 3<<20)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", startTestServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// Send headers and body in many small writes so the server sees the
+	// request split across TCP segments
+	header := "POST /files/artifact.bin HTTP/1.1\r\nContent-Length: " + strconv.Itoa(len(content)) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(header)); err != nil {
+		t.Fatal(err)
+	}
+	for chunk := content; len(chunk) > 0; {
+		n := 1000
+		if n > len(chunk) {
+			n = len(chunk)
+		}
+		if _, err := conn.Write(chunk[:n]); err != nil {
+			t.Fatal(err)
+		}
+		chunk = chunk[n:]
+	}
+	resp, err := readResponse(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected StatusCode 201, but got %d", resp.StatusCode)
+	}
+
+	saved, err := os.ReadFile(filepath.Join(testConfig.Dir, "artifact.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, content) {
+		t.Errorf("Expected %d uploaded bytes to match, got %d bytes", len(content), len(saved))
+	}
+
+	// The connection must still be usable after the upload
+	resp = roundTrip(t, conn, reader, "GET /echo/after HTTP/1.1\r\n\r\n")
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected StatusCode 200, but got %d", resp.StatusCode)
+	}
+}
+
+func TestHandleConnection_UnreadBodyIsDiscarded(t *testing.T) {
+	conn, err := net.Dial("tcp", startTestServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// The echo handler ignores the body, so the server has to skip it to
+	// find the next request
+	resp := roundTrip(t, conn, reader, "POST /echo/first HTTP/1.1\r\nContent-Length: 11\r\n\r\nGET /bogus ")
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected StatusCode 200, but got %d", resp.StatusCode)
+	}
+	resp = roundTrip(t, conn, reader, "GET /echo/second HTTP/1.1\r\n\r\n")
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "second" {
+		t.Errorf("Expected Body second, but got %s", body)
+	}
+}
+
+func TestParseHttpRequest_Errors(t *testing.T) {
+	tests := []struct {
+		name               string
+		requestString      string
+		expectedStatusCode int
+	}{
+		{name: "MissingTarget", requestString: "GET\r\n\r\n", expectedStatusCode: 400},
+		{name: "MissingVersion", requestString: "GET /\r\n\r\n", expectedStatusCode: 400},
+		{name: "ExtraSpaces", requestString: "GET  / HTTP/1.1\r\n\r\n", expectedStatusCode: 400},
+		{name: "TooManyParts", requestString: "GET / HTTP/1.1 extra\r\n\r\n", expectedStatusCode: 400},
+		{name: "InvalidMethod", requestString: "G(T / HTTP/1.1\r\n\r\n", expectedStatusCode: 400},
+		{name: "RelativeTarget", requestString: "GET index.html HTTP/1.1\r\n\r\n", expectedStatusCode: 400},
+		{name: "ControlInTarget", requestString: "GET /a\x00b HTTP/1.1\r\n\r\n", expectedStatusCode: 400},
+		{name: "MalformedVersion", requestString: "GET / HTTQ/1.1\r\n\r\n", expectedStatusCode: 400},
+		{name: "UnsupportedVersion", requestString: "GET / HTTP/2.0\r\n\r\n", expectedStatusCode: 505},
+		{name: "HeaderWithoutColon", requestString: "GET / HTTP/1.1\r\nHost localhost\r\n\r\n", expectedStatusCode: 400},
+		{name: "EmptyHeaderName", requestString: "GET / HTTP/1.1\r\n: value\r\n\r\n", expectedStatusCode: 400},
+		{name: "SpaceBeforeColon", requestString: "GET / HTTP/1.1\r\nHost : localhost\r\n\r\n", expectedStatusCode: 400},
+		{name: "ControlInHeaderValue", requestString: "GET / HTTP/1.1\r\nX-A: a\x01b\r\n\r\n", expectedStatusCode: 400},
+		{name: "SignedContentLength", requestString: "POST / HTTP/1.1\r\nContent-Length: +5\r\n\r\nhello", expectedStatusCode: 400},
+		{name: "TransferCoding", requestString: "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n", expectedStatusCode: 501},
+		{name: "OnlyEmptyLines", requestString: strings.Repeat("\r\n", 10), expectedStatusCode: 400},
+		{
+			name:               "URITooLong",
+			requestString:      "GET /" + strings.Repeat("a", maxRequestLineLength) + " HTTP/1.1\r\n\r\n",
+			expectedStatusCode: 414,
+		},
+		{
+			name:               "HeaderLineTooLarge",
+			requestString:      "GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", maxHeaderBytes) + "\r\n\r\n",
+			expectedStatusCode: 431,
+		},
+		{
+			name:               "TooManyHeaders",
+			requestString:      "GET / HTTP/1.1\r\n" + strings.Repeat("X-Header: "+strings.Repeat("a", 100)+"\r\n", 1000) + "\r\n",
+			expectedStatusCode: 431,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseHttpRequest(tt.requestString)
+			var requestErr *RequestError
+			if !errors.As(err, &requestErr) {
+				t.Fatalf("Expected a *RequestError, but got %v", err)
+			}
+			if requestErr.StatusCode != tt.expectedStatusCode {
+				t.Errorf("Expected StatusCode %d, but got %d (%v)", tt.expectedStatusCode, requestErr.StatusCode, err)
+			}
+		})
+	}
+}
+
+func TestParseHttpRequest_Lenient(t *testing.T) {
+	tests := []struct {
+		name            string
+		requestString   string
+		expectedPath    string
+		expectedHeaders map[string]string
+	}{
+		{
+			name:            "HeaderWhitespace",
+			requestString:   "GET / HTTP/1.1\r\nHost:localhost\r\nUser-Agent: \t curl/8.7.1 \t\r\n\r\n",
+			expectedPath:    "/",
+			expectedHeaders: map[string]string{"Host": "localhost", "User-Agent": "curl/8.7.1"},
+		},
+		{
+			name:            "EmptyHeaderValue",
+			requestString:   "GET / HTTP/1.1\r\nX-Empty:\r\n\r\n",
+			expectedPath:    "/",
+			expectedHeaders: map[string]string{"X-Empty": ""},
+		},
+		{
+			name:          "LeadingEmptyLines",
+			requestString: "\r\n\r\nGET /echo/abc HTTP/1.1\r\n\r\n",
+			expectedPath:  "/echo/abc",
+		},
+		{
+			name:          "BareLF",
+			requestString: "GET /echo/abc HTTP/1.0\nHost: localhost\n\n",
+			expectedPath:  "/echo/abc",
+		},
+		{
+			name:          "AbsoluteForm",
+			requestString: "GET http://localhost:4221/echo/abc HTTP/1.1\r\n\r\n",
+			expectedPath:  "/echo/abc",
+		},
+		{
+			name:          "AbsoluteFormWithoutPath",
+			requestString: "GET http://localhost:4221 HTTP/1.1\r\n\r\n",
+			expectedPath:  "/",
+		},
+		{
+			name:          "Asterisk",
+			requestString: "OPTIONS * HTTP/1.1\r\n\r\n",
+			expectedPath:  "*",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := parseHttpRequest(tt.requestString)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Path != tt.expectedPath {
+				t.Errorf("Expected Path %s, but got %s", tt.expectedPath, req.Path)
+			}
+			for key, expectedValue := range tt.expectedHeaders {
+				if value := req.Headers.Get(key); !req.Headers.Has(key) || value != expectedValue {
+					t.Errorf("Expected Header %s to be %q, but got %q", key, expectedValue, value)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleConnection_MalformedRequests(t *testing.T) {
+	addr := startTestServer(t)
+
+	tests := []struct {
+		name               string
+		requestString      string
+		expectedStatusCode int
+	}{
+		{name: "BadRequest", requestString: "GARBAGE\r\n\r\n", expectedStatusCode: 400},
+		{name: "HeaderWithoutColon", requestString: "GET / HTTP/1.1\r\nnonsense\r\n\r\n", expectedStatusCode: 400},
+		{name: "VersionNotSupported", requestString: "GET / HTTP/3.0\r\n\r\n", expectedStatusCode: 505},
+		{
+			name:               "URITooLong",
+			requestString:      "GET /" + strings.Repeat("a", 10000) + " HTTP/1.1\r\n\r\n",
+			expectedStatusCode: 414,
+		},
+		{
+			name:               "HeaderTooLarge",
+			requestString:      "GET / HTTP/1.1\r\nCookie: " + strings.Repeat("a", 70000) + "\r\n\r\n",
+			expectedStatusCode: 431,
+		},
+		{
+			name:               "BadChunk",
+			requestString:      "POST /files/bad HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nxyz\r\n",
+			expectedStatusCode: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig.Dir = t.TempDir()
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+
+			resp := roundTrip(t, conn, reader, tt.requestString)
+			if resp.StatusCode != tt.expectedStatusCode {
+				t.Errorf("Expected StatusCode %d, but got %d", tt.expectedStatusCode, resp.StatusCode)
+			}
+			if !resp.Close {
+				t.Errorf("Expected the connection to be closed after a malformed request")
+			}
+		})
+	}
+}
+
+func FuzzParseHttpRequest(f *testing.F) {
+	seeds := []string{
+		"GET / HTTP/1.1\r\nHost: localhost:4221\r\nUser-Agent: curl/8.7.1\r\nAccept: */*\r\n\r\n",
+		"POST /files/file_123 HTTP/1.1\r\nContent-Length: 5\r\n\r\n12345",
+		"POST /files/x HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5;a=b\r\nHello\r\n0\r\nX-T: 1\r\n\r\n",
+		"GET http://localhost/echo/abc HTTP/1.0\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost localhost\r\n\r\n",
+		"",
+		"\r\n",
+		"GET",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, requestString string) {
+		req, err := parseHttpRequest(requestString)
+		if err != nil {
+			return
+		}
+		if !isToken(string(req.Method)) {
+			t.Errorf("Parsed invalid method %q", req.Method)
+		}
+		if req.Path != "*" && !strings.HasPrefix(req.Path, "/") {
+			t.Errorf("Parsed invalid path %q", req.Path)
+		}
+		if req.Version != HTTP10 && req.Version != HTTP11 {
+			t.Errorf("Parsed unsupported version %q", req.Version)
+		}
+		if req.Body == nil {
+			t.Fatalf("Parsed request without a Body")
+		}
+		// Reading the body must never panic, whatever the framing says
+		_, _ = io.Copy(io.Discard, req.Body)
+	})
+}
@@ -0,0 +1,315 @@
+package httpserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// HttpResponse represents an HTTP response.
+type HttpResponse struct {
+	StatusCode int
+	Status     string
+	Headers    Header
+	Body       []byte
+	// BodyReader, when set, is streamed to the client instead of Body. If it
+	// implements io.Closer it is closed once the response has been written.
+	// Without a Content-Length header the body is sent chunked.
+	BodyReader io.Reader
+	Encoding   Encoding
+}
+
+func (r *HttpResponse) GetBodyAsString() string {
+	return string(r.Body)
+}
+
+func (r *HttpResponse) SetBodyFromString(body string) {
+	r.Body = []byte(body)
+}
+
+// textResponse builds a plain text response, as used for errors.
+func textResponse(statusCode int, body string) HttpResponse {
+	return HttpResponse{
+		StatusCode: statusCode,
+		Status:     StatusText(statusCode),
+		Headers:    Header{"Content-Type": {"text/plain"}},
+		Body:       []byte(body),
+	}
+}
+
+// statusText holds the reason phrases for the status codes the server sends.
+var statusText = map[int]string{
+	200: "OK",
+	201: "Created",
+	204: "No Content",
+	206: "Partial Content",
+	301: "Moved Permanently",
+	304: "Not Modified",
+	400: "Bad Request",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	406: "Not Acceptable",
+	409: "Conflict",
+	412: "Precondition Failed",
+	413: "Payload Too Large",
+	414: "URI Too Long",
+	415: "Unsupported Media Type",
+	416: "Range Not Satisfiable",
+	431: "Request Header Fields Too Large",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	505: "HTTP Version Not Supported",
+}
+
+// StatusText returns the reason phrase for an HTTP status code.
+func StatusText(code int) string {
+	if text, ok := statusText[code]; ok {
+		return text
+	}
+	return "Status " + strconv.Itoa(code)
+}
+
+// ResponseWriter is used to construct an HTTP response. Headers must be set
+// before WriteHeader or the first call to Write; after that the body is
+// streamed to the client as it is written.
+type ResponseWriter interface {
+	// Header returns the headers that will be sent by WriteHeader.
+	Header() Header
+	// Write writes body bytes, sending a 200 OK header first if WriteHeader
+	// has not been called yet.
+	Write([]byte) (int, error)
+	// WriteHeader sends the status line and headers.
+	WriteHeader(statusCode int)
+}
+
+var errBodyTooLong = errors.New("response body longer than Content-Length")
+
+// responseWriter is the ResponseWriter for a single request on a connection.
+// The body is framed with the declared Content-Length if there is one, or
+// with chunked transfer coding otherwise.
+type responseWriter struct {
+	w         *bufio.Writer
+	request   HttpRequest
+	header    Header
+	keepAlive bool
+	// closing, if set, reports whether the server is going away, in which
+	// case the connection isn't kept either
+	closing func() bool
+
+	wroteHeader bool
+	// contentLength is the declared body length, or -1 if unknown
+	contentLength int64
+	written       int64
+	chunked       *chunkedWriter
+	// noBody is set for responses that can't carry a body
+	noBody bool
+	err    error
+}
+
+func newResponseWriter(w *bufio.Writer, request HttpRequest, keepAlive bool) *responseWriter {
+	return &responseWriter{
+		w:             w,
+		request:       request,
+		header:        make(Header),
+		keepAlive:     keepAlive,
+		contentLength: -1,
+	}
+}
+
+func (rw *responseWriter) Header() Header {
+	return rw.header
+}
+
+func (rw *responseWriter) WriteHeader(statusCode int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+
+	// Whatever the handler left of the request body is skipped now, so the
+	// connection is only kept if that is cheap
+	if rw.keepAlive && !discardBody(rw.request.Body) {
+		rw.keepAlive = false
+	}
+	if rw.keepAlive && rw.closing != nil && rw.closing() {
+		rw.keepAlive = false
+	}
+
+	rw.noBody = statusCode < 200 || statusCode == 204 || statusCode == 304
+	if rw.noBody {
+		rw.header.Del("Content-Length")
+		rw.header.Del("Transfer-Encoding")
+	} else if rw.request.Method == HEAD {
+		// A HEAD response describes the GET body without sending it, so its
+		// Content-Length is kept but nothing is framed
+		rw.noBody = true
+	} else if rw.header.Has("Content-Length") {
+		value := rw.header.Get("Content-Length")
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			rw.fail(fmt.Errorf("invalid response Content-Length %q", value))
+			return
+		}
+		rw.contentLength = n
+	} else if rw.request.Version == HTTP10 {
+		// HTTP/1.0 clients don't understand chunked encoding, so the end of
+		// the body can only be signaled by closing the connection
+		rw.keepAlive = false
+	} else {
+		rw.header.Set("Transfer-Encoding", "chunked")
+		rw.chunked = newChunkedWriter(rw.w)
+	}
+
+	if !rw.keepAlive {
+		rw.header.Set("Connection", "close")
+	} else if rw.request.Version == HTTP10 {
+		// HTTP/1.0 clients only keep the connection if we say so
+		rw.header.Set("Connection", "keep-alive")
+	}
+
+	if _, err := fmt.Fprintf(rw.w, "HTTP/1.1 %d %s\r\n", statusCode, StatusText(statusCode)); err != nil {
+		rw.fail(err)
+		return
+	}
+	if err := rw.header.Write(rw.w); err != nil {
+		rw.fail(err)
+		return
+	}
+	if _, err := io.WriteString(rw.w, "\r\n"); err != nil {
+		rw.fail(err)
+		return
+	}
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(200)
+	}
+	if rw.err != nil {
+		return 0, rw.err
+	}
+	if rw.noBody {
+		return len(p), nil
+	}
+	if rw.contentLength >= 0 && rw.written+int64(len(p)) > rw.contentLength {
+		rw.fail(errBodyTooLong)
+		return 0, rw.err
+	}
+
+	var n int
+	var err error
+	if rw.chunked != nil {
+		n, err = rw.chunked.Write(p)
+	} else {
+		n, err = rw.w.Write(p)
+	}
+	rw.written += int64(n)
+	if err != nil {
+		rw.fail(err)
+	}
+	return n, err
+}
+
+// finish completes the response and flushes it to the connection. It
+// reports whether the connection can be reused for another request.
+func (rw *responseWriter) finish() (bool, error) {
+	if !rw.wroteHeader {
+		// Nothing was written, so the body is known to be empty
+		if !rw.header.Has("Content-Length") {
+			rw.header.Set("Content-Length", "0")
+		}
+		rw.WriteHeader(200)
+	}
+	if rw.err == nil && rw.chunked != nil {
+		if err := rw.chunked.Close(); err != nil {
+			rw.fail(err)
+		}
+	}
+	if rw.err == nil && rw.contentLength >= 0 && rw.written < rw.contentLength {
+		// The client is still waiting for bytes that will never come
+		rw.fail(fmt.Errorf("response body shorter than Content-Length: wrote %d of %d bytes",
+			rw.written, rw.contentLength))
+	}
+	if err := rw.w.Flush(); err != nil && rw.err == nil {
+		rw.fail(err)
+	}
+	return rw.keepAlive && rw.err == nil, rw.err
+}
+
+// discardsBody reports whether the body of the response is dropped, as for
+// HEAD requests, once the header has been written.
+func (rw *responseWriter) discardsBody() bool {
+	return rw.wroteHeader && rw.noBody
+}
+
+// bodyDiscarder is implemented by response writers that can tell a body
+// would be dropped, so a streamed one needn't be read.
+type bodyDiscarder interface {
+	discardsBody() bool
+}
+
+func discardsBody(w ResponseWriter) bool {
+	d, ok := w.(bodyDiscarder)
+	return ok && d.discardsBody()
+}
+
+// abort gives up on the response after err, closing the connection once it
+// has been flushed. finish reports err.
+func (rw *responseWriter) abort(err error) {
+	rw.fail(err)
+}
+
+// responseAborter is implemented by response writers that can give up on a
+// response a handler failed to write.
+type responseAborter interface {
+	abort(err error)
+}
+
+// abortResponse gives up on the response being written to w after err.
+func abortResponse(w ResponseWriter, err error) {
+	if a, ok := w.(responseAborter); ok {
+		a.abort(err)
+	}
+}
+
+func (rw *responseWriter) fail(err error) {
+	if rw.err == nil {
+		rw.err = err
+	}
+	rw.keepAlive = false
+}
+
+// writeHttpResponse sends response through w. In-memory bodies get a
+// Content-Length; a BodyReader is streamed and closed afterwards.
+func writeHttpResponse(w ResponseWriter, response HttpResponse) error {
+	if response.BodyReader != nil {
+		if closer, ok := response.BodyReader.(io.Closer); ok {
+			defer closer.Close()
+		}
+	}
+
+	header := w.Header()
+	for key, values := range response.Headers {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+	if response.BodyReader == nil && !header.Has("Content-Length") {
+		header.Set("Content-Length", strconv.Itoa(len(response.Body)))
+	}
+	w.WriteHeader(response.StatusCode)
+
+	if response.BodyReader != nil {
+		if discardsBody(w) {
+			// Don't read a body that would be discarded
+			return nil
+		}
+		_, err := io.Copy(w, response.BodyReader)
+		return err
+	}
+	_, err := w.Write(response.Body)
+	return err
+}
@@ -0,0 +1,224 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeTestResponse writes response for request into a buffer, through the
+// compression middleware as the server does, and parses it back with the
+// standard library, which also undoes chunked encoding.
+func writeTestResponse(t *testing.T, request HttpRequest, response HttpResponse) (*http.Response, bool, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	rw := newResponseWriter(w, request, true)
+	cw := newCompressWriter(rw, request, DefaultCompressionLevels())
+	writeErr := writeHttpResponse(cw, response)
+	if err := cw.close(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	keepAlive, err := rw.finish()
+	if writeErr != nil {
+		err = writeErr
+	}
+
+	resp, parseErr := readResponse(bufio.NewReader(&buf))
+	if parseErr != nil {
+		t.Fatalf("Error parsing %q: %v", buf.String(), parseErr)
+	}
+	return resp, keepAlive, err
+}
+
+// serveTestResponse generates the response to request and passes it through
+// the response writer, as the server's handler does, so content coding
+// applies.
+func serveTestResponse(t *testing.T, request HttpRequest) HttpResponse {
+	t.Helper()
+	if request.Version == "" {
+		request.Version = HTTP11
+	}
+	resp, _, err := writeTestResponse(t, request, generateHttpResponse(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return HttpResponse{
+		StatusCode: resp.StatusCode,
+		Status:     strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)+" "),
+		Headers:    Header(resp.Header),
+		Body:       body,
+	}
+}
+
+func TestWriteHttpResponse_ContentLength(t *testing.T) {
+	resp, keepAlive, err := writeTestResponse(t, HttpRequest{Version: HTTP11}, HttpResponse{
+		StatusCode: 200,
+		Headers:    Header{"Content-Type": {"text/plain"}},
+		Body:       []byte("abc"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keepAlive {
+		t.Errorf("Expected the connection to be reusable")
+	}
+	if resp.ContentLength != 3 {
+		t.Errorf("Expected Content-Length 3, but got %d", resp.ContentLength)
+	}
+	if len(resp.TransferEncoding) != 0 {
+		t.Errorf("Expected no Transfer-Encoding, but got %v", resp.TransferEncoding)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "abc" {
+		t.Errorf("Expected Body abc, but got %s", body)
+	}
+}
+
+func TestWriteHttpResponse_ChunkedWhenLengthUnknown(t *testing.T) {
+	content := strings.Repeat("generated output\n", 1000)
+	resp, keepAlive, err := writeTestResponse(t, HttpRequest{Version: HTTP11}, HttpResponse{
+		StatusCode: 200,
+		BodyReader: strings.NewReader(content),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keepAlive {
+		t.Errorf("Expected the connection to be reusable")
+	}
+	if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("Expected chunked Transfer-Encoding, but got %v", resp.TransferEncoding)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != content {
+		t.Errorf("Expected %d body bytes, but got %d", len(content), len(body))
+	}
+}
+
+func TestWriteHttpResponse_HTTP10UnknownLengthCloses(t *testing.T) {
+	resp, keepAlive, err := writeTestResponse(t, HttpRequest{Version: HTTP10}, HttpResponse{
+		StatusCode: 200,
+		BodyReader: strings.NewReader("stream"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keepAlive {
+		t.Errorf("Expected the connection to be closed to delimit the body")
+	}
+	if !resp.Close || len(resp.TransferEncoding) != 0 {
+		t.Errorf("Expected an unframed body ended by closing the connection")
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "stream" {
+		t.Errorf("Expected Body stream, but got %s", body)
+	}
+}
+
+func TestWriteHttpResponse_NoBodyStatus(t *testing.T) {
+	resp, keepAlive, err := writeTestResponse(t, HttpRequest{Version: HTTP11}, HttpResponse{
+		StatusCode: 204,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keepAlive {
+		t.Errorf("Expected the connection to be reusable")
+	}
+	if resp.StatusCode != 204 || resp.Status != "204 No Content" {
+		t.Errorf("Expected 204 No Content, but got %s", resp.Status)
+	}
+	if _, ok := resp.Header["Content-Length"]; ok {
+		t.Errorf("Expected no Content-Length on a 204 response")
+	}
+}
+
+func TestResponseWriter_LengthMismatch(t *testing.T) {
+	t.Run("TooShort", func(t *testing.T) {
+		rw := newResponseWriter(bufio.NewWriter(io.Discard), HttpRequest{Version: HTTP11}, true)
+		rw.Header().Set("Content-Length", "10")
+		if _, err := rw.Write([]byte("short")); err != nil {
+			t.Fatal(err)
+		}
+		if keepAlive, err := rw.finish(); err == nil || keepAlive {
+			t.Errorf("Expected a short body to fail and close the connection")
+		}
+	})
+
+	t.Run("TooLong", func(t *testing.T) {
+		rw := newResponseWriter(bufio.NewWriter(io.Discard), HttpRequest{Version: HTTP11}, true)
+		rw.Header().Set("Content-Length", "2")
+		if _, err := rw.Write([]byte("too long")); err != errBodyTooLong {
+			t.Errorf("Expected errBodyTooLong, but got %v", err)
+		}
+		if keepAlive, _ := rw.finish(); keepAlive {
+			t.Errorf("Expected the connection to be closed")
+		}
+	})
+}
+
+func TestChunkedWriter_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := newChunkedWriter(&buf)
+	for _, part := range []string{"Hello", "", ", ", "World"} {
+		if _, err := w.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "5\r\nHello\r\n2\r\n, \r\n5\r\nWorld\r\n0\r\n\r\n" {
+		t.Errorf("Unexpected chunked encoding %q", buf.String())
+	}
+
+	body, err := io.ReadAll(newChunkedReader(bufio.NewReader(&buf), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "Hello, World" {
+		t.Errorf("Expected Body %q, but got %q", "Hello, World", body)
+	}
+}
+
+func TestHandleConnection_LargeDownload(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	content := make([]byte, 4<<20)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(testConfig.Dir, "big.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", startTestServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for i := 0; i < 2; i++ {
+		resp := roundTrip(t, conn, reader, "GET /files/big.bin HTTP/1.1\r\n\r\n")
+		if resp.ContentLength != int64(len(content)) {
+			t.Errorf("Expected Content-Length %d, but got %d", len(content), resp.ContentLength)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if !bytes.Equal(body, content) {
+			t.Errorf("Expected downloaded bytes to match the file")
+		}
+	}
+}
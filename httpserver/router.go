@@ -0,0 +1,286 @@
+package httpserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Router dispatches requests to the handler registered for their path and
+// method.
+//
+// Patterns are slash separated segments. A segment is either literal text,
+// "{name}", which matches any one non-empty segment, or, as the last
+// segment only, "{name...}", which matches the rest of the path, possibly
+// empty. Matched values are available from HttpRequest.PathValue, still
+// percent-encoded. When several patterns match, literal segments win over
+// wildcards and "{name}" wins over "{name...}".
+//
+// A path that matches no pattern gets 404 Not Found and a method without a
+// handler gets 405 Method Not Allowed with an Allow header. HEAD falls back
+// to the GET handler and OPTIONS is answered with the allowed methods unless
+// a handler is registered for it.
+type Router struct {
+	routes []*route
+}
+
+type route struct {
+	pattern  string
+	segments []routeSegment
+	handlers map[HttpMethod]ResponseFunc
+}
+
+type routeSegment struct {
+	literal string
+	// name is set for wildcards; rest for a trailing "{name...}".
+	name string
+	rest bool
+}
+
+// methodOrder is the order methods are listed in Allow.
+var methodOrder = []HttpMethod{GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for method requests to paths matching pattern.
+// It panics if the pattern is malformed or already has a handler for method,
+// both of which are programming errors.
+func (r *Router) Handle(method HttpMethod, pattern string, handler ResponseFunc) {
+	rt := r.lookupPattern(pattern)
+	if rt == nil {
+		segments, err := parsePattern(pattern)
+		if err != nil {
+			panic(err)
+		}
+		rt = &route{pattern: pattern, segments: segments, handlers: map[HttpMethod]ResponseFunc{}}
+		r.routes = append(r.routes, rt)
+	}
+	if _, ok := rt.handlers[method]; ok {
+		panic(fmt.Sprintf("router: %s %s registered twice", method, pattern))
+	}
+	rt.handlers[method] = handler
+}
+
+func (r *Router) Get(pattern string, handler ResponseFunc) {
+	r.Handle(GET, pattern, handler)
+}
+
+func (r *Router) Post(pattern string, handler ResponseFunc) {
+	r.Handle(POST, pattern, handler)
+}
+
+func (r *Router) lookupPattern(pattern string) *route {
+	for _, rt := range r.routes {
+		if rt.pattern == pattern {
+			return rt
+		}
+	}
+	return nil
+}
+
+// parsePattern splits pattern into its segments.
+func parsePattern(pattern string) ([]routeSegment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("router: pattern %q does not start with /", pattern)
+	}
+	if pattern == "/" {
+		return nil, nil
+	}
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]routeSegment, len(parts))
+	names := map[string]bool{}
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("router: pattern %q has a malformed segment %q", pattern, part)
+			}
+			segments[i] = routeSegment{literal: part}
+			continue
+		}
+		name := strings.TrimSuffix(part[1:], "}")
+		if name == part[1:] {
+			return nil, fmt.Errorf("router: pattern %q has an unterminated wildcard", pattern)
+		}
+		rest := strings.HasSuffix(name, "...")
+		name = strings.TrimSuffix(name, "...")
+		if name == "" || strings.ContainsAny(name, "{}.") {
+			return nil, fmt.Errorf("router: pattern %q has a malformed wildcard %q", pattern, part)
+		}
+		if rest && i != len(parts)-1 {
+			return nil, fmt.Errorf("router: pattern %q has %q before its last segment", pattern, part)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("router: pattern %q uses %q twice", pattern, name)
+		}
+		names[name] = true
+		segments[i] = routeSegment{name: name, rest: rest}
+	}
+	return segments, nil
+}
+
+// match reports whether path matches the route and the wildcard values it
+// binds.
+func (rt *route) match(path string) (map[string]string, bool) {
+	var parts []string
+	if path != "/" {
+		parts = strings.Split(path[1:], "/")
+	}
+	params := map[string]string{}
+	for i, segment := range rt.segments {
+		if segment.rest && i < len(parts) {
+			params[segment.name] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch {
+		case segment.name != "" && parts[i] != "":
+			params[segment.name] = parts[i]
+		case segment.name == "" && parts[i] == segment.literal:
+		default:
+			return nil, false
+		}
+	}
+	return params, len(parts) == len(rt.segments)
+}
+
+// moreSpecific reports whether rt should win over other when both match the
+// same path.
+func (rt *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(rt.segments) && i < len(other.segments); i++ {
+		a, b := rt.segments[i].rank(), other.segments[i].rank()
+		if a != b {
+			return a < b
+		}
+	}
+	return len(rt.segments) > len(other.segments)
+}
+
+func (s routeSegment) rank() int {
+	switch {
+	case s.name == "":
+		return 0
+	case !s.rest:
+		return 1
+	}
+	return 2
+}
+
+// handler returns the handler for method, falling back to GET for HEAD.
+func (rt *route) handler(method HttpMethod) ResponseFunc {
+	if handler, ok := rt.handlers[method]; ok {
+		return handler
+	}
+	if method == HEAD {
+		return rt.handlers[GET]
+	}
+	return nil
+}
+
+// allowed lists the methods rt answers, HEAD and OPTIONS included.
+func (rt *route) allowed() map[HttpMethod]bool {
+	methods := map[HttpMethod]bool{OPTIONS: true}
+	for method := range rt.handlers {
+		methods[method] = true
+	}
+	if methods[GET] {
+		methods[HEAD] = true
+	}
+	return methods
+}
+
+// ServeHTTP writes the response to request to w.
+func (r *Router) ServeHTTP(w ResponseWriter, request HttpRequest) {
+	ResponseFunc(r.Respond).ServeHTTP(w, request)
+}
+
+// Respond answers request with the handler of the most specific route that
+// has one for its method.
+func (r *Router) Respond(request HttpRequest) HttpResponse {
+	urlPath, _, _ := strings.Cut(request.Path, "?")
+	if urlPath == "*" && request.Method == OPTIONS {
+		allowed := map[HttpMethod]bool{}
+		for _, rt := range r.routes {
+			for method := range rt.allowed() {
+				allowed[method] = true
+			}
+		}
+		return optionsResponse(allowed)
+	}
+
+	var best *route
+	var bestParams map[string]string
+	allowed := map[HttpMethod]bool{}
+	matched := false
+	for _, rt := range r.routes {
+		params, ok := rt.match(urlPath)
+		if !ok {
+			continue
+		}
+		matched = true
+		for method := range rt.allowed() {
+			allowed[method] = true
+		}
+		if rt.handler(request.Method) != nil && (best == nil || rt.moreSpecific(best)) {
+			best, bestParams = rt, params
+		}
+	}
+
+	switch {
+	case best != nil:
+		request.Params = bestParams
+		return best.handler(request.Method)(request)
+	case !matched:
+		return textResponse(404, "Path not found")
+	case request.Method == OPTIONS:
+		return optionsResponse(allowed)
+	}
+	return methodNotAllowed(allowed)
+}
+
+// methodNotAllowed answers a request whose method the resource doesn't
+// support, listing those it does in Allow as RFC 9110 requires.
+func methodNotAllowed(allowed map[HttpMethod]bool) HttpResponse {
+	response := textResponse(405, "Method Not Allowed")
+	response.Headers.Set("Allow", allowHeader(allowed))
+	return response
+}
+
+func optionsResponse(allowed map[HttpMethod]bool) HttpResponse {
+	return HttpResponse{
+		StatusCode: 204,
+		Status:     StatusText(204),
+		Headers:    Header{"Allow": {allowHeader(allowed)}},
+	}
+}
+
+// allowHeader lists methods in methodOrder, followed by any others
+// alphabetically.
+func allowHeader(methods map[HttpMethod]bool) string {
+	var names []string
+	for _, method := range methodOrder {
+		if methods[method] {
+			names = append(names, string(method))
+		}
+	}
+	var others []string
+	for method := range methods {
+		if !isKnownMethod(method) {
+			others = append(others, string(method))
+		}
+	}
+	sort.Strings(others)
+	return strings.Join(append(names, others...), ", ")
+}
+
+func isKnownMethod(method HttpMethod) bool {
+	for _, known := range methodOrder {
+		if method == known {
+			return true
+		}
+	}
+	return false
+}
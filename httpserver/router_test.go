@@ -0,0 +1,165 @@
+package httpserver
+
+import (
+	"testing"
+)
+
+// echoParams answers with the route that matched and its wildcard values.
+func echoParams(name string) ResponseFunc {
+	return func(request HttpRequest) HttpResponse {
+		body := name
+		for _, key := range []string{"id", "path", "rest"} {
+			if value, ok := request.Params[key]; ok {
+				body += " " + key + "=" + value
+			}
+		}
+		return textResponse(200, body)
+	}
+}
+
+func newTestRouter() *Router {
+	router := NewRouter()
+	router.Get("/", echoParams("root"))
+	router.Get("/users/{id}", echoParams("user"))
+	router.Handle(DELETE, "/users/{id}", echoParams("delete user"))
+	router.Get("/users/me", echoParams("me"))
+	router.Get("/files/{path...}", echoParams("files"))
+	router.Get("/files/{id}/meta", echoParams("meta"))
+	router.Post("/upload", echoParams("upload"))
+	router.Handle(OPTIONS, "/upload", echoParams("upload options"))
+	return router
+}
+
+func TestRouter_Match(t *testing.T) {
+	tests := []struct {
+		path         string
+		expectedBody string
+	}{
+		{path: "/", expectedBody: "root"},
+		{path: "/users/42", expectedBody: "user id=42"},
+		{path: "/users/42?verbose=1", expectedBody: "user id=42"},
+		{path: "/users/me", expectedBody: "me"},
+		{path: "/users/a%2Fb", expectedBody: "user id=a%2Fb"},
+		{path: "/files/", expectedBody: "files path="},
+		{path: "/files/a/b/c.txt", expectedBody: "files path=a/b/c.txt"},
+		{path: "/files/x/meta", expectedBody: "meta id=x"},
+		{path: "/files/x/y/meta", expectedBody: "files path=x/y/meta"},
+	}
+	router := newTestRouter()
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			response := router.Respond(HttpRequest{Method: GET, Path: tt.path, Headers: Header{}})
+			if response.StatusCode != 200 {
+				t.Fatalf("Expected StatusCode 200, but got %d", response.StatusCode)
+			}
+			if body := response.GetBodyAsString(); body != tt.expectedBody {
+				t.Errorf("Expected Body %q, but got %q", tt.expectedBody, body)
+			}
+		})
+	}
+}
+
+func TestRouter_NotFound(t *testing.T) {
+	router := newTestRouter()
+	for _, path := range []string{"/users", "/users/", "/users/42/extra", "/files", "/nope"} {
+		response := router.Respond(HttpRequest{Method: GET, Path: path, Headers: Header{}})
+		if response.StatusCode != 404 {
+			t.Errorf("Expected StatusCode 404 for %s, but got %d", path, response.StatusCode)
+		}
+	}
+}
+
+func TestRouter_MethodNotAllowed(t *testing.T) {
+	router := newTestRouter()
+	tests := []struct {
+		method        HttpMethod
+		path          string
+		expectedAllow string
+	}{
+		{method: PUT, path: "/users/42", expectedAllow: "GET, HEAD, DELETE, OPTIONS"},
+		// /users/me only has GET, but /users/{id} matches it too
+		{method: DELETE + "X", path: "/users/me", expectedAllow: "GET, HEAD, DELETE, OPTIONS"},
+		{method: GET, path: "/upload", expectedAllow: "POST, OPTIONS"},
+	}
+	for _, tt := range tests {
+		response := router.Respond(HttpRequest{Method: tt.method, Path: tt.path, Headers: Header{}})
+		if response.StatusCode != 405 {
+			t.Errorf("Expected StatusCode 405 for %s %s, but got %d", tt.method, tt.path, response.StatusCode)
+		}
+		if allow := response.Headers.Get("Allow"); allow != tt.expectedAllow {
+			t.Errorf("Expected Allow %q for %s, but got %q", tt.expectedAllow, tt.path, allow)
+		}
+	}
+
+	// A more general route answers a method the most specific one lacks
+	response := router.Respond(HttpRequest{Method: DELETE, Path: "/users/me", Headers: Header{}})
+	if body := response.GetBodyAsString(); body != "delete user id=me" {
+		t.Errorf("Expected Body %q, but got %q", "delete user id=me", body)
+	}
+}
+
+func TestRouter_HeadFallsBackToGet(t *testing.T) {
+	response := newTestRouter().Respond(HttpRequest{Method: HEAD, Path: "/users/7", Headers: Header{}})
+	if response.StatusCode != 200 {
+		t.Errorf("Expected StatusCode 200, but got %d", response.StatusCode)
+	}
+	if body := response.GetBodyAsString(); body != "user id=7" {
+		t.Errorf("Expected Body %q, but got %q", "user id=7", body)
+	}
+}
+
+func TestRouter_Options(t *testing.T) {
+	router := newTestRouter()
+	tests := []struct {
+		path               string
+		expectedStatusCode int
+		expectedAllow      string
+	}{
+		{path: "/users/7", expectedStatusCode: 204, expectedAllow: "GET, HEAD, DELETE, OPTIONS"},
+		{path: "/upload", expectedStatusCode: 200, expectedAllow: ""},
+		{path: "*", expectedStatusCode: 204, expectedAllow: "GET, HEAD, POST, DELETE, OPTIONS"},
+		{path: "/nope", expectedStatusCode: 404, expectedAllow: ""},
+	}
+	for _, tt := range tests {
+		response := router.Respond(HttpRequest{Method: OPTIONS, Path: tt.path, Headers: Header{}})
+		if response.StatusCode != tt.expectedStatusCode {
+			t.Errorf("Expected StatusCode %d for %s, but got %d", tt.expectedStatusCode, tt.path, response.StatusCode)
+		}
+		if allow := response.Headers.Get("Allow"); allow != tt.expectedAllow {
+			t.Errorf("Expected Allow %q for %s, but got %q", tt.expectedAllow, tt.path, allow)
+		}
+	}
+}
+
+func TestRouter_InvalidPatterns(t *testing.T) {
+	patterns := []string{"users", "/users/{id", "/users/{}", "/files/{path...}/meta", "/a/{x}/{x}", "/a{b}"}
+	for _, pattern := range patterns {
+		t.Run(pattern, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected %q to be rejected", pattern)
+				}
+			}()
+			NewRouter().Get(pattern, echoParams(pattern))
+		})
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a second GET handler for the same pattern to be rejected")
+		}
+	}()
+	router := NewRouter()
+	router.Get("/twice", echoParams("first"))
+	router.Get("/twice", echoParams("second"))
+}
+
+func TestGenerateHttpResponse_Options(t *testing.T) {
+	response := generateHttpResponse(HttpRequest{Method: OPTIONS, Path: "/echo/hi", Headers: Header{}})
+	if response.StatusCode != 204 {
+		t.Errorf("Expected StatusCode 204, but got %d", response.StatusCode)
+	}
+	if allow := response.Headers.Get("Allow"); allow != "GET, HEAD, POST, OPTIONS" {
+		t.Errorf("Expected Allow %q, but got %q", "GET, HEAD, POST, OPTIONS", allow)
+	}
+}
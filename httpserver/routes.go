@@ -0,0 +1,129 @@
+package httpserver
+
+import (
+	"fmt"
+)
+
+// Config configures the routes NewHandler serves. The zero value serves the
+// working directory without any upload limits; DefaultConfig is a better
+// starting point.
+type Config struct {
+	// Dir is the directory served under /files/ and the one uploads are
+	// stored in.
+	Dir string
+
+	// Symlinks decides which symlinks under Dir are followed.
+	Symlinks SymlinkPolicy
+
+	// ETags selects weak (size and mtime) or strong (content hash) entity
+	// tags for served files.
+	ETags ETagMode
+
+	// MimeTypes maps file extensions to media types. Nil means the built-in
+	// table; LoadMimeTypes extends it from a mime.types file.
+	MimeTypes map[string]string
+
+	// NoSniff adds "X-Content-Type-Options: nosniff" to file responses.
+	NoSniff bool
+
+	// CreateDirs creates missing parent directories for uploads.
+	CreateDirs bool
+
+	// MaxPartSize and MaxFormSize limit multipart/form-data uploads: the
+	// size of each file and of the whole body. Zero means unlimited.
+	MaxPartSize int64
+	MaxFormSize int64
+
+	// MaxUploadSize limits resumable uploads. Zero means unlimited.
+	MaxUploadSize int64
+
+	// MaxDecodedSize limits the size of a compressed upload once decoded.
+	// Zero means unlimited.
+	MaxDecodedSize int64
+
+	// StoreEncoded stores compressed uploads as they were sent instead of
+	// decoding them.
+	StoreEncoded bool
+
+	// CompressionLevels sets the level each content coding compresses
+	// responses at. Codings it leaves out use their default level.
+	CompressionLevels CompressionLevels
+}
+
+// DefaultConfig returns the configuration the command line starts from,
+// serving dir.
+func DefaultConfig(dir string) Config {
+	return Config{
+		Dir:               dir,
+		Symlinks:          SymlinksWithinRoot,
+		ETags:             ETagsWeak,
+		CreateDirs:        true,
+		MaxPartSize:       1 << 30,
+		MaxFormSize:       4 << 30,
+		MaxDecodedSize:    1 << 30,
+		CompressionLevels: DefaultCompressionLevels(),
+	}
+}
+
+// NewHandler returns the handler serving the routes of config, with
+// responses compressed as the client accepts.
+func NewHandler(config Config) Handler {
+	return Chain(NewRoutes(config), Compress(config.CompressionLevels))
+}
+
+// fileMethods are the methods handleFiles serves; the router adds HEAD and
+// OPTIONS.
+var fileMethods = []HttpMethod{GET, POST, PUT, PATCH, DELETE}
+
+// NewRoutes registers the server's endpoints, configured by config, on a
+// new Router.
+func NewRoutes(config Config) *Router {
+	c := &config
+	router := NewRouter()
+	router.Get("/", handleRoot)
+	router.Get("/echo/{message...}", handleEcho)
+	// The echo endpoint has always ignored the body of a POST
+	router.Post("/echo/{message...}", handleEcho)
+	router.Get("/user-agent", handleUserAgent)
+	for _, method := range fileMethods {
+		router.Handle(method, "/files/{path...}", c.handleFiles)
+	}
+	// tus answers OPTIONS itself and sorts out methods by upload
+	for _, pattern := range []string{"/uploads", "/uploads/{id...}"} {
+		for _, method := range []HttpMethod{OPTIONS, HEAD, POST, PATCH, DELETE} {
+			router.Handle(method, pattern, c.handleUploads)
+		}
+	}
+	return router
+}
+
+func handleRoot(request HttpRequest) HttpResponse {
+	return HttpResponse{
+		StatusCode: 200,
+		Status:     "OK",
+		Headers:    Header{"Content-Type": {"text/plain"}},
+		Body:       []byte(""),
+	}
+}
+
+func handleEcho(request HttpRequest) HttpResponse {
+	msg := request.PathValue("message")
+	return HttpResponse{
+		StatusCode: 200,
+		Status:     "OK",
+		Headers: Header{"Content-Type": {"text/plain"},
+			"Content-Length": {fmt.Sprintf("%d", len(msg))}},
+		Body: []byte(msg),
+	}
+}
+
+func handleUserAgent(request HttpRequest) HttpResponse {
+	userAgent := request.Headers.Get("User-Agent")
+	return HttpResponse{
+		StatusCode: 200,
+		Status:     "OK",
+		Headers: Header{"Content-Type": {"text/plain"},
+			"Content-Length": {fmt.Sprintf("%d", len(userAgent))}},
+		Body: []byte(userAgent),
+	}
+}
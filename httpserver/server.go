@@ -0,0 +1,387 @@
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultAddr is the address ListenAndServe listens on when Server.Addr is
+// empty.
+const DefaultAddr = ":4221"
+
+// ErrServerClosed is returned by Serve and ListenAndServe once Shutdown has
+// been called.
+var ErrServerClosed = errors.New("httpserver: server closed")
+
+// Server serves HTTP/1.0 and HTTP/1.1 requests to Handler on the
+// connections its listeners accept.
+type Server struct {
+	// Addr is the TCP address ListenAndServe listens on, DefaultAddr if
+	// empty.
+	Addr string
+
+	// Handler answers every request.
+	Handler Handler
+
+	// IdleTimeout bounds how long a connection may sit between requests
+	// before it is closed. Zero disables the timeout.
+	IdleTimeout time.Duration
+
+	// WriteTimeout bounds the time from reading a request's headers to
+	// sending the last byte of its response. Zero disables the timeout.
+	WriteTimeout time.Duration
+
+	// MaxRequestsPerConn caps the number of requests served on a single
+	// connection. Zero means unlimited.
+	MaxRequestsPerConn int
+
+	// TLSConfig, when set, makes Serve speak TLS on its listeners. It must
+	// hold a certificate or a way to get one.
+	TLSConfig *tls.Config
+
+	// Logger receives connection errors. Nil means the standard logger of
+	// the log package.
+	Logger *log.Logger
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	// conns maps each open connection to whether it is serving a request
+	conns    map[net.Conn]bool
+	shutdown bool
+}
+
+// ListenAndServe listens on Addr and serves the connections it accepts.
+// It always returns a non-nil error, ErrServerClosed after Shutdown.
+func (s *Server) ListenAndServe() error {
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+	addr := s.Addr
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves each one on its own goroutine.
+// ln is closed when Serve returns. It always returns a non-nil error,
+// ErrServerClosed after Shutdown.
+func (s *Server) Serve(ln net.Listener) error {
+	if s.TLSConfig != nil {
+		ln = tls.NewListener(ln, s.TLSConfig)
+	}
+	defer ln.Close()
+	if !s.trackListener(ln, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(ln, false)
+
+	var backoff time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			// Running out of file descriptors and the like pass, so back
+			// off rather than spin
+			if backoff == 0 {
+				backoff = 5 * time.Millisecond
+			} else if backoff *= 2; backoff > time.Second {
+				backoff = time.Second
+			}
+			s.logf("Error accepting a connection: %v; retrying in %v", err, backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		if !s.trackConn(conn, false) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// ServeAll serves every listener in listeners, each like Serve, and returns
+// once all of them have stopped. If one fails the others are closed and its
+// error is returned; otherwise the result is ErrServerClosed after Shutdown.
+func (s *Server) ServeAll(listeners []net.Listener) error {
+	if len(listeners) == 0 {
+		return errors.New("httpserver: no listeners to serve")
+	}
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			errs <- s.Serve(ln)
+		}(ln)
+	}
+	var err error
+	for range listeners {
+		serveErr := <-errs
+		if err != nil {
+			// The others stop because of the first, so their errors are of
+			// no interest
+			continue
+		}
+		err = serveErr
+		if err != ErrServerClosed {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+		}
+	}
+	return err
+}
+
+// Shutdown stops the server gracefully: the listeners are closed, idle
+// connections closed and Shutdown waits for the requests in flight to be
+// answered. Connections are closed once their current response is sent.
+// If ctx ends first its error is returned and the remaining connections are
+// left to finish, or to Close.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	err := s.closeListeners()
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !s.closeIdleConns() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return err
+}
+
+// Close stops the server at once, closing its listeners and every
+// connection, including those in the middle of a request. It is the last
+// resort once Shutdown has run out of time.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = true
+	err := s.closeListeners()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+	return err
+}
+
+// closeListeners closes and forgets the listeners. s.mu must be held.
+func (s *Server) closeListeners() error {
+	var err error
+	for ln := range s.listeners {
+		if closeErr := ln.Close(); closeErr != nil && err == nil && !errors.Is(closeErr, net.ErrClosed) {
+			err = closeErr
+		}
+		delete(s.listeners, ln)
+	}
+	return err
+}
+
+// shutdownPollInterval is how often Shutdown checks whether the requests
+// in flight are done.
+const shutdownPollInterval = 10 * time.Millisecond
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown
+}
+
+// trackListener adds or removes ln from the listeners Shutdown closes. It
+// reports false if ln can't be added because the server is shutting down.
+func (s *Server) trackListener(ln net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.listeners, ln)
+		return true
+	}
+	if s.shutdown {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[ln] = struct{}{}
+	return true
+}
+
+// trackConn records whether conn is serving a request. It reports false if
+// the server is shutting down and conn should be closed rather than wait
+// for another request.
+func (s *Server) trackConn(conn net.Conn, active bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+	s.conns[conn] = active
+	return active || !s.shutdown
+}
+
+func (s *Server) forgetConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// closeIdleConns closes the connections waiting for a request and reports
+// whether none are left.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, active := range s.conns {
+		if !active {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// serveConn serves the requests of conn until either side closes it.
+func (s *Server) serveConn(conn net.Conn) {
+	// Close the connection when the function returns
+	defer func(conn net.Conn) {
+		s.forgetConn(conn)
+		err := conn.Close()
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			s.logf("Error closing connection: %v", err)
+		}
+	}(conn)
+
+	// Buffer reads so requests can be parsed line by line and successive
+	// requests on the same connection are not lost
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	// Serve requests until the client or the server decides to close
+	for served := 1; ; served++ {
+		if s.IdleTimeout > 0 {
+			if err := conn.SetReadDeadline(time.Now().Add(s.IdleTimeout)); err != nil {
+				s.logf("Error setting read deadline: %v", err)
+				return
+			}
+		}
+
+		// The connection only counts as idle, and may be closed by Shutdown,
+		// until the first byte of a request arrives
+		if _, err := reader.Peek(1); err != nil {
+			if !isConnectionDone(err) {
+				s.logf("Error reading from the connection: %v", err)
+			}
+			return
+		}
+		s.trackConn(conn, true)
+
+		request, err := readHttpRequest(reader)
+		if err != nil {
+			if !isConnectionDone(err) {
+				s.logf("Error reading from the connection: %v", err)
+				var requestErr *RequestError
+				if errors.As(err, &requestErr) {
+					s.rejectRequest(writer, requestErr)
+				}
+			}
+			return
+		}
+		// The idle timeout only applies while waiting for a request; a large
+		// upload is allowed to take as long as it needs
+		if err := conn.SetReadDeadline(time.Time{}); err != nil {
+			s.logf("Error clearing read deadline: %v", err)
+			return
+		}
+		if s.WriteTimeout > 0 {
+			if err := conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout)); err != nil {
+				s.logf("Error setting write deadline: %v", err)
+				return
+			}
+		}
+
+		keepAlive := shouldKeepAlive(request) &&
+			(s.MaxRequestsPerConn <= 0 || served < s.MaxRequestsPerConn)
+
+		rw := newResponseWriter(writer, request, keepAlive)
+		rw.closing = s.shuttingDown
+		s.Handler.ServeHTTP(rw, request)
+		keepAlive, err = rw.finish()
+		if err != nil {
+			s.logf("Error while writing to the connection: %v", err)
+		}
+		if !keepAlive {
+			return
+		}
+		// A pipelined request that has been read already is in progress
+		if reader.Buffered() == 0 && !s.trackConn(conn, false) {
+			return
+		}
+	}
+}
+
+// rejectRequest answers a request that could not be parsed. The connection
+// is closed afterwards since the rest of the stream can't be trusted.
+func (s *Server) rejectRequest(w *bufio.Writer, requestErr *RequestError) {
+	response := HttpResponse{
+		StatusCode: requestErr.StatusCode,
+		Status:     StatusText(requestErr.StatusCode),
+		Headers:    Header{"Content-Type": {"text/plain"}},
+		Body:       []byte(StatusText(requestErr.StatusCode)),
+	}
+	rw := newResponseWriter(w, HttpRequest{}, false)
+	if err := writeHttpResponse(rw, response); err != nil {
+		s.logf("Error while writing the response: %v", err)
+	}
+	if _, err := rw.finish(); err != nil {
+		s.logf("Error while writing to the connection: %v", err)
+	}
+}
+
+// shouldKeepAlive reports whether the client asked for the connection to be
+// reused after request. HTTP/1.1 connections are persistent unless the client
+// sends "Connection: close"; HTTP/1.0 connections are closed unless the client
+// sends "Connection: keep-alive".
+func shouldKeepAlive(request HttpRequest) bool {
+	if request.Version == HTTP10 {
+		return request.Headers.hasToken("Connection", "keep-alive")
+	}
+	return !request.Headers.hasToken("Connection", "close")
+}
+
+// isConnectionDone reports whether err simply means the peer went away or
+// the idle timeout fired, neither of which is worth logging.
+func isConnectionDone(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,998 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParse_HttpRequest_Empty(t *testing.T) {
+	request := "GET / HTTP/1.1\r\nHost: localhost:4221\r\nUser-Agent: curl/8.7.1\r\nAccept: */*\r\n\r\n"
+	expectedMethod := HttpMethod("GET")
+	expectedPath := "/"
+	expectedBody := ""
+
+	req, err := parseHttpRequest(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != expectedMethod {
+		t.Errorf("Expected Method %s, but got %s", expectedMethod, req.Method)
+	}
+	if req.Path != expectedPath {
+		t.Errorf("Expected Path %s, but got %s", expectedPath, req.Path)
+	}
+	if body := readRequestBody(t, req); body != expectedBody {
+		t.Errorf("Expected Body %s, but got %s", expectedBody, body)
+	}
+
+}
+
+func TestParseHttpRequest(t *testing.T) {
+	tests := []struct {
+		name            string
+		requestString   string
+		expectedMethod  HttpMethod
+		expectedPath    string
+		expectedHeaders map[string]string
+		expectedBody    string
+	}{
+		{
+			name:           "RootPath",
+			requestString:  "GET / HTTP/1.1\r\nHost: localhost:4221\r\nUser-Agent: curl/8.7.1\r\nAccept: */*\r\n\r\n",
+			expectedMethod: GET,
+			expectedPath:   "/",
+			expectedHeaders: map[string]string{
+				"Host":       "localhost:4221",
+				"User-Agent": "curl/8.7.1",
+				"Accept":     "*/*",
+			},
+			expectedBody: "",
+		},
+		{
+			name:           "WithBody",
+			requestString:  "POST /submit HTTP/1.1\r\nHost: localhost:4221\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 12\r\n\r\nname=JohnDoe",
+			expectedMethod: POST,
+			expectedPath:   "/submit",
+			expectedHeaders: map[string]string{
+				"Host":           "localhost:4221",
+				"Content-Type":   "application/x-www-form-urlencoded",
+				"Content-Length": "12",
+			},
+			expectedBody: "name=JohnDoe",
+		},
+		{
+			name:           "PostWithBody",
+			requestString:  "POST /files/file_123 HTTP/1.1\r\nHost: localhost:4221\r\nUser-Agent: curl/8.7.1\r\nAccept: */*\r\nContent-Type: application/octet-stream\r\nContent-Length: 5\r\n\r\n12345",
+			expectedMethod: POST,
+			expectedPath:   "/files/file_123",
+			expectedHeaders: map[string]string{
+				"Host":           "localhost:4221",
+				"User-Agent":     "curl/8.7.1",
+				"Accept":         "*/*",
+				"Content-Type":   "application/octet-stream",
+				"Content-Length": "5",
+			},
+			expectedBody: "12345",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := parseHttpRequest(tt.requestString)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Method != tt.expectedMethod {
+				t.Errorf("Expected Method %s, but got %s", tt.expectedMethod, req.Method)
+			}
+			if req.Path != tt.expectedPath {
+				t.Errorf("Expected Path %s, but got %s", tt.expectedPath, req.Path)
+			}
+			for key, expectedValue := range tt.expectedHeaders {
+				if req.Headers.Get(key) != expectedValue {
+					t.Errorf("Expected Header %s to be %s, but got %s", key, expectedValue, req.Headers.Get(key))
+				}
+			}
+			if body := readRequestBody(t, req); body != tt.expectedBody {
+				t.Errorf("Expected Body %s, but got %s", tt.expectedBody, body)
+			}
+		})
+	}
+}
+
+func TestGenerateHttpResponse_RootPath(t *testing.T) {
+	request := HttpRequest{
+		Method:  GET,
+		Path:    "/",
+		Headers: Header{},
+		Body:    strings.NewReader(""),
+	}
+
+	expectedStatusCode := 200
+	expectedStatus := "OK"
+	expectedBody := ""
+
+	response := generateHttpResponse(request)
+
+	if response.StatusCode != expectedStatusCode {
+		t.Errorf("Expected StatusCode %d, but got %d", expectedStatusCode, response.StatusCode)
+	}
+	if response.Status != expectedStatus {
+		t.Errorf("Expected Status %s, but got %s", expectedStatus, response.Status)
+	}
+	if response.GetBodyAsString() != expectedBody {
+		t.Errorf("Expected Body %s, but got %s", expectedBody, response.GetBodyAsString())
+	}
+}
+
+func TestGenerateHttpResponse_FileNotFound(t *testing.T) {
+	request := HttpRequest{
+		Method:  GET,
+		Path:    "/files/nonexistent.txt",
+		Headers: Header{},
+		Body:    strings.NewReader(""),
+	}
+
+	expectedStatusCode := 404
+	expectedStatus := "Not Found"
+	expectedBody := "File not found"
+
+	response := generateHttpResponse(request)
+
+	if response.StatusCode != expectedStatusCode {
+		t.Errorf("Expected StatusCode %d, but got %d", expectedStatusCode, response.StatusCode)
+	}
+	if response.Status != expectedStatus {
+		t.Errorf("Expected Status %s, but got %s", expectedStatus, response.Status)
+	}
+	if response.GetBodyAsString() != expectedBody {
+		t.Errorf("Expected Body %s, but got %s", expectedBody, response.GetBodyAsString())
+	}
+}
+
+func TestGenerateHttpResponse_FileFound(t *testing.T) {
+	// Create a temporary file to test
+	tmpFile, err := os.CreateTemp("", "testfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	content := "Hello, World!"
+	if _, err := tmpFile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	tmpFile.Close()
+
+	request := HttpRequest{
+		Method:  GET,
+		Path:    "/files/" + filepath.Base(tmpFile.Name()),
+		Headers: Header{},
+		Body:    strings.NewReader(""),
+	}
+
+	expectedStatusCode := 200
+	expectedStatus := "OK"
+	expectedBody := content
+
+	// Set the directory to the temp file's directory
+	testConfig.Dir = filepath.Dir(tmpFile.Name())
+
+	response := generateHttpResponse(request)
+
+	if response.StatusCode != expectedStatusCode {
+		t.Errorf("Expected StatusCode %d, but got %d", expectedStatusCode, response.StatusCode)
+	}
+	if response.Status != expectedStatus {
+		t.Errorf("Expected Status %s, but got %s", expectedStatus, response.Status)
+	}
+	if body := readResponseBody(t, response); body != expectedBody {
+		t.Errorf("Expected Body %s, but got %s", expectedBody, body)
+	}
+	// The temp file has no extension, so its type is sniffed from the content
+	if response.Headers.Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Expected Content-Type to be text/plain; charset=utf-8, but got %s",
+			response.Headers.Get("Content-Type"))
+	}
+	if response.Headers.Get("Content-Length") != strconv.Itoa(len(content)) {
+		t.Errorf("Expected Content-Length to be %d, but got %s",
+			len(content), response.Headers.Get("Content-Length"))
+	}
+}
+
+func TestGenerateHttpResponse_FileCreate(t *testing.T) {
+	u := uuid.New()
+	param := u.String()
+	testConfig.Dir = os.TempDir()
+
+	request := HttpRequest{
+		Method:  POST,
+		Path:    "/files/" + param,
+		Headers: Header{},
+		Body:    strings.NewReader("12345"),
+	}
+
+	expectedStatusCode := 201
+	expectedStatus := "Created"
+	expectedFileContent := "12345"
+
+	response := generateHttpResponse(request)
+
+	if response.StatusCode != expectedStatusCode {
+		t.Errorf("Expected StatusCode %d, but got %d", expectedStatusCode, response.StatusCode)
+	}
+	if response.Status != expectedStatus {
+		t.Errorf("Expected Status %s, but got %s", expectedStatus, response.Status)
+	}
+	// Check if the file was created
+	filePath := filepath.Join(testConfig.Dir, param)
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		t.Errorf("Expected file %s to exist, but it does not", filePath)
+	}
+
+	// Check contents of the filePath equals params
+	// Read the file
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != expectedFileContent {
+		t.Errorf("Expected file content to be %s, but got %s", expectedFileContent, string(content))
+	}
+
+	// Clean up
+	os.Remove(filePath)
+
+}
+
+func TestGenerateHttpResponse_PathNotFound(t *testing.T) {
+	request := HttpRequest{
+		Method:  GET,
+		Path:    "/unknown",
+		Headers: Header{},
+		Body:    strings.NewReader(""),
+	}
+
+	expectedStatusCode := 404
+	expectedStatus := "Not Found"
+	expectedBody := "Path not found"
+
+	response := generateHttpResponse(request)
+
+	if response.StatusCode != expectedStatusCode {
+		t.Errorf("Expected StatusCode %d, but got %d", expectedStatusCode, response.StatusCode)
+	}
+	if response.Status != expectedStatus {
+		t.Errorf("Expected Status %s, but got %s", expectedStatus, response.Status)
+	}
+	if response.GetBodyAsString() != expectedBody {
+		t.Errorf("Expected Body %s, but got %s", expectedBody, response.GetBodyAsString())
+	}
+}
+
+const (
+	HelloString = "Hello"
+)
+
+func TestGenerateHttpResponse_EchoPath(t *testing.T) {
+	request := HttpRequest{
+		Method:  GET,
+		Path:    "/echo/Hello",
+		Headers: Header{},
+		Body:    strings.NewReader(""),
+	}
+
+	expectedStatusCode := 200
+	expectedStatus := "OK"
+	expectedBody := HelloString
+	expectedContentType := "text/plain"
+	expectedContentLength := strconv.Itoa(len(expectedBody))
+
+	response := generateHttpResponse(request)
+
+	if response.StatusCode != expectedStatusCode {
+		t.Errorf("Expected StatusCode %d, but got %d", expectedStatusCode, response.StatusCode)
+	}
+	if response.Status != expectedStatus {
+		t.Errorf("Expected Status %s, but got %s", expectedStatus, response.Status)
+	}
+	if response.GetBodyAsString() != expectedBody {
+		t.Errorf("Expected Body %s, but got %s", expectedBody, response.GetBodyAsString())
+	}
+	if response.Headers.Get("Content-Type") != expectedContentType {
+		t.Errorf("Expected Content-Type to be application/octet-stream, but got %s",
+			response.Headers.Get("Content-Type"))
+	}
+	if response.Headers.Get("Content-Length") != expectedContentLength {
+		t.Errorf("Expected Content-Length to be %s, but got %s",
+			expectedContentLength, response.Headers.Get("Content-Length"))
+	}
+}
+
+func TestGenerateHttpResponse_UserAgentHeader(t *testing.T) {
+	uaString := "foobar/1.2.3"
+
+	request := HttpRequest{
+		Method:  GET,
+		Path:    "/user-agent",
+		Headers: Header{"User-Agent": {"foobar/1.2.3"}},
+		Body:    strings.NewReader(""),
+	}
+
+	expectedStatusCode := 200
+	expectedStatus := "OK"
+	expectedBody := uaString
+
+	response := generateHttpResponse(request)
+
+	if response.StatusCode != expectedStatusCode {
+		t.Errorf("Expected StatusCode %d, but got %d", expectedStatusCode, response.StatusCode)
+	}
+	if response.Status != expectedStatus {
+		t.Errorf("Expected Status %s, but got %s", expectedStatus, response.Status)
+	}
+	if response.GetBodyAsString() != expectedBody {
+		t.Errorf("Expected Body %s, but got %s", expectedBody, response.GetBodyAsString())
+	}
+	if response.Headers.Get("Content-Length") != strconv.Itoa(len(uaString)) {
+		t.Errorf("Expected Content-Length to be %d, but got %s",
+			len(uaString), response.Headers.Get("Content-Length"))
+	}
+}
+
+func TestGenerateHttpResponse_GzipEncoding(t *testing.T) {
+	request := HttpRequest{
+		Method:  GET,
+		Path:    "/echo/Hello",
+		Headers: Header{"Accept-Encoding": {"gzip"}},
+		Body:    strings.NewReader(""),
+	}
+
+	expectedStatusCode := 200
+	expectedStatus := "OK"
+	expectedBody := HelloString
+
+	response := serveTestResponse(t, request)
+	if response.StatusCode != expectedStatusCode {
+		t.Errorf("Expected StatusCode %d, but got %d", expectedStatusCode, response.StatusCode)
+	}
+	if response.Status != expectedStatus {
+		t.Errorf("Expected Status %s, but got %s", expectedStatus, response.Status)
+	}
+	responseBody, _ := decodeGzipToString(response.Body)
+	if responseBody != expectedBody {
+		t.Errorf("Expected Body %s, but got %s", expectedBody, response.GetBodyAsString())
+	}
+	if response.Headers.Get("Content-Encoding") != string(GZIP) {
+		t.Errorf("Expected Content-Encoding to be gzip, but got %s",
+			response.Headers.Get("Content-Encoding"))
+	}
+}
+
+func TestGenerateHttpResponse_InvalidEncoding(t *testing.T) {
+	request := HttpRequest{
+		Method:  GET,
+		Path:    "/echo/Hello",
+		Headers: Header{"Accept-Encoding": {"invalid-encoding"}},
+		Body:    strings.NewReader(""),
+	}
+
+	expectedStatusCode := 200
+	expectedStatus := "OK"
+	expectedBody := HelloString
+
+	response := serveTestResponse(t, request)
+	if response.StatusCode != expectedStatusCode {
+		t.Errorf("Expected StatusCode %d, but got %d", expectedStatusCode, response.StatusCode)
+	}
+	if response.Status != expectedStatus {
+		t.Errorf("Expected Status %s, but got %s", expectedStatus, response.Status)
+	}
+	if response.GetBodyAsString() != expectedBody {
+		t.Errorf("Expected Body %s, but got %s", expectedBody, response.GetBodyAsString())
+	}
+	if response.Headers.Get("Content-Encoding") != "" {
+		t.Errorf("Expected No Content-Encoding but got %s",
+			response.Headers.Get("Content-Encoding"))
+	}
+}
+
+func TestGenerateHttpResponse_MultipleEncodings_gzip_invalid(t *testing.T) {
+	request := HttpRequest{
+		Method:  GET,
+		Path:    "/echo/Hello",
+		Headers: Header{"Accept-Encoding": {"invalid-encoding-1, gzip, invalid-encoding-2"}},
+		Body:    strings.NewReader(""),
+	}
+
+	expectedStatusCode := 200
+	expectedStatus := "OK"
+	expectedBody := HelloString
+
+	response := serveTestResponse(t, request)
+	if response.StatusCode != expectedStatusCode {
+		t.Errorf("Expected StatusCode %d, but got %d", expectedStatusCode, response.StatusCode)
+	}
+	if response.Status != expectedStatus {
+		t.Errorf("Expected Status %s, but got %s", expectedStatus, response.Status)
+	}
+	responseBody, _ := decodeGzipToString(response.Body)
+	if responseBody != expectedBody {
+		t.Errorf("Expected Body %s, but got %s", expectedBody, response.GetBodyAsString())
+	}
+	if response.Headers.Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected Content-Encoding to be gzip, but got %s",
+			response.Headers.Get("Content-Encoding"))
+	}
+}
+
+func TestGenerateHttpResponse_MultipleEncodings_only_invalid(t *testing.T) {
+	request := HttpRequest{
+		Method:  GET,
+		Path:    "/echo/Hello",
+		Headers: Header{"Accept-Encoding": {"invalid-encoding-1, invalid-encoding-2"}},
+		Body:    strings.NewReader(""),
+	}
+
+	expectedStatusCode := 200
+	expectedStatus := "OK"
+	expectedBody := HelloString
+
+	response := serveTestResponse(t, request)
+	if response.StatusCode != expectedStatusCode {
+		t.Errorf("Expected StatusCode %d, but got %d", expectedStatusCode, response.StatusCode)
+	}
+	if response.Status != expectedStatus {
+		t.Errorf("Expected Status %s, but got %s", expectedStatus, response.Status)
+	}
+	if response.GetBodyAsString() != expectedBody {
+		t.Errorf("Expected Body %s, but got %s", expectedBody, response.GetBodyAsString())
+	}
+	if response.Headers.Get("Content-Encoding") != "" {
+		t.Errorf("Expected No Content-Encoding but got %s",
+			response.Headers.Get("Content-Encoding"))
+	}
+}
+
+func TestGenerateHttpResponse_GzipEncoding_WithBody(t *testing.T) {
+	request := HttpRequest{
+		Method:  GET,
+		Path:    "/echo/Hello",
+		Headers: Header{"Accept-Encoding": {"gzip"}},
+		Body:    strings.NewReader(""),
+	}
+
+	expectedStatusCode := 200
+	expectedStatus := "OK"
+	expectedBody := HelloString
+
+	response := serveTestResponse(t, request)
+	if response.StatusCode != expectedStatusCode {
+		t.Errorf("Expected StatusCode %d, but got %d", expectedStatusCode, response.StatusCode)
+	}
+	if response.Status != expectedStatus {
+		t.Errorf("Expected Status %s, but got %s", expectedStatus, response.Status)
+	}
+	responseBody, _ := decodeGzipToString(response.Body)
+	if responseBody != expectedBody {
+		t.Errorf("Expected Body %s, but got %s", expectedBody, response.GetBodyAsString())
+	}
+	if response.Headers.Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected Content-Encoding to be gzip, but got %s",
+			response.Headers.Get("Content-Encoding"))
+	}
+}
+
+// readRequestBody reads the whole body of a parsed request.
+func readRequestBody(t *testing.T, req HttpRequest) string {
+	t.Helper()
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// readResponseBody returns the body of a generated response, draining and
+// closing its BodyReader if it is streamed.
+func readResponseBody(t *testing.T, response HttpResponse) string {
+	t.Helper()
+	if response.BodyReader == nil {
+		return response.GetBodyAsString()
+	}
+	if closer, ok := response.BodyReader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	body, err := io.ReadAll(response.BodyReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// Decode Gzip-compressed bytes back to a string.
+func decodeGzipToString(compressedBytes []byte) (string, error) {
+	// Create a gzip reader
+	gzipReader, err := gzip.NewReader(bytes.NewReader(compressedBytes))
+	if err != nil {
+		return "", err
+	}
+	defer gzipReader.Close()
+
+	// Read the decompressed content
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, gzipReader)
+	if err != nil {
+		return "", err
+	}
+
+	// Return the decompressed string
+	return buf.String(), nil
+}
+
+// testConfig is what the tests serve with. Tests point Dir at a fresh
+// directory and restore whatever else they change.
+var testConfig = DefaultConfig(os.TempDir())
+
+// generateHttpResponse answers request with the routes of testConfig.
+func generateHttpResponse(request HttpRequest) HttpResponse {
+	return NewRoutes(testConfig).Respond(request)
+}
+
+// testHandler serves every request with the routes of testConfig as it is
+// at the time of the request.
+var testHandler = HandlerFunc(func(w ResponseWriter, request HttpRequest) {
+	NewHandler(testConfig).ServeHTTP(w, request)
+})
+
+// startTestServer runs testHandler with the default connection settings on
+// an ephemeral port and returns its address.
+func startTestServer(t *testing.T) string {
+	t.Helper()
+	return serveTestServer(t, &Server{Handler: testHandler, IdleTimeout: 60 * time.Second, MaxRequestsPerConn: 100})
+}
+
+// serveTestServer runs s on an ephemeral port and returns its address. The
+// server is shut down when the test finishes.
+func serveTestServer(t *testing.T, s *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+
+	go s.Serve(ln)
+	return ln.Addr().String()
+}
+
+// roundTrip writes a raw request on conn and reads back one response.
+func roundTrip(t *testing.T, conn net.Conn, reader *bufio.Reader, rawRequest string) *http.Response {
+	t.Helper()
+	if _, err := conn.Write([]byte(rawRequest)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := readResponse(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// readResponse reads one response and buffers its body so the reader is
+// positioned at the start of the next response.
+func readResponse(reader *bufio.Reader) (*http.Response, error) {
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// expectClosed asserts that the server closes conn without sending more data.
+func expectClosed(t *testing.T, conn net.Conn, reader *bufio.Reader) {
+	t.Helper()
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected connection to be closed, but got %v", err)
+	}
+}
+
+func TestHandleConnection_KeepAlive(t *testing.T) {
+	conn, err := net.Dial("tcp", startTestServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	paths := []string{"/", "/echo/one", "/echo/two", "/user-agent"}
+	for _, path := range paths {
+		resp := roundTrip(t, conn, reader, "GET "+path+" HTTP/1.1\r\nHost: localhost\r\nUser-Agent: keepalive\r\n\r\n")
+		if resp.StatusCode != 200 {
+			t.Errorf("%s: Expected StatusCode 200, but got %d", path, resp.StatusCode)
+		}
+		if resp.Close {
+			t.Errorf("%s: Expected connection to stay open", path)
+		}
+	}
+
+	resp := roundTrip(t, conn, reader, "GET /echo/bye HTTP/1.1\r\nConnection: close\r\n\r\n")
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "bye" {
+		t.Errorf("Expected Body bye, but got %s", body)
+	}
+	if !resp.Close {
+		t.Errorf("Expected response to close the connection")
+	}
+	expectClosed(t, conn, reader)
+}
+
+func TestHandleConnection_HTTP10(t *testing.T) {
+	addr := startTestServer(t)
+
+	t.Run("DefaultClose", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+
+		resp := roundTrip(t, conn, reader, "GET / HTTP/1.0\r\n\r\n")
+		if !resp.Close {
+			t.Errorf("Expected HTTP/1.0 response to close the connection")
+		}
+		expectClosed(t, conn, reader)
+	})
+
+	t.Run("KeepAlive", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+
+		for i := 0; i < 3; i++ {
+			resp := roundTrip(t, conn, reader, "GET /echo/abc HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n")
+			if resp.Close {
+				t.Errorf("Expected keep-alive response to leave the connection open")
+			}
+		}
+	})
+}
+
+func TestHandleConnection_MaxRequests(t *testing.T) {
+	conn, err := net.Dial("tcp", serveTestServer(t, &Server{Handler: testHandler, MaxRequestsPerConn: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	if resp := roundTrip(t, conn, reader, "GET / HTTP/1.1\r\n\r\n"); resp.Close {
+		t.Errorf("Expected first response to keep the connection open")
+	}
+	if resp := roundTrip(t, conn, reader, "GET / HTTP/1.1\r\n\r\n"); !resp.Close {
+		t.Errorf("Expected last allowed response to close the connection")
+	}
+	expectClosed(t, conn, reader)
+}
+
+func TestHandleConnection_IdleTimeout(t *testing.T) {
+	conn, err := net.Dial("tcp", serveTestServer(t, &Server{Handler: testHandler, IdleTimeout: 50 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	roundTrip(t, conn, reader, "GET / HTTP/1.1\r\n\r\n")
+	expectClosed(t, conn, reader)
+}
+
+func TestServer_ShutdownDrains(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &Server{Handler: HandlerFunc(func(w ResponseWriter, request HttpRequest) {
+		if request.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		io.WriteString(w, "done")
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ln) }()
+
+	idle, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	busy, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	if _, err := busy.Write([]byte("GET /slow HTTP/1.1\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("Expected Serve to return ErrServerClosed, but got %v", err)
+	}
+	expectClosed(t, idle, bufio.NewReader(idle))
+	select {
+	case <-shutdown:
+		t.Fatalf("Expected Shutdown to wait for the request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	reader := bufio.NewReader(busy)
+	resp, err := readResponse(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "done" || !resp.Close {
+		t.Errorf("Expected the last response with Connection: close, but got %q, close %v", body, resp.Close)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Expected Shutdown to succeed, but got %v", err)
+	}
+	if err := server.ListenAndServe(); err != ErrServerClosed {
+		t.Errorf("Expected ListenAndServe after Shutdown to fail, but got %v", err)
+	}
+}
+
+func TestServer_ShutdownHalfSentRequest(t *testing.T) {
+	server := &Server{Handler: testHandler}
+	conn, err := net.Dial("tcp", serveTestServer(t, server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET /echo/late HTTP/1.1\r\nHost: x\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Expected Shutdown to wait for the request being sent, but got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := conn.Write([]byte("\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := readResponse(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("Expected the request to be answered, but got %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "late" || !resp.Close {
+		t.Errorf("Expected the response with Connection: close, but got %q, close %v", body, resp.Close)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Expected Shutdown to succeed, but got %v", err)
+	}
+}
+
+func TestServer_ShutdownPipelinedRequest(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &Server{Handler: HandlerFunc(func(w ResponseWriter, request HttpRequest) {
+		if request.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		io.WriteString(w, request.Path)
+	})}
+	conn, err := net.Dial("tcp", serveTestServer(t, server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET /slow HTTP/1.1\r\n\r\nGET /next HTTP/1.1\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// The first response keeps the connection open, so the second request
+	// is answered even if Shutdown starts in between
+	reader := bufio.NewReader(conn)
+	close(release)
+	if resp, err := readResponse(reader); err != nil || resp.Close {
+		t.Fatalf("Expected a keep-alive response, but got %v", err)
+	}
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	resp, err := readResponse(reader)
+	if err != nil {
+		t.Fatalf("Expected the pipelined request to be answered, but got %v", err)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "/next" {
+		t.Errorf("Expected body /next, but got %q", body)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Expected Shutdown to succeed, but got %v", err)
+	}
+}
+
+func TestServer_ServeAll(t *testing.T) {
+	server := &Server{Handler: testHandler}
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, ln)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.ServeAll(listeners) }()
+
+	for _, ln := range listeners {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := roundTrip(t, conn, bufio.NewReader(conn), "GET /echo/both HTTP/1.1\r\n\r\n")
+		conn.Close()
+		if body, _ := io.ReadAll(resp.Body); string(body) != "both" {
+			t.Errorf("Expected body both from %s, but got %q", ln.Addr(), body)
+		}
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("Expected ServeAll to return ErrServerClosed, but got %v", err)
+	}
+}
+
+func TestServer_ShutdownDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server := &Server{Handler: HandlerFunc(func(w ResponseWriter, request HttpRequest) {
+		<-release
+	})}
+	conn, err := net.Dial("tcp", serveTestServer(t, server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected Shutdown to give up at the deadline, but got %v", err)
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server := &Server{Handler: HandlerFunc(func(w ResponseWriter, request HttpRequest) {
+		<-release
+	})}
+	addr := serveTestServer(t, server)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectClosed(t, conn, bufio.NewReader(conn))
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Errorf("Expected the listener to be closed")
+	}
+}
+
+func TestServer_TLS(t *testing.T) {
+	certificate := testCertificate(t)
+	addr := serveTestServer(t, &Server{
+		Handler:   testHandler,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{certificate}},
+	})
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate.Leaf)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get("https://" + addr + "/echo/secure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "secure" {
+		t.Errorf("Expected Body secure, but got %q", body)
+	}
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1.
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
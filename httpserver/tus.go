@@ -0,0 +1,393 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The /uploads/ routes implement the core tus 1.0 resumable upload protocol
+// (https://tus.io/protocols/resumable-upload) with the creation and
+// termination extensions. Finished uploads land in directory like any other
+// upload.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+
+	// tusContentType is the only body type PATCH accepts.
+	tusContentType = "application/offset+octet-stream"
+
+	// uploadStateDir holds in-progress uploads under directory. Each has
+	// an <id>.json info file and an <id>.part file with the bytes received
+	// so far, so uploads survive a restart.
+	uploadStateDir = ".tus"
+)
+
+// tusUpload is the persisted state of one upload.
+type tusUpload struct {
+	ID     string `json:"id"`
+	Length int64  `json:"length"`
+	// Metadata is the Upload-Metadata header the upload was created with
+	Metadata string `json:"metadata,omitempty"`
+	// Target is the percent-encoded path under /files/ the finished upload
+	// is moved to
+	Target   string    `json:"target"`
+	Created  time.Time `json:"created"`
+	Complete bool      `json:"complete"`
+}
+
+// uploadStore keeps tus upload state in a directory.
+type uploadStore struct {
+	dir string
+}
+
+func newUploadStore(root fileRoot) uploadStore {
+	return uploadStore{dir: filepath.Join(root.dir, uploadStateDir)}
+}
+
+func (s uploadStore) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s uploadStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".part")
+}
+
+// load reads the state of upload id. It fails with os.ErrNotExist for
+// unknown or malformed ids.
+func (s uploadStore) load(id string) (tusUpload, error) {
+	var upload tusUpload
+	if _, err := uuid.Parse(id); err != nil {
+		return upload, os.ErrNotExist
+	}
+	content, err := os.ReadFile(s.infoPath(id))
+	if err != nil {
+		return upload, err
+	}
+	err = json.Unmarshal(content, &upload)
+	return upload, err
+}
+
+// save atomically writes the state of upload.
+func (s uploadStore) save(upload tusUpload) error {
+	content, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	return saveFile(s.infoPath(upload.ID), bytes.NewReader(content), true)
+}
+
+// offset returns how many bytes of upload have been received.
+func (s uploadStore) offset(upload tusUpload) (int64, error) {
+	if upload.Complete {
+		return upload.Length, nil
+	}
+	info, err := os.Stat(s.dataPath(upload.ID))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// isUploadStatePath reports whether filePath lies in the upload state
+// directory, which isn't served under /files/.
+func isUploadStatePath(root fileRoot, filePath string) bool {
+	rel, err := filepath.Rel(root.dir, filePath)
+	if err != nil {
+		return false
+	}
+	return rel == uploadStateDir || strings.HasPrefix(rel, uploadStateDir+string(filepath.Separator))
+}
+
+// handleUploads serves the /uploads/ routes.
+func (c *Config) handleUploads(request HttpRequest) HttpResponse {
+	urlPath, _, _ := strings.Cut(request.Path, "?")
+	id := strings.Trim(strings.TrimPrefix(urlPath, "/uploads"), "/")
+
+	var response HttpResponse
+	if request.Method == OPTIONS {
+		response = HttpResponse{StatusCode: 204, Status: "No Content", Headers: Header{}}
+		response.Headers.Set("Tus-Version", tusVersion)
+		response.Headers.Set("Tus-Extension", tusExtensions)
+		if c.MaxUploadSize > 0 {
+			response.Headers.Set("Tus-Max-Size", strconv.FormatInt(c.MaxUploadSize, 10))
+		}
+	} else if request.Headers.Get("Tus-Resumable") != tusVersion {
+		response = textResponse(412, "Unsupported tus version")
+		response.Headers.Set("Tus-Version", tusVersion)
+	} else {
+		root := newFileRoot(c.Dir, c.Symlinks)
+		store := newUploadStore(root)
+		switch {
+		case id == "" && request.Method == POST:
+			response = c.createUpload(root, store, request)
+		case id == "":
+			response = textResponse(405, "Method Not Allowed")
+			response.Headers.Set("Allow", "OPTIONS, POST")
+		case request.Method == HEAD:
+			response = uploadOffset(store, id)
+		case request.Method == PATCH:
+			response = c.patchUpload(root, store, id, request)
+		case request.Method == DELETE:
+			response = terminateUpload(store, id)
+		default:
+			response = textResponse(405, "Method Not Allowed")
+			response.Headers.Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+		}
+	}
+
+	if response.Headers == nil {
+		response.Headers = Header{}
+	}
+	response.Headers.Set("Tus-Resumable", tusVersion)
+	return response
+}
+
+// createUpload starts a new upload of Upload-Length bytes. The filename in
+// Upload-Metadata, if any, picks where it is stored once finished.
+func (c *Config) createUpload(root fileRoot, store uploadStore, request HttpRequest) HttpResponse {
+	length, err := strconv.ParseInt(request.Headers.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return textResponse(400, "Missing or invalid Upload-Length")
+	}
+	if c.MaxUploadSize > 0 && length > c.MaxUploadSize {
+		return textResponse(413, "Payload Too Large")
+	}
+	metadata, err := parseUploadMetadata(request.Headers.Get("Upload-Metadata"))
+	if err != nil {
+		return textResponse(400, "Invalid Upload-Metadata")
+	}
+
+	upload := tusUpload{
+		ID:       uuid.New().String(),
+		Length:   length,
+		Metadata: request.Headers.Get("Upload-Metadata"),
+		Created:  time.Now().UTC(),
+	}
+	upload.Target = upload.ID
+	if filename := metadata["filename"]; filename != "" {
+		upload.Target = escapePath(filename)
+	}
+	targetPath, err := root.resolve(upload.Target)
+	if err != nil {
+		return pathErrorResponse(err)
+	}
+	if isUploadStatePath(root, targetPath) {
+		return textResponse(403, "Forbidden")
+	}
+
+	if err := os.MkdirAll(store.dir, 0755); err != nil {
+		return textResponse(500, "Error creating upload")
+	}
+	if err := os.WriteFile(store.dataPath(upload.ID), nil, 0644); err != nil {
+		return textResponse(500, "Error creating upload")
+	}
+	if err := store.save(upload); err != nil {
+		os.Remove(store.dataPath(upload.ID))
+		return textResponse(500, "Error creating upload")
+	}
+	if length == 0 {
+		if err := c.completeUpload(root, store, &upload); err != nil {
+			return writeErrorResponse(err)
+		}
+	}
+
+	return HttpResponse{
+		StatusCode: 201,
+		Status:     "Created",
+		Headers:    Header{"Location": {"/uploads/" + upload.ID}},
+	}
+}
+
+// uploadOffset answers a HEAD for an upload with how much of it has been
+// received.
+func uploadOffset(store uploadStore, id string) HttpResponse {
+	unlock := lockPath(store.infoPath(id))
+	defer unlock()
+
+	upload, err := store.load(id)
+	if err != nil {
+		return uploadErrorResponse(err)
+	}
+	offset, err := store.offset(upload)
+	if err != nil {
+		return uploadErrorResponse(err)
+	}
+
+	headers := Header{
+		"Upload-Offset": {strconv.FormatInt(offset, 10)},
+		"Upload-Length": {strconv.FormatInt(upload.Length, 10)},
+		"Cache-Control": {"no-store"},
+	}
+	if upload.Metadata != "" {
+		headers.Set("Upload-Metadata", upload.Metadata)
+	}
+	if upload.Complete {
+		headers.Set("Content-Location", "/files/"+upload.Target)
+	}
+	return HttpResponse{StatusCode: 200, Status: "OK", Headers: headers}
+}
+
+// patchUpload appends the body to an upload at the offset the client
+// claims, which must be the current one. Whatever part of the body arrives
+// is kept, so an interrupted PATCH can be resumed from the new offset; a
+// body longer than what is left of the upload is refused as a whole.
+func (c *Config) patchUpload(root fileRoot, store uploadStore, id string, request HttpRequest) HttpResponse {
+	mediaType, _, _ := strings.Cut(request.Headers.Get("Content-Type"), ";")
+	if !strings.EqualFold(strings.TrimSpace(mediaType), tusContentType) {
+		return textResponse(415, "Content-Type must be "+tusContentType)
+	}
+	claimed, err := strconv.ParseInt(request.Headers.Get("Upload-Offset"), 10, 64)
+	if err != nil || claimed < 0 {
+		return textResponse(400, "Missing or invalid Upload-Offset")
+	}
+
+	unlock := lockPath(store.infoPath(id))
+	defer unlock()
+
+	upload, err := store.load(id)
+	if err != nil {
+		return uploadErrorResponse(err)
+	}
+	offset, err := store.offset(upload)
+	if err != nil {
+		return uploadErrorResponse(err)
+	}
+	if upload.Complete || claimed != offset {
+		response := textResponse(409, "Upload-Offset doesn't match")
+		response.Headers.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		return response
+	}
+
+	remaining := upload.Length - offset
+	if length, err := parseContentLength(request.Headers.Values("Content-Length")); err == nil && length > remaining {
+		return writeErrorResponse(errPayloadTooLarge)
+	}
+
+	file, err := os.OpenFile(store.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return uploadErrorResponse(err)
+	}
+	_, copyErr := io.Copy(file, &sizeLimitReader{r: request.Body, remaining: &remaining})
+	if errors.Is(copyErr, errPayloadTooLarge) {
+		// The chunk as a whole is wrong, so none of it is kept; keeping the
+		// part that fits would leave the upload looking complete to the
+		// client while it is never moved into place
+		file.Truncate(offset)
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if copyErr != nil {
+		return writeErrorResponse(copyErr)
+	}
+	if err != nil {
+		return uploadErrorResponse(err)
+	}
+
+	offset, err = store.offset(upload)
+	if err != nil {
+		return uploadErrorResponse(err)
+	}
+	if offset == upload.Length {
+		if err := c.completeUpload(root, store, &upload); err != nil {
+			return writeErrorResponse(err)
+		}
+	}
+	return HttpResponse{
+		StatusCode: 204,
+		Status:     "No Content",
+		Headers:    Header{"Upload-Offset": {strconv.FormatInt(offset, 10)}},
+	}
+}
+
+// completeUpload moves a fully received upload to its target.
+func (c *Config) completeUpload(root fileRoot, store uploadStore, upload *tusUpload) error {
+	targetPath, err := root.resolve(upload.Target)
+	if err != nil {
+		return err
+	}
+	if err := c.createParentDirs(targetPath); err != nil {
+		return err
+	}
+	if err := commitTempFile(store.dataPath(upload.ID), targetPath, true); err != nil {
+		return err
+	}
+	upload.Complete = true
+	return store.save(*upload)
+}
+
+// terminateUpload discards an upload. A finished upload's file is kept;
+// only its state goes.
+func terminateUpload(store uploadStore, id string) HttpResponse {
+	unlock := lockPath(store.infoPath(id))
+	defer unlock()
+
+	if _, err := store.load(id); err != nil {
+		return uploadErrorResponse(err)
+	}
+	if err := os.Remove(store.dataPath(id)); err != nil && !os.IsNotExist(err) {
+		return textResponse(500, "Error removing upload")
+	}
+	if err := os.Remove(store.infoPath(id)); err != nil {
+		return textResponse(500, "Error removing upload")
+	}
+	return HttpResponse{StatusCode: 204, Status: "No Content"}
+}
+
+// uploadErrorResponse answers a failure to access an upload's state.
+func uploadErrorResponse(err error) HttpResponse {
+	if os.IsNotExist(err) {
+		return textResponse(404, "Upload not found")
+	}
+	return textResponse(500, "Error reading upload")
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header: comma separated
+// pairs of a key and an optional base64 encoded value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" || strings.ContainsAny(key, " ,") {
+			return nil, fmt.Errorf("invalid metadata key %q", key)
+		}
+		if _, ok := metadata[key]; ok {
+			return nil, fmt.Errorf("duplicate metadata key %q", key)
+		}
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata value for %q: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// escapePath percent-encodes each segment of a slash separated path.
+func escapePath(p string) string {
+	segments := strings.Split(strings.TrimLeft(p, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,214 @@
+package httpserver
+
+import (
+	"bufio"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func tusRequest(method HttpMethod, path string, body string) HttpRequest {
+	return HttpRequest{
+		Method:  method,
+		Path:    path,
+		Version: HTTP11,
+		Headers: Header{"Tus-Resumable": {tusVersion}},
+		Body:    strings.NewReader(body),
+	}
+}
+
+func createTestUpload(t *testing.T, length string, filename string) string {
+	t.Helper()
+	request := tusRequest(POST, "/uploads/", "")
+	request.Headers.Set("Upload-Length", length)
+	if filename != "" {
+		request.Headers.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(filename)))
+	}
+	response := generateHttpResponse(request)
+	if response.StatusCode != 201 {
+		t.Fatalf("Expected StatusCode 201, but got %d: %s", response.StatusCode, response.Body)
+	}
+	location := response.Headers.Get("Location")
+	if !strings.HasPrefix(location, "/uploads/") {
+		t.Fatalf("Expected a Location under /uploads/, but got %q", location)
+	}
+	return location
+}
+
+func patchTestUpload(location string, offset string, body string) HttpResponse {
+	request := tusRequest(PATCH, location, body)
+	request.Headers.Set("Content-Type", tusContentType)
+	request.Headers.Set("Upload-Offset", offset)
+	return generateHttpResponse(request)
+}
+
+func TestHandleUploads_Options(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	response := generateHttpResponse(HttpRequest{Method: OPTIONS, Path: "/uploads/", Headers: Header{}})
+	if response.StatusCode != 204 {
+		t.Errorf("Expected StatusCode 204, but got %d", response.StatusCode)
+	}
+	if got := response.Headers.Get("Tus-Extension"); got != tusExtensions {
+		t.Errorf("Expected Tus-Extension %s, but got %s", tusExtensions, got)
+	}
+}
+
+func TestHandleUploads_RequiresVersion(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	response := generateHttpResponse(HttpRequest{Method: POST, Path: "/uploads/", Headers: Header{"Upload-Length": {"1"}}})
+	if response.StatusCode != 412 {
+		t.Errorf("Expected StatusCode 412, but got %d", response.StatusCode)
+	}
+}
+
+func TestHandleUploads_Resume(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	location := createTestUpload(t, "11", "builds/app.bin")
+
+	response := patchTestUpload(location, "0", "hello ")
+	if response.StatusCode != 204 || response.Headers.Get("Upload-Offset") != "6" {
+		t.Fatalf("Expected 204 with Upload-Offset 6, but got %d %q", response.StatusCode, response.Headers.Get("Upload-Offset"))
+	}
+
+	// A retried chunk at a stale offset is refused
+	if response := patchTestUpload(location, "0", "hello "); response.StatusCode != 409 {
+		t.Errorf("Expected StatusCode 409, but got %d", response.StatusCode)
+	}
+
+	// The offset comes from disk, so it is the same after a restart
+	response = generateHttpResponse(tusRequest(HEAD, location, ""))
+	if response.Headers.Get("Upload-Offset") != "6" || response.Headers.Get("Upload-Length") != "11" {
+		t.Errorf("Expected offset 6 of 11, but got %s of %s",
+			response.Headers.Get("Upload-Offset"), response.Headers.Get("Upload-Length"))
+	}
+
+	response = patchTestUpload(location, "6", "world")
+	if response.StatusCode != 204 || response.Headers.Get("Upload-Offset") != "11" {
+		t.Fatalf("Expected 204 with Upload-Offset 11, but got %d %q", response.StatusCode, response.Headers.Get("Upload-Offset"))
+	}
+	if content := readTestFile(t, "builds/app.bin"); content != "hello world" {
+		t.Errorf("Expected content hello world, but got %q", content)
+	}
+	if response := generateHttpResponse(tusRequest(GET, "/files/.tus/", "")); response.StatusCode != 404 {
+		t.Errorf("Expected the upload state to be hidden, but got %d", response.StatusCode)
+	}
+}
+
+func TestHandleUploads_InterruptedPatchKeepsData(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	location := createTestUpload(t, "10", "")
+
+	// The chunked body breaks off after the first chunk
+	request := tusRequest(PATCH, location, "")
+	request.Headers.Set("Content-Type", tusContentType)
+	request.Headers.Set("Upload-Offset", "0")
+	request.Body = newChunkedReader(bufio.NewReader(strings.NewReader("4\r\nabcd\r\n")), Header{})
+	if response := generateHttpResponse(request); response.StatusCode < 400 {
+		t.Errorf("Expected the PATCH to fail, but got %d", response.StatusCode)
+	}
+
+	response := generateHttpResponse(tusRequest(HEAD, location, ""))
+	if got := response.Headers.Get("Upload-Offset"); got != "4" {
+		t.Errorf("Expected Upload-Offset 4, but got %s", got)
+	}
+}
+
+func TestHandleUploads_TooLong(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	location := createTestUpload(t, "3", "")
+
+	if response := patchTestUpload(location, "0", "abcdef"); response.StatusCode != 413 {
+		t.Errorf("Expected StatusCode 413, but got %d", response.StatusCode)
+	}
+	response := generateHttpResponse(tusRequest(HEAD, location, ""))
+	if got := response.Headers.Get("Upload-Offset"); got != "0" {
+		t.Errorf("Expected the chunk to be dropped, but got Upload-Offset %s", got)
+	}
+}
+
+func TestHandleUploads_TooLongChunk(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	location := createTestUpload(t, "6", "abc.txt")
+	patchTestUpload(location, "0", "abc")
+
+	request := tusRequest(PATCH, location, "defg")
+	request.Headers.Set("Content-Type", tusContentType)
+	request.Headers.Set("Upload-Offset", "3")
+	request.Headers.Set("Content-Length", "4")
+	if response := generateHttpResponse(request); response.StatusCode != 413 {
+		t.Errorf("Expected StatusCode 413, but got %d", response.StatusCode)
+	}
+	response := generateHttpResponse(tusRequest(HEAD, location, ""))
+	if got := response.Headers.Get("Upload-Offset"); got != "3" {
+		t.Errorf("Expected Upload-Offset 3, but got %s", got)
+	}
+
+	// The client can still finish the upload with the right chunk
+	if response := patchTestUpload(location, "3", "def"); response.StatusCode != 204 {
+		t.Fatalf("Expected StatusCode 204, but got %d", response.StatusCode)
+	}
+	if content := readTestFile(t, "abc.txt"); content != "abcdef" {
+		t.Errorf("Expected content abcdef, but got %q", content)
+	}
+}
+
+func TestHandleUploads_Terminate(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	location := createTestUpload(t, "10", "")
+	patchTestUpload(location, "0", "abc")
+
+	if response := generateHttpResponse(tusRequest(DELETE, location, "")); response.StatusCode != 204 {
+		t.Errorf("Expected StatusCode 204, but got %d", response.StatusCode)
+	}
+	if response := generateHttpResponse(tusRequest(HEAD, location, "")); response.StatusCode != 404 {
+		t.Errorf("Expected StatusCode 404, but got %d", response.StatusCode)
+	}
+	entries, err := os.ReadDir(filepath.Join(testConfig.Dir, uploadStateDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected the upload state to be removed, but found %d entries", len(entries))
+	}
+}
+
+func TestHandleUploads_Errors(t *testing.T) {
+	testConfig.Dir = t.TempDir()
+	location := createTestUpload(t, "10", "")
+
+	request := tusRequest(PATCH, location, "abc")
+	request.Headers.Set("Upload-Offset", "0")
+	if response := generateHttpResponse(request); response.StatusCode != 415 {
+		t.Errorf("Expected StatusCode 415 without a Content-Type, but got %d", response.StatusCode)
+	}
+	if response := generateHttpResponse(tusRequest(HEAD, "/uploads/not-an-id", "")); response.StatusCode != 404 {
+		t.Errorf("Expected StatusCode 404 for an unknown upload, but got %d", response.StatusCode)
+	}
+
+	request = tusRequest(POST, "/uploads/", "")
+	request.Headers.Set("Upload-Length", "1")
+	request.Headers.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("../escape")))
+	if response := generateHttpResponse(request); response.StatusCode != 403 && response.StatusCode != 400 {
+		t.Errorf("Expected an escaping filename to be refused, but got %d", response.StatusCode)
+	}
+}
+
+func TestParseUploadMetadata(t *testing.T) {
+	metadata, err := parseUploadMetadata("filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["filename"] != "world_domination_plan.pdf" {
+		t.Errorf("Expected filename world_domination_plan.pdf, but got %q", metadata["filename"])
+	}
+	if _, ok := metadata["is_confidential"]; !ok {
+		t.Errorf("Expected a key without a value to be kept")
+	}
+	for _, header := range []string{"a !!!", "a YQ==,a YQ==", " ,"} {
+		if _, err := parseUploadMetadata(header); err == nil {
+			t.Errorf("Expected an error for %q", header)
+		}
+	}
+}
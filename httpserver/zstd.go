@@ -0,0 +1,667 @@
+package httpserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// A zstd encoder, RFC 8878. It finds matches with the shared LZ77 matcher,
+// Huffman codes literals when they fit the direct weight format, and codes
+// sequences with the predefined FSE tables or tables fitted to each block.
+
+const (
+	zstdMagic        = 0xFD2FB528
+	zstdMaxBlockSize = 1 << 17
+
+	zstdBlockRaw        = 0
+	zstdBlockRLE        = 1
+	zstdBlockCompressed = 2
+
+	zstdLiteralsRaw        = 0
+	zstdLiteralsRLE        = 1
+	zstdLiteralsCompressed = 2
+
+	// zstdMaxHuffmanBits is the longest literal code a decoder accepts
+	zstdMaxHuffmanBits = 11
+)
+
+// Predefined distributions of literal length, match length and offset codes,
+// RFC 8878 section 3.1.1.3.2.2.
+var (
+	zstdLiteralLengthNorm = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	zstdMatchLengthNorm = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	zstdOffsetNorm = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+
+	zstdLiteralLengthTable = newFSEEncoder(zstdLiteralLengthNorm, 6)
+	zstdMatchLengthTable   = newFSEEncoder(zstdMatchLengthNorm, 6)
+	zstdOffsetTable        = newFSEEncoder(zstdOffsetNorm, 5)
+)
+
+// Baselines and extra bits of the literal and match length codes, RFC 8878
+// section 3.1.1.3.2.1.1.
+var (
+	zstdLiteralLengthBase = []uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	zstdLiteralLengthBits = []uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	zstdMatchLengthBase = []uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	zstdMatchLengthBits = []uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// lengthCode returns the code whose baseline is the largest not above v.
+func lengthCode(base []uint32, v uint32) int {
+	code := len(base) - 1
+	for base[code] > v {
+		code--
+	}
+	return code
+}
+
+// fseEncoder is a finite state entropy coding table built from a normalized
+// distribution, as in the reference FSE_buildCTable.
+type fseEncoder struct {
+	tableLog   uint
+	stateTable []uint16
+	symbols    []fseSymbolTransform
+}
+
+type fseSymbolTransform struct {
+	deltaNbBits    uint32
+	deltaFindState int
+}
+
+func newFSEEncoder(norm []int16, tableLog uint) *fseEncoder {
+	tableSize := 1 << tableLog
+	mask := tableSize - 1
+	highThreshold := tableSize - 1
+
+	// Symbols with a "less than one" probability take the last cells, the
+	// others are spread over the rest
+	spread := make([]int, tableSize)
+	cumul := make([]int, len(norm)+1)
+	for s, n := range norm {
+		if n == -1 {
+			cumul[s+1] = cumul[s] + 1
+			spread[highThreshold] = s
+			highThreshold--
+		} else {
+			cumul[s+1] = cumul[s] + int(n)
+		}
+	}
+	step := tableSize>>1 + tableSize>>3 + 3
+	position := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			spread[position] = s
+			position = (position + step) & mask
+			for position > highThreshold {
+				position = (position + step) & mask
+			}
+		}
+	}
+
+	e := &fseEncoder{
+		tableLog:   tableLog,
+		stateTable: make([]uint16, tableSize),
+		symbols:    make([]fseSymbolTransform, len(norm)),
+	}
+	for u, s := range spread {
+		e.stateTable[cumul[s]] = uint16(tableSize + u)
+		cumul[s]++
+	}
+	total := 0
+	for s, n := range norm {
+		switch n {
+		case 0:
+			e.symbols[s].deltaNbBits = uint32(tableLog+1)<<16 - uint32(tableSize)
+		case -1, 1:
+			e.symbols[s] = fseSymbolTransform{
+				deltaNbBits:    uint32(tableLog)<<16 - uint32(tableSize),
+				deltaFindState: total - 1,
+			}
+			total++
+		default:
+			maxBitsOut := tableLog - (bitLength(uint64(n-1)) - 1)
+			minStatePlus := uint32(n) << maxBitsOut
+			e.symbols[s] = fseSymbolTransform{
+				deltaNbBits:    uint32(maxBitsOut)<<16 - minStatePlus,
+				deltaFindState: total - int(n),
+			}
+			total += int(n)
+		}
+	}
+	return e
+}
+
+// initState returns the state that encodes symbol, the first symbol coded
+// and so the last one decoded.
+func (e *fseEncoder) initState(symbol int) uint32 {
+	tt := e.symbols[symbol]
+	nbBitsOut := (tt.deltaNbBits + 1<<15) >> 16
+	value := nbBitsOut<<16 - tt.deltaNbBits
+	return uint32(e.stateTable[int(value>>nbBitsOut)+tt.deltaFindState])
+}
+
+// encode writes the bits leaving state and moves to the state for symbol.
+func (e *fseEncoder) encode(b *bitWriter, state uint32, symbol int) uint32 {
+	tt := e.symbols[symbol]
+	nbBitsOut := (state + tt.deltaNbBits) >> 16
+	b.writeBits(uint64(state), uint(nbBitsOut))
+	return uint32(e.stateTable[int(state>>nbBitsOut)+tt.deltaFindState])
+}
+
+// flush writes the final state for the decoder to start from.
+func (e *fseEncoder) flush(b *bitWriter, state uint32) {
+	b.writeBits(uint64(state), e.tableLog)
+}
+
+// zstdWriter compresses into a single zstd frame. Input is buffered and
+// compressed a block at a time.
+type zstdWriter struct {
+	w         io.Writer
+	matcher   *lzMatcher
+	blockSize int
+	pending   []byte
+	out       []byte
+	started   bool
+	closed    bool
+	err       error
+}
+
+// newZstdWriter returns a writer compressing at level, 1 (fastest) to 19
+// (smallest).
+func newZstdWriter(w io.Writer, level int) *zstdWriter {
+	params := lzEffort((level*11 + 18) / 19)
+	blockSize := params.window
+	if blockSize > zstdMaxBlockSize {
+		blockSize = zstdMaxBlockSize
+	}
+	return &zstdWriter{w: w, matcher: newLZMatcher(params), blockSize: blockSize}
+}
+
+func (z *zstdWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("zstd: write after close")
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	z.pending = append(z.pending, p...)
+	// A full block is held back so the last one has data to carry the
+	// end of the frame
+	written := 0
+	for len(z.pending)-written > z.blockSize && z.err == nil {
+		z.writeBlock(z.pending[written:written+z.blockSize], false)
+		written += z.blockSize
+	}
+	z.pending = append(z.pending[:0], z.pending[written:]...)
+	return len(p), z.err
+}
+
+// Close compresses what is buffered as the last block of the frame.
+func (z *zstdWriter) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err == nil {
+		z.writeBlock(z.pending, true)
+		z.pending = nil
+	}
+	return z.err
+}
+
+func (z *zstdWriter) writeBlock(block []byte, last bool) {
+	z.out = z.out[:0]
+	if !z.started {
+		z.started = true
+		z.out = binary.LittleEndian.AppendUint32(z.out, zstdMagic)
+		// No content size, checksum or dictionary, and a window of the
+		// matcher's size
+		windowLog := bitLength(uint64(z.matcher.params.window)) - 1
+		z.out = append(z.out, 0, byte(windowLog-10)<<3)
+	}
+
+	sequences := z.matcher.encodeBlock(block)
+	headerAt := len(z.out)
+	z.out = append(z.out, 0, 0, 0)
+	blockType, size := zstdBlockCompressed, 0
+	if len(block) > 0 {
+		z.out = appendZstdBlock(z.out, block, sequences)
+		size = len(z.out) - headerAt - 3
+	}
+	switch {
+	case len(block) > 0 && isRun(block):
+		z.out = append(z.out[:headerAt+3], block[0])
+		blockType, size = zstdBlockRLE, len(block)
+	case size == 0 || size >= len(block):
+		z.out = append(z.out[:headerAt+3], block...)
+		blockType, size = zstdBlockRaw, len(block)
+	}
+	header := uint32(size)<<3 | uint32(blockType)<<1
+	if last {
+		header |= 1
+	}
+	z.out[headerAt] = byte(header)
+	z.out[headerAt+1] = byte(header >> 8)
+	z.out[headerAt+2] = byte(header >> 16)
+
+	if _, err := z.w.Write(z.out); err != nil {
+		z.err = err
+	}
+}
+
+// isRun reports whether b repeats a single byte.
+func isRun(b []byte) bool {
+	for _, c := range b {
+		if c != b[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// appendZstdBlock appends the literals and sequences sections of a
+// compressed block.
+func appendZstdBlock(out []byte, block []byte, sequences []lzSequence) []byte {
+	var literals []byte
+	position := 0
+	for _, seq := range sequences {
+		literals = append(literals, block[position:position+seq.literalLength]...)
+		position += seq.literalLength + seq.matchLength
+	}
+	out = appendZstdLiterals(out, literals)
+
+	// The trailing literals follow the last sequence without one of their own
+	n := len(sequences) - 1
+	switch {
+	case n < 128:
+		out = append(out, byte(n))
+	case n < 0x7F00:
+		out = append(out, byte(n>>8+128), byte(n))
+	default:
+		out = append(out, 0xFF, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	if n == 0 {
+		return out
+	}
+	type codes struct {
+		ll, ml, of          int
+		llExtra, mlExtra    uint32
+		offsetValue, ofBits uint32
+	}
+	coded := make([]codes, n)
+	for i, seq := range sequences[:n] {
+		ll, ml := uint32(seq.literalLength), uint32(seq.matchLength)
+		// Offset values 1 to 3 are repeat offsets, so real offsets are
+		// shifted past them
+		offsetValue := uint32(seq.offset) + 3
+		c := codes{
+			ll:          lengthCode(zstdLiteralLengthBase, ll),
+			ml:          lengthCode(zstdMatchLengthBase, ml),
+			of:          int(bitLength(uint64(offsetValue))) - 1,
+			offsetValue: offsetValue,
+		}
+		c.llExtra = ll - zstdLiteralLengthBase[c.ll]
+		c.mlExtra = ml - zstdMatchLengthBase[c.ml]
+		c.ofBits = uint32(c.of)
+		coded[i] = c
+	}
+
+	llSymbols, mlSymbols, ofSymbols := make([]int, n), make([]int, n), make([]int, n)
+	for i, c := range coded {
+		llSymbols[i], mlSymbols[i], ofSymbols[i] = c.ll, c.ml, c.of
+	}
+	llMode, llDescription, llTable := chooseZstdTable(llSymbols, zstdLiteralLengthNorm, zstdLiteralLengthTable, 9)
+	ofMode, ofDescription, ofTable := chooseZstdTable(ofSymbols, zstdOffsetNorm, zstdOffsetTable, 8)
+	mlMode, mlDescription, mlTable := chooseZstdTable(mlSymbols, zstdMatchLengthNorm, zstdMatchLengthTable, 9)
+	out = append(out, llMode<<6|ofMode<<4|mlMode<<2)
+	out = append(out, llDescription...)
+	out = append(out, ofDescription...)
+	out = append(out, mlDescription...)
+
+	// The bitstream is read backwards, so the sequences are written last
+	// to first, each with its fields in the reverse of the decoding order
+	var b bitWriter
+	writeExtra := func(c codes) {
+		b.writeBits(uint64(c.llExtra), uint(zstdLiteralLengthBits[c.ll]))
+		b.writeBits(uint64(c.mlExtra), uint(zstdMatchLengthBits[c.ml]))
+		b.writeBits(uint64(c.offsetValue), uint(c.ofBits))
+	}
+	lastCodes := coded[n-1]
+	mlState := mlTable.initState(lastCodes.ml)
+	ofState := ofTable.initState(lastCodes.of)
+	llState := llTable.initState(lastCodes.ll)
+	writeExtra(lastCodes)
+	for i := n - 2; i >= 0; i-- {
+		c := coded[i]
+		ofState = ofTable.encode(&b, ofState, c.of)
+		mlState = mlTable.encode(&b, mlState, c.ml)
+		llState = llTable.encode(&b, llState, c.ll)
+		writeExtra(c)
+	}
+	mlTable.flush(&b, mlState)
+	ofTable.flush(&b, ofState)
+	llTable.flush(&b, llState)
+	b.writeBits(1, 1)
+	b.alignToByte()
+	return append(out, b.out...)
+}
+
+// Symbol compression modes of the sequences section.
+const (
+	zstdModePredefined = 0
+	zstdModeRLE        = 1
+	zstdModeCompressed = 2
+)
+
+// chooseZstdTable picks how to code one of the sequence fields: a single
+// repeated symbol as RLE, otherwise the predefined table or one fitted to
+// symbols, whichever is estimated to be smaller with its description.
+func chooseZstdTable(symbols []int, predefinedNorm []int16, predefined *fseEncoder, maxLog uint) (byte, []byte, *fseEncoder) {
+	count := make([]uint32, len(predefinedNorm))
+	maxSymbol, used := 0, 0
+	for _, s := range symbols {
+		if count[s] == 0 {
+			used++
+		}
+		count[s]++
+		if s > maxSymbol {
+			maxSymbol = s
+		}
+	}
+	if used == 1 {
+		norm := make([]int16, maxSymbol+1)
+		norm[maxSymbol] = 1
+		return zstdModeRLE, []byte{byte(maxSymbol)}, newFSEEncoder(norm, 0)
+	}
+
+	tableLog := bitLength(uint64(len(symbols)))
+	if minLog := bitLength(uint64(maxSymbol)) + 1; tableLog < minLog {
+		tableLog = minLog
+	}
+	if tableLog < 5 {
+		tableLog = 5
+	}
+	if tableLog > maxLog {
+		tableLog = maxLog
+	}
+	norm := normalizeCounts(count[:maxSymbol+1], len(symbols), tableLog)
+	var description bitWriter
+	writeFSEDescription(&description, norm, tableLog)
+	description.alignToByte()
+
+	predefinedCost := fseCost(count, predefinedNorm, predefined.tableLog)
+	if fseCost(count, norm, tableLog)+float64(8*len(description.out)) >= predefinedCost {
+		return zstdModePredefined, nil, predefined
+	}
+	return zstdModeCompressed, description.out, newFSEEncoder(norm, tableLog)
+}
+
+// fseCost estimates the bits needed to code count with a table.
+func fseCost(count []uint32, norm []int16, tableLog uint) float64 {
+	cost := 0.0
+	for s, c := range count {
+		if c == 0 {
+			continue
+		}
+		n := 1.0
+		if norm[s] > 1 {
+			n = float64(norm[s])
+		}
+		cost += float64(c) * (float64(tableLog) - math.Log2(n))
+	}
+	return cost
+}
+
+// normalizeCounts scales count to sum to 1<<tableLog, keeping every used
+// symbol at least 1.
+func normalizeCounts(count []uint32, total int, tableLog uint) []int16 {
+	tableSize := 1 << tableLog
+	norm := make([]int16, len(count))
+	sum, largest := 0, 0
+	for s, c := range count {
+		if c == 0 {
+			continue
+		}
+		n := int(uint64(c) * uint64(tableSize) / uint64(total))
+		if n < 1 {
+			n = 1
+		}
+		norm[s] = int16(n)
+		sum += n
+		if norm[s] > norm[largest] {
+			largest = s
+		}
+	}
+	norm[largest] += int16(tableSize - sum)
+	// Rounding rare symbols up may overshoot, which is taken back from the
+	// most probable symbols
+	for norm[largest] < 1 {
+		norm[largest]++
+		biggest := 0
+		for s := range norm {
+			if s != largest && norm[s] > norm[biggest] {
+				biggest = s
+			}
+		}
+		norm[biggest]--
+	}
+	return norm
+}
+
+// writeFSEDescription writes a normalized distribution as an FSE table
+// description, RFC 8878 section 4.1.1.
+func writeFSEDescription(b *bitWriter, norm []int16, tableLog uint) {
+	b.writeBits(uint64(tableLog-5), 4)
+	tableSize := 1 << tableLog
+	remaining := tableSize + 1
+	threshold := tableSize
+	nbBits := tableLog + 1
+	previousIs0 := false
+	for symbol := 0; symbol < len(norm) && remaining > 1; {
+		if previousIs0 {
+			// Zero probabilities are coded as repeat flags
+			start := symbol
+			for symbol < len(norm) && norm[symbol] == 0 {
+				symbol++
+			}
+			for symbol >= start+24 {
+				start += 24
+				b.writeBits(0xFFFF, 16)
+			}
+			for symbol >= start+3 {
+				start += 3
+				b.writeBits(3, 2)
+			}
+			b.writeBits(uint64(symbol-start), 2)
+		}
+		count := int(norm[symbol])
+		symbol++
+		max := 2*threshold - 1 - remaining
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		count++
+		if count >= threshold {
+			count += max
+		}
+		if count < max {
+			b.writeBits(uint64(count), nbBits-1)
+		} else {
+			b.writeBits(uint64(count), nbBits)
+		}
+		previousIs0 = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+}
+
+// appendZstdLiterals appends the literals section, Huffman coded when that
+// is possible and smaller.
+func appendZstdLiterals(out []byte, literals []byte) []byte {
+	if len(literals) > 0 && isRun(literals) {
+		return append(appendRawLiteralsHeader(out, zstdLiteralsRLE, len(literals)), literals[0])
+	}
+	if compressed, ok := huffmanZstdLiterals(literals); ok && len(compressed) < len(literals) {
+		return append(out, compressed...)
+	}
+	return append(appendRawLiteralsHeader(out, zstdLiteralsRaw, len(literals)), literals...)
+}
+
+func appendRawLiteralsHeader(out []byte, literalsType, size int) []byte {
+	switch {
+	case size < 32:
+		return append(out, byte(literalsType|size<<3))
+	case size < 4096:
+		v := literalsType | 1<<2 | size<<4
+		return append(out, byte(v), byte(v>>8))
+	}
+	v := literalsType | 3<<2 | size<<4
+	return append(out, byte(v), byte(v>>8), byte(v>>16))
+}
+
+// huffmanZstdLiterals Huffman codes literals, including the header. The
+// tree is described with direct weights, which only reach symbol 128, so
+// literals beyond that are left raw.
+func huffmanZstdLiterals(literals []byte) ([]byte, bool) {
+	if len(literals) < 32 {
+		return nil, false
+	}
+	freq := make([]uint32, 256)
+	maxSymbol := 0
+	for _, c := range literals {
+		freq[c]++
+		if int(c) > maxSymbol {
+			maxSymbol = int(c)
+		}
+	}
+	if maxSymbol > 128 {
+		return nil, false
+	}
+	lengths := huffmanLengths(freq[:maxSymbol+1], zstdMaxHuffmanBits)
+	maxBits := uint8(0)
+	for _, l := range lengths {
+		if l > maxBits {
+			maxBits = l
+		}
+	}
+
+	// Weights grow as codes get shorter. The last symbol's weight is
+	// implied by the others.
+	weights := make([]uint8, maxSymbol+1)
+	for s, l := range lengths {
+		if l > 0 {
+			weights[s] = maxBits + 1 - l
+		}
+	}
+	tree := []byte{byte(127 + maxSymbol)}
+	for i := 0; i < maxSymbol; i += 2 {
+		w := weights[i] << 4
+		if i+1 < maxSymbol {
+			w |= weights[i+1]
+		}
+		tree = append(tree, w)
+	}
+
+	// Codes are handed out by increasing weight, in symbol order within a
+	// weight, as the decoder fills its table
+	var rankStart [zstdMaxHuffmanBits + 2]int
+	for _, w := range weights {
+		if w > 0 {
+			rankStart[w+1] += 1 << (w - 1)
+		}
+	}
+	for w := 2; w < len(rankStart); w++ {
+		rankStart[w] += rankStart[w-1]
+	}
+	codes := make([]uint16, maxSymbol+1)
+	for s, w := range weights {
+		if w > 0 {
+			codes[s] = uint16(rankStart[w] >> (w - 1))
+			rankStart[w] += 1 << (w - 1)
+		}
+	}
+
+	encodeStream := func(segment []byte) []byte {
+		var b bitWriter
+		for i := len(segment) - 1; i >= 0; i-- {
+			s := segment[i]
+			b.writeBits(uint64(codes[s]), uint(lengths[s]))
+		}
+		b.writeBits(1, 1)
+		b.alignToByte()
+		return b.out
+	}
+
+	regenerated := len(literals)
+	payload := tree
+	var header []byte
+	if regenerated < 1024 {
+		payload = append(payload, encodeStream(literals)...)
+		if len(payload) >= 1024 {
+			return nil, false
+		}
+		v := uint32(zstdLiteralsCompressed) | uint32(regenerated)<<4 | uint32(len(payload))<<14
+		header = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	} else {
+		segmentSize := (regenerated + 3) / 4
+		var streams [4][]byte
+		for i := range streams {
+			start := i * segmentSize
+			end := start + segmentSize
+			if i == 3 {
+				end = regenerated
+			}
+			streams[i] = encodeStream(literals[start:end])
+		}
+		for _, stream := range streams[:3] {
+			payload = binary.LittleEndian.AppendUint16(payload, uint16(len(stream)))
+		}
+		for _, stream := range streams {
+			payload = append(payload, stream...)
+		}
+		if regenerated < 1<<14 && len(payload) < 1<<14 {
+			v := uint32(zstdLiteralsCompressed) | 2<<2 | uint32(regenerated)<<4 | uint32(len(payload))<<18
+			header = binary.LittleEndian.AppendUint32(nil, v)
+		} else {
+			v := uint64(zstdLiteralsCompressed) | 3<<2 | uint64(regenerated)<<4 | uint64(len(payload))<<22
+			header = append(binary.LittleEndian.AppendUint32(nil, uint32(v)), byte(v>>32))
+		}
+	}
+	return append(header, payload...), true
+}
@@ -0,0 +1,560 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Decode zstd-compressed bytes back to a string. Only what the encoder
+// produces is supported: literals raw, RLE or Huffman coded with direct
+// weights, and sequences with predefined, RLE or described tables.
+func decodeZstdToString(compressed []byte) (string, error) {
+	if len(compressed) < 6 || binary.LittleEndian.Uint32(compressed) != zstdMagic {
+		return "", errors.New("not a zstd frame")
+	}
+	descriptor := compressed[4]
+	if descriptor&0x03 != 0 {
+		return "", errors.New("dictionaries aren't supported")
+	}
+	singleSegment := descriptor&0x20 != 0
+	position := 5
+	if !singleSegment {
+		position++
+	}
+	contentSizeBytes := []int{0, 2, 4, 8}[descriptor>>6]
+	if singleSegment && descriptor>>6 == 0 {
+		contentSizeBytes = 1
+	}
+	position += contentSizeBytes
+
+	var out []byte
+	repeats := [3]int{1, 4, 8}
+	for {
+		if position+3 > len(compressed) {
+			return "", io.ErrUnexpectedEOF
+		}
+		header := int(compressed[position]) | int(compressed[position+1])<<8 | int(compressed[position+2])<<16
+		position += 3
+		last, blockType, size := header&1 == 1, header>>1&3, header>>3
+		switch blockType {
+		case zstdBlockRaw:
+			if position+size > len(compressed) {
+				return "", io.ErrUnexpectedEOF
+			}
+			out = append(out, compressed[position:position+size]...)
+			position += size
+		case zstdBlockRLE:
+			if position >= len(compressed) {
+				return "", io.ErrUnexpectedEOF
+			}
+			out = append(out, bytes.Repeat(compressed[position:position+1], size)...)
+			position++
+		case zstdBlockCompressed:
+			if position+size > len(compressed) {
+				return "", io.ErrUnexpectedEOF
+			}
+			var err error
+			if out, err = decodeZstdBlock(out, compressed[position:position+size], &repeats); err != nil {
+				return "", err
+			}
+			position += size
+		default:
+			return "", errors.New("reserved block type")
+		}
+		if last {
+			break
+		}
+	}
+	if descriptor&0x04 != 0 {
+		position += 4
+	}
+	if position != len(compressed) {
+		return "", errors.New("trailing data after the frame")
+	}
+	return string(out), nil
+}
+
+func decodeZstdBlock(out []byte, block []byte, repeats *[3]int) ([]byte, error) {
+	literals, rest, err := decodeZstdLiterals(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) == 0 {
+		return nil, errors.New("missing sequences section")
+	}
+	count := int(rest[0])
+	switch {
+	case count == 0:
+		return append(out, literals...), nil
+	case count < 128:
+		rest = rest[1:]
+	case count < 255:
+		count = (count-128)<<8 + int(rest[1])
+		rest = rest[2:]
+	default:
+		count = int(rest[1]) + int(rest[2])<<8 + 0x7F00
+		rest = rest[3:]
+	}
+	modes := rest[0]
+	rest = rest[1:]
+	tables := make([]*testFSETable, 3)
+	for i, field := range []struct {
+		mode       byte
+		predefined []int16
+		log        uint
+	}{
+		{modes >> 6, zstdLiteralLengthNorm, 6},
+		{modes >> 4 & 3, zstdOffsetNorm, 5},
+		{modes >> 2 & 3, zstdMatchLengthNorm, 6},
+	} {
+		switch field.mode {
+		case zstdModePredefined:
+			tables[i] = newTestFSETable(field.predefined, field.log)
+		case zstdModeRLE:
+			norm := make([]int16, int(rest[0])+1)
+			norm[rest[0]] = 1
+			tables[i] = newTestFSETable(norm, 0)
+			rest = rest[1:]
+		case zstdModeCompressed:
+			r := &testBitReader{data: rest}
+			norm, log, err := readTestFSEDescription(r)
+			if err != nil {
+				return nil, err
+			}
+			tables[i] = newTestFSETable(norm, log)
+			r.alignToByte()
+			rest = rest[r.pos/8:]
+		default:
+			return nil, errors.New("repeated tables aren't supported")
+		}
+	}
+	literalLengths, offsets, matchLengths := tables[0], tables[1], tables[2]
+
+	r, err := newTestBackwardReader(rest)
+	if err != nil {
+		return nil, err
+	}
+	llState, ofState, mlState := r.bits(literalLengths.log), r.bits(offsets.log), r.bits(matchLengths.log)
+	for i := 0; i < count; i++ {
+		llCode := literalLengths.cells[llState].symbol
+		ofCode := offsets.cells[ofState].symbol
+		mlCode := matchLengths.cells[mlState].symbol
+		offsetValue := 1<<uint(ofCode) + int(r.bits(uint(ofCode)))
+		matchLength := int(zstdMatchLengthBase[mlCode]) + int(r.bits(uint(zstdMatchLengthBits[mlCode])))
+		literalLength := int(zstdLiteralLengthBase[llCode]) + int(r.bits(uint(zstdLiteralLengthBits[llCode])))
+
+		var offset int
+		if offsetValue > 3 {
+			offset = offsetValue - 3
+			repeats[0], repeats[1], repeats[2] = offset, repeats[0], repeats[1]
+		} else {
+			index := offsetValue - 1
+			if literalLength == 0 {
+				index++
+			}
+			switch index {
+			case 0:
+				offset = repeats[0]
+			case 3:
+				offset = repeats[0] - 1
+			default:
+				offset = repeats[index]
+			}
+			if index == 1 {
+				repeats[0], repeats[1] = offset, repeats[0]
+			} else if index > 1 {
+				repeats[0], repeats[1], repeats[2] = offset, repeats[0], repeats[1]
+			}
+		}
+
+		if literalLength > len(literals) {
+			return nil, errors.New("sequence uses more literals than there are")
+		}
+		out = append(out, literals[:literalLength]...)
+		literals = literals[literalLength:]
+		if offset <= 0 || offset > len(out) {
+			return nil, fmt.Errorf("invalid offset %d", offset)
+		}
+		for j := 0; j < matchLength; j++ {
+			out = append(out, out[len(out)-offset])
+		}
+		if i < count-1 {
+			llState = literalLengths.next(llState, r)
+			mlState = matchLengths.next(mlState, r)
+			ofState = offsets.next(ofState, r)
+		}
+	}
+	if !r.finished() {
+		return nil, errors.New("sequences bitstream not fully consumed")
+	}
+	return append(out, literals...), nil
+}
+
+func decodeZstdLiterals(block []byte) ([]byte, []byte, error) {
+	if len(block) < 1 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	literalsType, sizeFormat := block[0]&3, block[0]>>2&3
+	if literalsType == zstdLiteralsRaw || literalsType == zstdLiteralsRLE {
+		var size, headerSize int
+		switch sizeFormat {
+		case 0, 2:
+			size, headerSize = int(block[0]>>3), 1
+		case 1:
+			size, headerSize = int(block[0]>>4)|int(block[1])<<4, 2
+		case 3:
+			size, headerSize = int(block[0]>>4)|int(block[1])<<4|int(block[2])<<12, 3
+		}
+		if literalsType == zstdLiteralsRLE {
+			return bytes.Repeat(block[headerSize:headerSize+1], size), block[headerSize+1:], nil
+		}
+		return block[headerSize : headerSize+size], block[headerSize+size:], nil
+	}
+	if literalsType != zstdLiteralsCompressed {
+		return nil, nil, errors.New("treeless literals aren't supported")
+	}
+
+	headerSize, sizeBits, streams := 3, uint(10), 4
+	switch sizeFormat {
+	case 0:
+		streams = 1
+	case 2:
+		headerSize, sizeBits = 4, 14
+	case 3:
+		headerSize, sizeBits = 5, 18
+	}
+	var v uint64
+	for i := headerSize - 1; i >= 0; i-- {
+		v = v<<8 | uint64(block[i])
+	}
+	mask := uint64(1)<<sizeBits - 1
+	regenerated := int(v >> 4 & mask)
+	compressedSize := int(v >> (4 + sizeBits) & mask)
+	payload := block[headerSize : headerSize+compressedSize]
+	rest := block[headerSize+compressedSize:]
+
+	// Direct weights, the last one implied
+	if payload[0] < 128 {
+		return nil, nil, errors.New("FSE compressed weights aren't supported")
+	}
+	weightCount := int(payload[0]) - 127
+	weights := make([]uint, weightCount+1)
+	total := 0
+	for i := 0; i < weightCount; i++ {
+		weights[i] = uint(payload[1+i/2] >> (4 * uint(1-i%2)) & 15)
+		if weights[i] > 0 {
+			total += 1 << (weights[i] - 1)
+		}
+	}
+	payload = payload[1+(weightCount+1)/2:]
+	maxBits := bitLength(uint64(total))
+	rest2 := 1<<maxBits - total
+	if rest2&(rest2-1) != 0 {
+		return nil, nil, errors.New("weights don't complete a code")
+	}
+	weights[weightCount] = bitLength(uint64(rest2))
+
+	// Table of symbols and code lengths by the maxBits bits ahead
+	type entry struct {
+		symbol byte
+		bits   uint
+	}
+	table := make([]entry, 1<<maxBits)
+	var rankStart [16]int
+	for w := uint(1); w <= maxBits; w++ {
+		rankStart[w+1] = rankStart[w]
+		for _, weight := range weights {
+			if weight == w {
+				rankStart[w+1] += 1 << (w - 1)
+			}
+		}
+	}
+	for symbol, w := range weights {
+		if w == 0 {
+			continue
+		}
+		for i := 0; i < 1<<(w-1); i++ {
+			table[rankStart[w]+i] = entry{symbol: byte(symbol), bits: maxBits + 1 - w}
+		}
+		rankStart[w] += 1 << (w - 1)
+	}
+
+	var segments [][]byte
+	if streams == 1 {
+		segments = [][]byte{payload}
+	} else {
+		jump := payload[:6]
+		payload = payload[6:]
+		for i := 0; i < 3; i++ {
+			size := int(binary.LittleEndian.Uint16(jump[2*i:]))
+			segments = append(segments, payload[:size])
+			payload = payload[size:]
+		}
+		segments = append(segments, payload)
+	}
+	segmentSize := (regenerated + 3) / 4
+	var literals []byte
+	for i, segment := range segments {
+		n := regenerated
+		if streams == 4 {
+			n = segmentSize
+			if i == 3 {
+				n = regenerated - 3*segmentSize
+			}
+		}
+		r, err := newTestBackwardReader(segment)
+		if err != nil {
+			return nil, nil, err
+		}
+		for j := 0; j < n; j++ {
+			e := table[r.peek(maxBits)]
+			r.consume(e.bits)
+			literals = append(literals, e.symbol)
+		}
+		if !r.finished() {
+			return nil, nil, errors.New("literals bitstream not fully consumed")
+		}
+	}
+	return literals, rest, nil
+}
+
+// testBackwardReader reads a zstd bitstream from its end, past the padding
+// up to the highest set bit.
+type testBackwardReader struct {
+	data []byte
+	pos  int // bits left to read
+}
+
+func newTestBackwardReader(data []byte) (*testBackwardReader, error) {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return nil, errors.New("bitstream without an end mark")
+	}
+	return &testBackwardReader{data: data, pos: 8*(len(data)-1) + int(bitLength(uint64(data[len(data)-1]))) - 1}, nil
+}
+
+// peek returns the next n bits, padded with zeros past the start.
+func (r *testBackwardReader) peek(n uint) uint32 {
+	var v uint32
+	for i := 0; i < int(n); i++ {
+		bit := uint32(0)
+		if p := r.pos - 1 - i; p >= 0 {
+			bit = uint32(r.data[p/8] >> (p % 8) & 1)
+		}
+		v = v<<1 | bit
+	}
+	return v
+}
+
+func (r *testBackwardReader) consume(n uint) { r.pos -= int(n) }
+
+func (r *testBackwardReader) bits(n uint) uint32 {
+	v := r.peek(n)
+	r.consume(n)
+	return v
+}
+
+func (r *testBackwardReader) finished() bool { return r.pos == 0 }
+
+// testFSETable decodes FSE states.
+type testFSETable struct {
+	log   uint
+	cells []testFSECell
+}
+
+type testFSECell struct {
+	symbol   int
+	bits     uint
+	baseline int
+}
+
+func newTestFSETable(norm []int16, log uint) *testFSETable {
+	size := 1 << log
+	cells := make([]testFSECell, size)
+	next := make([]int, len(norm))
+	high := size - 1
+	for s, n := range norm {
+		if n == -1 {
+			cells[high].symbol = s
+			high--
+			next[s] = 1
+		} else {
+			next[s] = int(n)
+		}
+	}
+	position, step := 0, size>>1+size>>3+3
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			cells[position].symbol = s
+			for position = (position + step) & (size - 1); position > high; position = (position + step) & (size - 1) {
+			}
+		}
+	}
+	for u := range cells {
+		s := cells[u].symbol
+		state := next[s]
+		next[s]++
+		cells[u].bits = log - (bitLength(uint64(state)) - 1)
+		cells[u].baseline = state<<cells[u].bits - size
+	}
+	return &testFSETable{log: log, cells: cells}
+}
+
+func (t *testFSETable) next(state uint32, r *testBackwardReader) uint32 {
+	cell := t.cells[state]
+	return uint32(cell.baseline) + r.bits(cell.bits)
+}
+
+// readTestFSEDescription reads a table description, RFC 8878 section
+// 4.1.1.
+func readTestFSEDescription(r *testBitReader) ([]int16, uint, error) {
+	logBits, err := r.bits(4)
+	if err != nil {
+		return nil, 0, err
+	}
+	log := uint(logBits) + 5
+	remaining, threshold, nbBits := 1<<log+1, 1<<log, log+1
+	var norm []int16
+	previousIs0 := false
+	for remaining > 1 {
+		if previousIs0 {
+			for {
+				flag, err := r.bits(2)
+				if err != nil {
+					return nil, 0, err
+				}
+				for i := 0; i < int(flag); i++ {
+					norm = append(norm, 0)
+				}
+				if flag != 3 {
+					break
+				}
+			}
+		}
+		max := 2*threshold - 1 - remaining
+		low, err := r.peek(nbBits - 1)
+		if err != nil {
+			low, err = r.peek(nbBits - 1)
+		}
+		var count int
+		if int(low)&(threshold-1) < max {
+			count = int(low) & (threshold - 1)
+			r.pos += int(nbBits - 1)
+		} else {
+			full, err := r.bits(nbBits)
+			if err != nil {
+				return nil, 0, err
+			}
+			count = int(full) & (2*threshold - 1)
+			if count >= threshold {
+				count -= max
+			}
+		}
+		count--
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+		previousIs0 = count == 0
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 {
+		return nil, 0, errors.New("table description doesn't add up")
+	}
+	return norm, log, nil
+}
+
+func TestZstdWriter_RoundTrip(t *testing.T) {
+	for name, content := range brotliTestInputs() {
+		for _, level := range []int{1, 3, 9, 19} {
+			t.Run(fmt.Sprintf("%s/%d", name, level), func(t *testing.T) {
+				var buf bytes.Buffer
+				w := newZstdWriter(&buf, level)
+				for i := 0; i < len(content); i += 10000 {
+					end := i + 10000
+					if end > len(content) {
+						end = len(content)
+					}
+					if _, err := io.WriteString(w, content[i:end]); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := decodeZstdToString(buf.Bytes())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if decoded != content {
+					t.Errorf("Expected the decompressed body to match, but got %d bytes of %d", len(decoded), len(content))
+				}
+				if len(content) > 1000 && buf.Len() > len(content)+len(content)/100 {
+					t.Errorf("Expected at most 1%% growth, but %d bytes became %d", len(content), buf.Len())
+				}
+			})
+		}
+	}
+}
+
+func TestZstdWriter_Compresses(t *testing.T) {
+	content := brotliTestInputs()["text"]
+	sizes := make(map[int]int)
+	for _, level := range []int{1, 19} {
+		var buf bytes.Buffer
+		w := newZstdWriter(&buf, level)
+		io.WriteString(w, content)
+		w.Close()
+		sizes[level] = buf.Len()
+	}
+	if sizes[1] > len(content)/3 {
+		t.Errorf("Expected text to shrink to under a third, but %d bytes became %d", len(content), sizes[1])
+	}
+	if sizes[19] >= sizes[1] {
+		t.Errorf("Expected level 19 to beat level 1, but got %d and %d", sizes[19], sizes[1])
+	}
+}
+
+func TestDecodeZstdToString_ReferenceFrame(t *testing.T) {
+	// Compressed by the reference zstd command line tool, with a repeat
+	// offset
+	frame := []byte{
+		0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x68, 0xc5, 0x00, 0x00, 0x90, 0x68, 0x65,
+		0x6c, 0x6c, 0x6f, 0x20, 0x7a, 0x73, 0x74, 0x64, 0x2c, 0x20, 0x61, 0x67,
+		0x61, 0x69, 0x6e, 0x0a, 0x01, 0x00, 0x17, 0x4b, 0x12,
+	}
+	decoded, err := decodeZstdToString(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "hello zstd, hello zstd, hello zstd, hello again\n"; decoded != expected {
+		t.Errorf("Expected %q, but got %q", expected, decoded)
+	}
+}
+
+func TestResponseWriter_Zstd(t *testing.T) {
+	response := serveTestResponse(t, HttpRequest{
+		Method:  GET,
+		Path:    "/echo/" + strings.Repeat("squeezed", 10),
+		Headers: Header{"Accept-Encoding": {"gzip;q=0.5, zstd"}},
+	})
+	if got := response.Headers.Get("Content-Encoding"); got != "zstd" {
+		t.Fatalf("Expected Content-Encoding zstd, but got %q", got)
+	}
+	body, err := decodeZstdToString(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != strings.Repeat("squeezed", 10) {
+		t.Errorf("Expected Body squeezed, but got %q", body)
+	}
+}